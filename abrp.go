@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reference: https://documenter.getpostman.com/view/7396339/SWTK5a8w
+
+var (
+	abrpTokenFlag  = flag.String("abrp-token", os.Getenv("ABRP_TOKEN"), "A Better Route Planner user token of the vehicle, from the Live Data settings of the car in ABRP, or vehicle=token,... for several vehicles; enables forwarding the telemetry to ABRP")
+	abrpAPIKeyFlag = flag.String("abrp-api-key", os.Getenv("ABRP_API_KEY"), "A Better Route Planner API key, as issued by Iternio for the app")
+	abrpURLFlag    = flag.String("abrp-url", "https://api.iternio.com/1/tlm/send", "A Better Route Planner telemetry API URL")
+)
+
+var abrpSends = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_abrp_sends_total",
+	Help: "Number of telemetry sends to A Better Route Planner, by result.",
+}, []string{"vehicle", "result"})
+
+// abrpTokens are the ABRP user tokens by vehicle.
+var abrpTokens map[string]string
+
+func setupABRP() error {
+	if *abrpTokenFlag == "" {
+		return nil
+	}
+	if *abrpAPIKeyFlag == "" {
+		return fmt.Errorf("-abrp-token needs -abrp-api-key")
+	}
+	abrpTokens = map[string]string{}
+	if !strings.Contains(*abrpTokenFlag, "=") {
+		ids, _ := parseVehicleIDs(*vehicleIDFlag)
+		if len(ids) != 1 {
+			return fmt.Errorf("-abrp-token needs vehicle=token pairs with %d vehicles", len(ids))
+		}
+		abrpTokens[ids[0]] = *abrpTokenFlag
+		return nil
+	}
+	for _, pair := range strings.Split(*abrpTokenFlag, ",") {
+		id, token, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || id == "" || token == "" {
+			return fmt.Errorf("invalid -abrp-token entry, expected vehicle=token")
+		}
+		abrpTokens[id] = token
+	}
+	return nil
+}
+
+// abrpTelemetry is the telemetry of a vehicle in the ABRP format. Only the
+// known values are sent.
+type abrpTelemetry struct {
+	UTC        int64    `json:"utc"`
+	SOC        *float64 `json:"soc,omitempty"`
+	Power      *float64 `json:"power,omitempty"`
+	Speed      *float64 `json:"speed,omitempty"`
+	Lat        *float64 `json:"lat,omitempty"`
+	Lon        *float64 `json:"lon,omitempty"`
+	Elevation  *float64 `json:"elevation,omitempty"`
+	IsCharging int      `json:"is_charging"`
+	IsDCFC     int      `json:"is_dcfc"`
+	IsParked   int      `json:"is_parked"`
+	ExtTemp    *float64 `json:"ext_temp,omitempty"`
+	BattTemp   *float64 `json:"batt_temp,omitempty"`
+	SOH        *float64 `json:"soh,omitempty"`
+	Odometer   *float64 `json:"odometer,omitempty"`
+}
+
+// abrpTelemetry returns the latest telemetry of the vehicle.
+func (v *vehicle) abrpTelemetry(at time.Time) abrpTelemetry {
+	t := abrpTelemetry{
+		UTC:       at.Unix(),
+		SOC:       v.floatField("S", "ms_v_bat_soc"),
+		Power:     v.floatField("L", "ms_v_bat_power"),
+		Speed:     v.floatField("L", "ms_v_pos_speed"),
+		Lat:       v.floatField("L", "ms_v_pos_latitude"),
+		Lon:       v.floatField("L", "ms_v_pos_longitude"),
+		Elevation: v.floatField("L", "ms_v_pos_altitude"),
+		ExtTemp:   v.floatField("D", "ms_v_env_temp"),
+		BattTemp:  v.floatField("D", "ms_v_bat_temp"),
+		SOH:       v.floatField("S", "ms_v_bat_soh"),
+	}
+	if t.Speed != nil && v.field("S", "m_units_distance") == "M" {
+		*t.Speed *= kmPerMile
+	}
+	if km := v.odometerKm(); km > 0 {
+		t.Odometer = &km
+	}
+	if rec, ok := v.records.get("S"); ok && isCharging(rec.fields["ms_v_charge_state"]) {
+		t.IsCharging = 1
+		if isDCCharge(rec.fields) {
+			t.IsDCFC = 1
+		}
+	}
+	if t.Speed == nil || *t.Speed == 0 {
+		t.IsParked = 1
+	}
+	return t
+}
+
+// abrpSender forwards the telemetry of the vehicles with new records.
+type abrpSender struct {
+	mu sync.Mutex
+	// sent is the time of the latest record sent by vehicle.
+	sent map[string]time.Time
+}
+
+var abrp abrpSender
+
+// send forwards the telemetry of the vehicles with records newer than the
+// ones already sent.
+func (s *abrpSender) send() {
+	if abrpTokens == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sent == nil {
+		s.sent = map[string]time.Time{}
+	}
+	for _, v := range vehicles {
+		token, ok := abrpTokens[v.id]
+		if !ok {
+			continue
+		}
+		last := v.records.lastMessage()
+		if last.IsZero() || !last.After(s.sent[v.id]) {
+			continue
+		}
+		if err := sendABRP(token, v.abrpTelemetry(last)); err != nil {
+			abrpSends.WithLabelValues(v.id, "error").Inc()
+			slog.Error("error sending the telemetry to ABRP", "vehicle", v.id, "err", err)
+			continue
+		}
+		abrpSends.WithLabelValues(v.id, "ok").Inc()
+		s.sent[v.id] = last
+	}
+}
+
+// sendABRP sends a telemetry to ABRP.
+func sendABRP(token string, t abrpTelemetry) error {
+	tlm, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	form := url.Values{"token": {token}, "tlm": {string(tlm)}}
+	req, err := http.NewRequest(http.MethodPost, *abrpURLFlag, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "APIKEY "+*abrpAPIKeyFlag)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var result struct {
+		Status  string `json:"status"`
+		Missing string `json:"missing"`
+	}
+	if resp.StatusCode != http.StatusOK || json.Unmarshal(body, &result) != nil || result.Status != "ok" {
+		return fmt.Errorf("ABRP: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
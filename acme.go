@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	acmeDomainsFlag   = flag.String("acme-domains", "", "Comma-separated domains to get certificates for via ACME; enables HTTPS on -addr and -public-addr")
+	acmeEmailFlag     = flag.String("acme-email", "", "Contact email of the ACME account")
+	acmeDirectoryFlag = flag.String("acme-directory", autocert.DefaultACMEDirectory, "ACME directory URL")
+	acmeCacheDirFlag  = flag.String("acme-cache-dir", "", "Directory where the ACME account and certificates are kept; defaults to <state-dir>/acme")
+	acmeHTTPAddrFlag  = flag.String("acme-http-addr", "", "Address answering the HTTP-01 challenges, e.g. :80; without it only TLS-ALPN-01 on -addr is used")
+)
+
+// certManager provisions and renews the certificates, nil without
+// -acme-domains.
+var certManager *autocert.Manager
+
+func setupACME() error {
+	if *acmeDomainsFlag == "" {
+		return nil
+	}
+	var domains []string
+	for _, d := range strings.Split(*acmeDomainsFlag, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	cacheDir := *acmeCacheDirFlag
+	if cacheDir == "" {
+		if *stateDirFlag == "" {
+			return fmt.Errorf("-acme-cache-dir or -state-dir is required")
+		}
+		cacheDir = filepath.Join(*stateDirFlag, "acme")
+	}
+	certManager = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cacheDir),
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Email:      *acmeEmailFlag,
+		Client:     &acme.Client{DirectoryURL: *acmeDirectoryFlag},
+	}
+	if *acmeHTTPAddrFlag != "" {
+		ln, err := listen(*acmeHTTPAddrFlag)
+		if err != nil {
+			return err
+		}
+		slog.Info("listening for ACME HTTP-01 challenges", "addr", *acmeHTTPAddrFlag)
+		go func() {
+			// Other requests are redirected to HTTPS.
+			fatal("ACME http server failed", http.Serve(ln, certManager.HTTPHandler(nil)))
+		}()
+	}
+	return nil
+}
+
+// serve serves srv on ln, over TLS with the ACME certificates if enabled.
+func serve(srv *http.Server, ln net.Listener) error {
+	if certManager == nil {
+		return srv.Serve(ln)
+	}
+	srv.TLSConfig = certManager.TLSConfig()
+	srv.TLSConfig.MinVersion = tls.VersionTLS12
+	return srv.ServeTLS(ln, "", "")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reference: https://github.com/prometheus/alertmanager/blob/main/api/v2/openapi.yaml
+
+var (
+	alertmanagerURLFlag   = flag.String("alertmanager-url", "", "Alertmanager URL, e.g. http://localhost:9093, to which the alerts of the offline vehicles and of the stalled charges are sent; empty disables it")
+	alertOfflineAfterFlag = flag.Duration("alert-offline-after", time.Hour, "Time without a new record after which the VehicleOffline alert fires")
+	alertChargeStallFlag  = flag.Duration("alert-charge-stall-after", 30*time.Minute, "Time charging without the SOC increasing after which the ChargingStalled alert fires")
+)
+
+var alertmanagerFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ovms_alertmanager_send_failures_total",
+	Help: "Number of failed sends to -alertmanager-url.",
+})
+
+// Alert names.
+const (
+	alertVehicleOffline  = "VehicleOffline"
+	alertChargingStalled = "ChargingStalled"
+)
+
+// amAlert is an alert of the Alertmanager API.
+type amAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+}
+
+// alertTracker evaluates the alert conditions of a vehicle.
+type alertTracker struct {
+	mu sync.Mutex
+	// firing are the firing alerts by name.
+	firing map[string]*amAlert
+	// stallSOC is the SOC of the ongoing charge when it last increased, at
+	// stallSince.
+	stallSOC   float64
+	stallSince time.Time
+}
+
+// evaluateAlerts updates the firing alerts of the vehicle and returns the
+// alerts to send: the firing ones and those resolved now.
+func (v *vehicle) evaluateAlerts(now time.Time) []amAlert {
+	a := &v.alerts
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.firing == nil {
+		a.firing = map[string]*amAlert{}
+	}
+	conditions := map[string]string{}
+
+	if last := v.records.lastMessage(); !last.IsZero() && now.Sub(last) > *alertOfflineAfterFlag {
+		conditions[alertVehicleOffline] = fmt.Sprintf("No data from vehicle %s since %s.", v.id, last.UTC().Format(time.RFC3339))
+	}
+
+	soc, err := strconv.ParseFloat(v.field("S", "ms_v_bat_soc"), 64)
+	if v.state() != stateCharging || err != nil {
+		a.stallSince = time.Time{}
+	} else if a.stallSince.IsZero() || soc > a.stallSOC {
+		a.stallSOC, a.stallSince = soc, now
+	} else if now.Sub(a.stallSince) > *alertChargeStallFlag {
+		conditions[alertChargingStalled] = fmt.Sprintf("Vehicle %s is charging but its SOC is stuck at %g%% since %s.", v.id, soc, a.stallSince.UTC().Format(time.RFC3339))
+	}
+
+	var alerts []amAlert
+	for name, summary := range conditions {
+		alert, ok := a.firing[name]
+		if !ok {
+			alert = &amAlert{
+				Labels:   map[string]string{"alertname": name, "vehicle": v.id, "severity": "warning"},
+				StartsAt: now,
+			}
+			a.firing[name] = alert
+			slog.Info("alert firing", "vehicle", v.id, "alert", name)
+		}
+		alert.Annotations = map[string]string{"summary": summary}
+		alerts = append(alerts, *alert)
+	}
+	for name, alert := range a.firing {
+		if _, ok := conditions[name]; ok {
+			continue
+		}
+		alert.EndsAt = &now
+		alerts = append(alerts, *alert)
+		delete(a.firing, name)
+		slog.Info("alert resolved", "vehicle", v.id, "alert", name)
+	}
+	return alerts
+}
+
+// sendAlerts evaluates the alerts of the vehicles and sends them to the
+// Alertmanager. The firing alerts are sent again every time, for the
+// Alertmanager not to resolve them.
+func sendAlerts() {
+	if *alertmanagerURLFlag == "" {
+		return
+	}
+	now := time.Now()
+	var alerts []amAlert
+	for _, v := range vehicles {
+		alerts = append(alerts, v.evaluateAlerts(now)...)
+	}
+	if len(alerts) == 0 {
+		return
+	}
+	if err := postAlerts(*alertmanagerURLFlag, alerts); err != nil {
+		alertmanagerFailures.Inc()
+		slog.Error("error sending the alerts", "url", redactURL(*alertmanagerURLFlag), "err", err)
+	}
+}
+
+func postAlerts(baseURL string, alerts []amAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(strings.TrimSuffix(baseURL, "/")+"/api/v2/alerts", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
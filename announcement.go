@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxAnnouncementLen caps the announcement text kept in the metric label.
+const maxAnnouncementLen = 200
+
+var announcementInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_server_announcement_info",
+	Help: "Maintenance or announcement message currently reported by the OVMS server.",
+}, []string{"message"})
+
+var (
+	announcementMu sync.Mutex
+	announcement   string
+)
+
+// setAnnouncement records a maintenance/announcement message from the OVMS
+// server. The notification is only sent when the message changes.
+func setAnnouncement(body string) {
+	// The label values must be valid UTF-8, whatever the server sent.
+	msg := strings.TrimSpace(strings.ToValidUTF8(body, "\uFFFD"))
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = strings.TrimSpace(msg[:i])
+	}
+	msg = truncateUTF8(msg, maxAnnouncementLen)
+	if msg == "" {
+		msg = "maintenance"
+	}
+
+	announcementMu.Lock()
+	defer announcementMu.Unlock()
+	if msg == announcement {
+		return
+	}
+	announcement = msg
+	announcementInfo.Reset()
+	announcementInfo.WithLabelValues(msg).Set(1)
+	notify("OVMS server announcement: %s", msg)
+}
+
+// truncateUTF8 returns at most the first n bytes of s, without splitting a
+// rune.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// clearAnnouncement is called after a successful fetch.
+func clearAnnouncement() {
+	announcementMu.Lock()
+	defer announcementMu.Unlock()
+	if announcement == "" {
+		return
+	}
+	announcement = ""
+	announcementInfo.Reset()
+	notify("OVMS server is back to normal operation")
+}
+
+// notify surfaces an event that a human should know about.
+func notify(format string, args ...interface{}) {
+	slog.Warn("notification", "text", fmt.Sprintf(format, args...))
+}
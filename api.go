@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// routeVehicleAPI serves the commands, with their own token, and the rest of
+// /api/v1/vehicles/ with the usual authentication.
+func routeVehicleAPI(w http.ResponseWriter, r *http.Request) {
+	if id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/vehicles/"), "/command"); ok && !strings.Contains(id, "/") {
+		handleCommand(w, r, id)
+		return
+	}
+	authenticated(handleVehicleAPI)(w, r)
+}
+
+// handleVehicleAPI dispatches /api/v1/vehicles/<id>/<resource>.
+func handleVehicleAPI(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/vehicles/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	v := findVehicle(parts[0])
+	if v == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+	switch parts[1] {
+	case "data":
+		handleVehicleData(w, r, v)
+	case "predict":
+		handlePredict(w, r, v)
+	case "metrics":
+		handleVehicleMetrics(w, r, v)
+	case "charge-curve":
+		handleChargeCurve(w, r, v)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Error("error writing the JSON response", "err", err)
+	}
+}
+
+// vehicleData is everything the exporter stores about a vehicle.
+type vehicleData struct {
+	Vehicle     string           `json:"vehicle"`
+	Degradation []degradationDay `json:"degradation"`
+	Tires       tireSnapshot     `json:"tires"`
+	Mileage     []mileageEntry   `json:"mileage"`
+	Utilization []utilizationDay `json:"utilization"`
+}
+
+// handleVehicleData exports (GET) or erases (DELETE) all the data stored
+// about a vehicle, e.g. when it is sold. Both require the -admin-token.
+func handleVehicleData(w http.ResponseWriter, r *http.Request, v *vehicle) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		mileage, _ := v.mileage.snapshot()
+		writeJSON(w, vehicleData{
+			Vehicle:     v.id,
+			Degradation: v.degradation.snapshot(),
+			Tires:       v.tires.snapshot(),
+			Mileage:     mileage,
+			Utilization: v.utilization.report(),
+		})
+	case http.MethodDelete:
+		if err := v.purge(); err != nil {
+			slog.Error("error purging vehicle data", "vehicle", v.id, "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("purged vehicle data", "vehicle", v.id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiRecord is a parsed record. The numeric fields, including the booleans
+// normalized to 0/1, are numbers, the others strings.
+type apiRecord struct {
+	Code   string                 `json:"code"`
+	Time   time.Time              `json:"time"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+// handleVehicleMetrics serves the latest parsed record of each code.
+func handleVehicleMetrics(w http.ResponseWriter, r *http.Request, v *vehicle) {
+	records := []apiRecord{}
+	for code, rec := range v.records.snapshot() {
+		fields := map[string]interface{}{}
+		for name, val := range rec.fields {
+			if f, err := strconv.ParseFloat(val, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+				fields[name] = f
+			} else {
+				fields[name] = val
+			}
+		}
+		records = append(records, apiRecord{Code: code, Time: rec.ts, Fields: fields})
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Code < records[j].Code })
+	writeShapedJSON(w, r, struct {
+		Vehicle string      `json:"vehicle"`
+		Records []apiRecord `json:"records"`
+	}{v.id, records})
+}
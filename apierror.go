@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var apiErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_api_errors_total",
+	Help: "Error responses of the OVMS server API in place of the records, by reason: unauthorized, unknown_vehicle, server_error, empty or unexpected.",
+}, []string{"vehicle", "reason"})
+
+// maxAPIErrorBody caps how much of an error response is read.
+const maxAPIErrorBody = 4096
+
+// maxAPIErrorLen caps the message of an error response that is logged.
+const maxAPIErrorLen = 200
+
+// apiError is an error response of the OVMS server API, in plain text or as
+// a JSON object, e.g. for a wrong password or an unknown vehicle.
+type apiError struct {
+	reason  string
+	message string
+}
+
+// parseAPIError classifies an error response by its status and message.
+func parseAPIError(status int, body []byte) apiError {
+	msg := strings.TrimSpace(strings.ToValidUTF8(string(body), "\uFFFD"))
+	var obj map[string]interface{}
+	if json.Unmarshal(body, &obj) == nil {
+		msg = ""
+		for _, k := range []string{"error", "message", "msg", "detail"} {
+			if s, ok := obj[k].(string); ok && s != "" {
+				msg = s
+				break
+			}
+		}
+	}
+	if i := strings.IndexByte(msg, '\n'); i >= 0 {
+		msg = strings.TrimSpace(msg[:i])
+	}
+	msg = truncateUTF8(msg, maxAPIErrorLen)
+
+	lower := strings.ToLower(msg)
+	reason := "unexpected"
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden ||
+		strings.Contains(lower, "password") || strings.Contains(lower, "unauthori") || strings.Contains(lower, "authenticat"):
+		reason = "unauthorized"
+	case status == http.StatusNotFound ||
+		strings.Contains(lower, "unknown vehicle") || strings.Contains(lower, "vehicle not found") || strings.Contains(lower, "no such vehicle"):
+		reason = "unknown_vehicle"
+	case status >= 500:
+		reason = "server_error"
+	case len(body) == 0:
+		reason = "empty"
+	}
+	return apiError{reason, msg}
+}
+
+// readAPIError reads and classifies the error response of a vehicle, and
+// counts it.
+func readAPIError(vehicleID string, status int, r io.Reader) apiError {
+	body, _ := io.ReadAll(io.LimitReader(r, maxAPIErrorBody))
+	e := parseAPIError(status, body)
+	apiErrorsTotal.WithLabelValues(vehicleID, e.reason).Inc()
+	return e
+}
+
+// isJSONArray reports whether the next non-whitespace byte of r starts a
+// JSON array, without consuming it.
+func isJSONArray(r *bufio.Reader) bool {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return false
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0] == '['
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	archiveDirFlag      = flag.String("archive-dir", "", "Directory where the parsed records are appended to daily CSV files per vehicle and code, for offline analysis; empty disables it")
+	archiveCompressFlag = flag.Bool("archive-compress", true, "Compress the CSV files of -archive-dir with gzip once their day is over")
+)
+
+// The archive files are <dir>/<vehicle>/<code>/<YYYY-MM-DD>.csv, by UTC day
+// of the record, with the time, the vehicle and the fields of the code as
+// columns, e.g. for DuckDB: SELECT * FROM read_csv('<dir>/*/S/*.csv*').
+const archiveExt = ".csv"
+
+// archiveWriter appends the parsed records of a vehicle to its daily CSV
+// files. A record is only appended once, when its message time changes.
+type archiveWriter struct {
+	vehicle string
+
+	mu    sync.Mutex
+	files map[string]*archiveFile
+}
+
+// archiveFile is the file of the current day of a code.
+type archiveFile struct {
+	day  string
+	f    *os.File
+	last time.Time
+}
+
+// archiveColumns returns the field columns of a code, the repeated field
+// names only once.
+func archiveColumns(code string) []string {
+	var cols []string
+	for _, name := range metricsMap[code] {
+		if !contains(cols, name) {
+			cols = append(cols, name)
+		}
+	}
+	return cols
+}
+
+// append writes the fields of a record to the file of its day.
+func (a *archiveWriter) append(code string, fields map[string]string, ts time.Time) {
+	if *archiveDirFlag == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.files == nil {
+		a.files = map[string]*archiveFile{}
+	}
+	af, ok := a.files[code]
+	if !ok {
+		af = &archiveFile{}
+		a.files[code] = af
+	}
+	if !ts.After(af.last) {
+		return
+	}
+	ts = ts.UTC()
+	cols := archiveColumns(code)
+	day := ts.Format(recordLogDayLayout)
+	if af.f == nil || day != af.day {
+		if err := af.open(filepath.Join(*archiveDirFlag, a.vehicle, code), day, cols); err != nil {
+			slog.Error("error opening the archive file", "vehicle", a.vehicle, "code", code, "err", err)
+			return
+		}
+	}
+
+	row := []string{ts.Format(time.RFC3339), a.vehicle}
+	for _, name := range cols {
+		row = append(row, fields[name])
+	}
+	w := csv.NewWriter(af.f)
+	w.Write(row)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		slog.Error("error writing the archive file", "vehicle", a.vehicle, "code", code, "err", err)
+		return
+	}
+	af.last = ts
+}
+
+// open closes the file of the previous day, if any, and opens the one of the
+// day, writing the header if it is new, otherwise reading the time of its
+// last row. The files of the previous days left uncompressed are compressed
+// in the background.
+func (af *archiveFile) open(dir, day string, cols []string) error {
+	if af.f != nil {
+		af.f.Close()
+		af.f = nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, day+archiveExt), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if st.Size() > 0 && af.last.IsZero() {
+		// Restarted, the records polled again are not appended twice.
+		af.last = lastArchiveTime(f.Name())
+	}
+	if st.Size() == 0 {
+		w := csv.NewWriter(f)
+		w.Write(append([]string{"time", "vehicle"}, cols...))
+		w.Flush()
+		if err := w.Error(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	af.f, af.day = f, day
+	if *archiveCompressFlag {
+		go compressArchive(dir, day)
+	}
+	return nil
+}
+
+// lastArchiveTime returns the time of the last row of a CSV file, zero if
+// none.
+func lastArchiveTime(name string) time.Time {
+	f, err := os.Open(name)
+	if err != nil {
+		return time.Time{}
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	var last time.Time
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return last
+		}
+		if _, ok := err.(*csv.ParseError); ok {
+			continue
+		}
+		if err != nil {
+			return last
+		}
+		if ts, err := time.Parse(time.RFC3339, row[0]); err == nil {
+			last = ts
+		}
+	}
+}
+
+// compressArchive gzips the CSV files of a directory older than a day.
+func compressArchive(dir, day string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Error("error listing the archive", "dir", dir, "err", err)
+		return
+	}
+	for _, e := range entries {
+		d, ok := strings.CutSuffix(e.Name(), archiveExt)
+		if !ok || e.IsDir() || d >= day {
+			continue
+		}
+		name := filepath.Join(dir, e.Name())
+		if err := gzipFile(name); err != nil {
+			slog.Error("error compressing the archive file", "file", name, "err", err)
+			continue
+		}
+		slog.Info("compressed the archive file", "file", name+".gz")
+	}
+}
+
+// gzipFile replaces a file by its gzip-compressed version, with the .gz
+// extension added.
+func gzipFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	gz := gzip.NewWriter(dst)
+	gz.Name = filepath.Base(name)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(dst.Name(), name+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var adminTokenFlag = flag.String("admin-token", os.Getenv("OVMS_EXPORTER_ADMIN_TOKEN"), "Bearer token required by the administrative endpoints; empty disables them")
+
+// checkBearer reports whether the request carries the bearer token.
+func checkBearer(r *http.Request, token string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// requireAdmin rejects the requests without the -admin-token.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if *adminTokenFlag == "" {
+		http.Error(w, "administrative endpoints are disabled, see -admin-token", http.StatusForbidden)
+		return false
+	}
+	if !checkBearer(r, *adminTokenFlag) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// bearerToken returns the token of the Authorization header, or of the
+// access_token cookie for the browsers, e.g. for /events.
+func bearerToken(r *http.Request) string {
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return t
+	}
+	if c, err := r.Cookie("access_token"); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// authenticated requires a valid OIDC token, or the -admin-token, when
+// -oidc-issuer is set.
+func authenticated(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if oidc == nil || checkBearer(r, *adminTokenFlag) {
+			h(w, r)
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		sub, err := oidc.verify(token)
+		if err != nil {
+			slog.Debug("invalid OIDC token", "path", r.URL.Path, "err", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		slog.Debug("authenticated", "path", r.URL.Path, "sub", sub)
+		h(w, r)
+	}
+}
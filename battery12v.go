@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	battery12VDropFlag       = flag.Float64("12v-alert-drop", 1.6, "Drop of the 12V battery voltage below its reference voltage, in V, raising ovms_12v_battery_alert, as the vehicle.12v.alert setting of the module")
+	battery12VSagVoltageFlag = flag.Float64("12v-sag-voltage", 11.5, "12V battery voltage, in V, under which a sag within -12v-sag-window raises ovms_12v_battery_alert; 0 disables it")
+	battery12VSagWindowFlag  = flag.Duration("12v-sag-window", 24*time.Hour, "How long a sag of the 12V battery voltage keeps ovms_12v_battery_alert raised")
+	battery12VDrainFlag      = flag.Float64("12v-drain-current", 1, "Current drawn from the 12V battery while parked, in A, raising ovms_12v_battery_alert after -12v-drain-after; 0 disables it")
+	battery12VDrainAfterFlag = flag.Duration("12v-drain-after", 2*time.Hour, "How long the -12v-drain-current must be drawn while parked to raise ovms_12v_battery_alert")
+)
+
+// event12VAlert is raised when ovms_12v_battery_alert becomes 1.
+const event12VAlert = "battery_12v_alert"
+
+var (
+	battery12VAlert = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_12v_battery_alert",
+		Help: "Whether the 12V battery needs attention: its voltage is too low compared to its reference, it sagged recently or it is being drained while parked.",
+	}, []string{"vehicle"})
+	battery12VMinVoltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_12v_battery_min_voltage",
+		Help: "Lowest 12V battery voltage within -12v-sag-window, in V.",
+	}, []string{"vehicle"})
+)
+
+type voltageSample struct {
+	ts      time.Time
+	voltage float64
+}
+
+// battery12VTracker evaluates the health of the 12V battery from the D
+// records.
+type battery12VTracker struct {
+	vehicle string
+	// onEvent, if set, is called when the alert is raised.
+	onEvent func(event string)
+
+	mu sync.Mutex
+	// samples are the voltages within -12v-sag-window, oldest first.
+	samples []voltageSample
+	// drainSince is when the drain while parked started, zero if none.
+	drainSince time.Time
+	alert      bool
+}
+
+// update processes the fields of a D record.
+func (b *battery12VTracker) update(fields map[string]string, ts time.Time, parked bool) {
+	voltage, err := strconv.ParseFloat(fields["ms_v_bat_12v_voltage"], 64)
+	if err != nil || voltage <= 0 {
+		return
+	}
+	ref, _ := strconv.ParseFloat(fields["ms_v_bat_12v_voltage_ref"], 64)
+	current, err := strconv.ParseFloat(fields["ms_v_bat_12v_current"], 64)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := len(b.samples); n > 0 && !ts.After(b.samples[n-1].ts) {
+		return
+	}
+	b.samples = append(b.samples, voltageSample{ts, voltage})
+	cutoff := ts.Add(-*battery12VSagWindowFlag)
+	i := 0
+	for i < len(b.samples) && b.samples[i].ts.Before(cutoff) {
+		i++
+	}
+	b.samples = append(b.samples[:0], b.samples[i:]...)
+	lowest := voltage
+	for _, s := range b.samples {
+		lowest = min(lowest, s.voltage)
+	}
+	battery12VMinVoltage.WithLabelValues(b.vehicle).Set(lowest)
+
+	if err == nil && parked && *battery12VDrainFlag > 0 && current >= *battery12VDrainFlag {
+		if b.drainSince.IsZero() {
+			b.drainSince = ts
+		}
+	} else {
+		b.drainSince = time.Time{}
+	}
+
+	var reasons []string
+	if ref > 0 && voltage < ref-*battery12VDropFlag {
+		reasons = append(reasons, "low")
+	}
+	if *battery12VSagVoltageFlag > 0 && lowest < *battery12VSagVoltageFlag {
+		reasons = append(reasons, "sag")
+	}
+	if !b.drainSince.IsZero() && ts.Sub(b.drainSince) >= *battery12VDrainAfterFlag {
+		reasons = append(reasons, "drain")
+	}
+	alert := len(reasons) > 0
+	if alert != b.alert {
+		if alert {
+			slog.Warn("12V battery alert", "vehicle", b.vehicle, "reasons", strings.Join(reasons, ","), "voltage", voltage, "ref", ref, "min", lowest, "current", current)
+			if b.onEvent != nil {
+				b.onEvent(event12VAlert)
+			}
+		} else {
+			slog.Info("12V battery alert cleared", "vehicle", b.vehicle, "voltage", voltage)
+		}
+		b.alert = alert
+	}
+	val := 0.0
+	if alert {
+		val = 1
+	}
+	battery12VAlert.WithLabelValues(b.vehicle).Set(val)
+}
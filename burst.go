@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Events that can trigger a burst.
+const (
+	eventChargeStart            = "charge_start"
+	eventTripStart              = "trip_start"
+	eventChargePortWithoutCable = "charge_port_without_cable"
+	eventOpenWhileMoving        = "open_while_moving"
+)
+
+var burstEvents = []string{eventChargeStart, eventTripStart, eventChargePortWithoutCable, eventOpenWhileMoving}
+
+var (
+	burstsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_bursts_total",
+		Help: "Number of burst captures started, by trigger.",
+	}, []string{"vehicle", "trigger"})
+	burstActive = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_burst_active",
+		Help: "1 while a burst capture is running.",
+	}, []string{"vehicle"})
+)
+
+// burstConfig switches to a short poll interval for a while after one of the
+// trigger events.
+type burstConfig struct {
+	Triggers     []string `json:"triggers"`
+	Duration     string   `json:"duration"`
+	PollInterval string   `json:"poll_interval"`
+
+	duration     time.Duration
+	pollInterval time.Duration
+}
+
+func (b *burstConfig) validate() error {
+	for _, t := range b.Triggers {
+		if !contains(burstEvents, t) {
+			return fmt.Errorf("burst: unknown trigger %q, expected one of %v", t, burstEvents)
+		}
+	}
+	var err error
+	if b.duration, err = time.ParseDuration(b.Duration); err != nil || b.duration <= 0 {
+		return fmt.Errorf("burst: invalid duration %q", b.Duration)
+	}
+	if b.pollInterval, err = time.ParseDuration(b.PollInterval); err != nil || b.pollInterval <= 0 {
+		return fmt.Errorf("burst: invalid poll_interval %q", b.PollInterval)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// burstTracker tracks the burst of one vehicle. The samples fetched during a
+// burst are appended to a separate file in -state-dir.
+type burstTracker struct {
+	vehicle string
+
+	mu    sync.Mutex
+	start time.Time
+	until time.Time
+}
+
+// trigger starts or extends a burst if event is a configured trigger.
+func (b *burstTracker) trigger(event string) {
+	c := cfg().Burst
+	if c == nil || !contains(c.Triggers, event) {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.After(b.until) {
+		b.start = now
+		burstsTotal.WithLabelValues(b.vehicle, event).Inc()
+		burstActive.WithLabelValues(b.vehicle).Set(1)
+		slog.Info("burst started", "vehicle", b.vehicle, "trigger", event, "duration", c.duration)
+	}
+	b.until = now.Add(c.duration)
+}
+
+// active reports whether a burst is running.
+func (b *burstTracker) active(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.start.IsZero() {
+		return false
+	}
+	if now.After(b.until) {
+		slog.Info("burst ended", "vehicle", b.vehicle)
+		burstActive.WithLabelValues(b.vehicle).Set(0)
+		b.start = time.Time{}
+		return false
+	}
+	return true
+}
+
+// record appends the samples of a fetch to the file of the running burst.
+func (b *burstTracker) record(samples string) {
+	if *stateDirFlag == "" || !b.active(time.Now()) {
+		return
+	}
+	b.mu.Lock()
+	name := fmt.Sprintf("burst_%s_%s.prom", b.vehicle, b.start.UTC().Format("20060102T150405Z"))
+	b.mu.Unlock()
+	if err := os.MkdirAll(*stateDirFlag, 0o755); err != nil {
+		slog.Error("error writing the burst", "vehicle", b.vehicle, "err", err)
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(*stateDirFlag, name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Error("error writing the burst", "vehicle", b.vehicle, "err", err)
+		return
+	}
+	_, err = f.WriteString(samples)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		slog.Error("error writing the burst", "vehicle", b.vehicle, "err", err)
+	}
+}
+
+// pollInterval returns the time to wait before the next poll: the burst poll
+// interval while any vehicle is in a burst, -poll-duration otherwise.
+func pollInterval() time.Duration {
+	c := cfg().Burst
+	if c == nil {
+		return *pollDurationFlag
+	}
+	now := time.Now()
+	for _, v := range vehicles {
+		if v.burst.active(now) && c.pollInterval < *pollDurationFlag {
+			return c.pollInterval
+		}
+	}
+	return *pollDurationFlag
+}
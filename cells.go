@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var batteryCellsFlag = flag.Bool("battery-cells", false, "Fetch the extended battery records of the vehicle type, e.g. RT-BAT-C and RT-BAT-M of the Twizy, from the historical API of the server each poll for the per-cell metrics; they are also taken from the -stream connection")
+
+// The extended battery records are historical records sent by the battery
+// monitor of the Renault Twizy, see vehicleDecoders, one per cell or module,
+// the record number being the cell or the module number:
+//
+//	RT-BAT-C,<cell>,<lifetime>,<volt_act>,<volt_min>,<volt_max>,<volt_maxdev>,<alert>
+//	RT-BAT-M,<module>,<lifetime>,<temp_act>,<temp_min>,<temp_max>,<temp_maxdev>,<alert>
+//
+// Only the actual values are exported.
+const (
+	cellVoltageRecord       = "RT-BAT-C"
+	moduleTemperatureRecord = "RT-BAT-M"
+)
+
+// historicalCode is the code of the historical records of the stream
+// connection: H<type>,<record number>,<lifetime>,<data>.
+const historicalCode = "H"
+
+var (
+	cellVoltage = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_bat_cell_voltage",
+		Help: "Voltage of a battery cell in V, from the RT-BAT-C records.",
+	}, []string{"vehicle", "cell"})
+	cellVoltageSpread = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_bat_cell_voltage_spread",
+		Help: "Difference between the highest and the lowest cell voltage in V, a measure of the cell imbalance.",
+	}, []string{"vehicle"})
+	moduleTemperature = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_bat_module_temperature",
+		Help: "Temperature of a battery module in °C, from the RT-BAT-M records.",
+	}, []string{"vehicle", "module"})
+)
+
+// historicalRecord is a record of the historical API of the server.
+type historicalRecord struct {
+	Timestamp    string      `json:"h_timestamp"`
+	RecordNumber json.Number `json:"h_recordnumber"`
+	Data         string      `json:"h_data"`
+}
+
+// fetchHistorical returns the historical records of a type.
+func fetchHistorical(vehicleID, recordType string) ([]historicalRecord, error) {
+	p := vehicleServer(vehicleID)
+	u := fmt.Sprintf("http://%s/api/historical/%s/%s", p.currentAddr(), vehicleID, url.PathEscape(recordType))
+	resp, err := p.get(u + "?" + vehicleAuthQuery(vehicleID).Encode())
+	if err != nil {
+		return nil, withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		e := readAPIError(vehicleID, resp.StatusCode, resp.Body)
+		return nil, fmt.Errorf("%s (%s): %s", resp.Status, e.reason, e.message)
+	}
+	var recs []historicalRecord
+	if err := json.NewDecoder(&maxBytesReader{r: resp.Body, n: *maxResponseFlag}).Decode(&recs); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// cellTracker holds the latest cell voltages of a vehicle.
+type cellTracker struct {
+	vehicle string
+
+	mu       sync.Mutex
+	voltages map[int]float64
+	// seen are the times of the latest records by type and record number.
+	seen map[string]time.Time
+}
+
+// update processes the data of an extended battery record, ignoring the
+// records of the other types and those older than the processed ones.
+func (c *cellTracker) update(recordType string, number int, data string, ts time.Time) {
+	var gauge *prometheus.GaugeVec
+	switch recordType {
+	case cellVoltageRecord:
+		gauge = cellVoltage
+	case moduleTemperatureRecord:
+		gauge = moduleTemperature
+	default:
+		return
+	}
+	actual, _, _ := strings.Cut(data, ",")
+	val, err := strconv.ParseFloat(actual, 64)
+	if err != nil {
+		slog.Warn("ignoring an invalid extended battery record", "vehicle", c.vehicle, "type", recordType, "record", number, "data", data)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := fmt.Sprintf("%s/%d", recordType, number)
+	if c.seen == nil {
+		c.seen = map[string]time.Time{}
+		c.voltages = map[int]float64{}
+	}
+	if ts.Before(c.seen[key]) {
+		return
+	}
+	c.seen[key] = ts
+	if recordType == cellVoltageRecord {
+		// Converted from mV, as some firmware versions send them.
+		if val > 100 {
+			val /= 1000
+		}
+		c.voltages[number] = val
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, v := range c.voltages {
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+		cellVoltageSpread.WithLabelValues(c.vehicle).Set(hi - lo)
+	}
+	gauge.WithLabelValues(c.vehicle, strconv.Itoa(number)).Set(val)
+}
+
+// historicalMessage processes a historical record of the stream connection
+// with the decoder of the vehicle type.
+func (v *vehicle) historicalMessage(payload string, ts time.Time) {
+	f := strings.SplitN(payload, ",", 4)
+	if len(f) < 4 {
+		return
+	}
+	d := v.decoder()
+	if d == nil || d.historical[f[0]] == nil {
+		return
+	}
+	if number, err := strconv.Atoi(f[1]); err == nil {
+		d.historical[f[0]](v, number, f[3], ts)
+	}
+}
+
+// fetchCells polls the historical records of the decoder of the vehicle type
+// with -battery-cells.
+func (v *vehicle) fetchCells() {
+	if !*batteryCellsFlag {
+		return
+	}
+	d := v.decoder()
+	if d == nil {
+		return
+	}
+	for recordType, decode := range d.historical {
+		recs, err := fetchHistorical(v.id, recordType)
+		if err != nil {
+			slog.Error("error fetching the extended battery records", "vehicle", v.id, "type", recordType, "err", err)
+			continue
+		}
+		for _, rec := range recs {
+			ts, err1 := parseMsgTime(rec.Timestamp)
+			number, err2 := strconv.Atoi(rec.RecordNumber.String())
+			if err1 != nil || err2 != nil {
+				slog.Warn("ignoring an invalid historical record", "vehicle", v.id, "type", recordType, "timestamp", rec.Timestamp, "record", rec.RecordNumber)
+				continue
+			}
+			decode(v, number, rec.Data, ts)
+		}
+	}
+}
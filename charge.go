@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	chargeSessionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_charge_sessions_total",
+		Help: "Number of charge sessions started.",
+	}, []string{"vehicle"})
+	chargeEnergyTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_charge_energy_total_kwh",
+		Help: "Energy charged across all sessions, in kWh.",
+	}, []string{"vehicle"})
+	chargeSessionDuration = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_current_charge_session_duration_seconds",
+		Help: "Duration of the ongoing charge session, 0 when not charging.",
+	}, []string{"vehicle"})
+	chargeCostEstimate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_charge_cost_estimate",
+		Help: "Estimated cost of the ongoing or last charge session, based on the configured tariff.",
+	}, []string{"vehicle"})
+	chargeCostTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_charge_cost_total",
+		Help: "Estimated cost of all charge sessions, based on the configured tariff.",
+	}, []string{"vehicle"})
+)
+
+// chargeTracker detects charge sessions from consecutive S records.
+type chargeTracker struct {
+	vehicle string
+	// onEvent, if set, is called when a charge session starts.
+	onEvent func(event string)
+
+	mu       sync.Mutex
+	charging bool
+	start    time.Time
+	// kwh is the energy of the ongoing session already added to chargeEnergyTotal.
+	kwh float64
+	// dc is whether the ongoing session is a DC charge.
+	dc bool
+	// lookup is whether the station of the session was looked up.
+	lookup bool
+	// station is the station of the ongoing session, once found.
+	station *chargeStation
+	// todayKWh is the energy charged on the local day todayDate, for
+	// ovms_fleet_charged_today_kwh.
+	todayDate string
+	todayKWh  float64
+}
+
+// isCharging reports whether ms_v_charge_state means energy is flowing.
+func isCharging(state string) bool {
+	return state == "charging" || state == "topoff"
+}
+
+// update processes the fields of an S record.
+func (c *chargeTracker) update(fields map[string]string, ts time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	charging := isCharging(fields["ms_v_charge_state"])
+	if charging && !c.charging {
+		chargeSessionsTotal.WithLabelValues(c.vehicle).Inc()
+		c.start = ts
+		c.kwh = 0
+		c.dc = false
+		c.lookup = false
+		c.station = nil
+		chargeCostEstimate.WithLabelValues(c.vehicle).Set(0)
+		if c.onEvent != nil {
+			c.onEvent(eventChargeStart)
+		}
+	}
+	c.charging = charging
+	if !charging {
+		chargeSessionDuration.WithLabelValues(c.vehicle).Set(0)
+		return
+	}
+
+	chargeSessionDuration.WithLabelValues(c.vehicle).Set(ts.Sub(c.start).Seconds())
+	if isDCCharge(fields) {
+		c.dc = true
+	}
+	// ms_v_charge_kwh is the energy of the ongoing session.
+	if kwh, err := strconv.ParseFloat(fields["ms_v_charge_kwh"], 64); err == nil {
+		if kwh > c.kwh {
+			chargeEnergyTotal.WithLabelValues(c.vehicle).Add(kwh - c.kwh)
+			if date := ts.Local().Format("2006-01-02"); date != c.todayDate {
+				c.todayDate, c.todayKWh = date, 0
+			}
+			c.todayKWh += kwh - c.kwh
+			if t := cfg().Tariff; t != nil {
+				cost := (kwh - c.kwh) * t.rate(ts)
+				chargeCostEstimate.WithLabelValues(c.vehicle).Add(cost)
+				chargeCostTotal.WithLabelValues(c.vehicle).Add(cost)
+			}
+			if c.station != nil {
+				chargeNetworkEnergy.WithLabelValues(c.vehicle, c.station.Network).Add(kwh - c.kwh)
+			}
+			c.kwh = kwh
+		}
+	}
+}
+
+// chargedToday returns the energy charged since local midnight, in kWh.
+func (c *chargeTracker) chargedToday(now time.Time) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.todayDate != now.Format("2006-01-02") {
+		return 0
+	}
+	return c.todayKWh
+}
+
+// startLookup returns the start of the ongoing DC session if its station
+// was not looked up yet, and marks it as looked up.
+func (c *chargeTracker) startLookup() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.charging || !c.dc || c.lookup {
+		return time.Time{}, false
+	}
+	c.lookup = true
+	return c.start, true
+}
+
+// setStation sets the station of the session started at start, unless
+// another session started since.
+func (c *chargeTracker) setStation(start time.Time, st chargeStation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.start.Equal(start) || c.station != nil {
+		return
+	}
+	c.station = &st
+	chargeStationInfo.DeletePartialMatch(prometheus.Labels{"vehicle": c.vehicle})
+	chargeStationInfo.WithLabelValues(c.vehicle, st.Network, st.Name).Set(1)
+	chargeNetworkSessions.WithLabelValues(c.vehicle, st.Network).Inc()
+	// The energy charged before the station was found.
+	chargeNetworkEnergy.WithLabelValues(c.vehicle, st.Network).Add(c.kwh)
+}
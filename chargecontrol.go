@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// chargeControlRetry is how long the controller waits for a stop command to
+// take effect before sending it again.
+const chargeControlRetry = 5 * time.Minute
+
+var chargeControlActions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_charge_control_actions_total",
+	Help: "Number of commands sent by the charge limit controller, by action and result.",
+}, []string{"vehicle", "action", "result"})
+
+// chargeLimit stops the charge once the SOC reaches a target, for the
+// vehicles whose firmware lacks a reliable charge limit, e.g.
+// {"vehicle": "mycar", "soc": 80}. Without a vehicle it applies to the
+// vehicles without a limit of their own.
+type chargeLimit struct {
+	Vehicle string  `json:"vehicle,omitempty"`
+	SOC     float64 `json:"soc"`
+}
+
+func (l *chargeLimit) validate() error {
+	if l.SOC <= 0 || l.SOC > 100 {
+		return fmt.Errorf("charge limit of %q: soc must be in (0, 100]", l.Vehicle)
+	}
+	return nil
+}
+
+// chargeLimit returns the charge limit of a vehicle, nil if none.
+func (c *config) chargeLimit(vehicle string) *chargeLimit {
+	var def *chargeLimit
+	for _, l := range c.ChargeLimits {
+		switch l.Vehicle {
+		case vehicle:
+			return l
+		case "":
+			def = l
+		}
+	}
+	return def
+}
+
+// chargeController tracks the stop commands of a vehicle.
+type chargeController struct {
+	mu   sync.Mutex
+	last time.Time
+	// limit is the charge limit set by the charge-limit command, 0 if none,
+	// in place of the one of the config until the exporter restarts.
+	limit float64
+}
+
+// chargeLimitSOC returns the charge limit of the vehicle, 0 if none: the one
+// set by the charge-limit command, otherwise the one of the config.
+func (v *vehicle) chargeLimitSOC() float64 {
+	v.chargeControl.mu.Lock()
+	limit := v.chargeControl.limit
+	v.chargeControl.mu.Unlock()
+	if limit == 0 {
+		if l := cfg().chargeLimit(v.id); l != nil {
+			limit = l.SOC
+		}
+	}
+	return limit
+}
+
+// setChargeLimit runs the charge-limit command, setting the charge limit of
+// a vehicle to the soc parameter.
+func setChargeLimit(vehicle string, params map[string]string) (string, error) {
+	v := findVehicle(vehicle)
+	if v == nil {
+		return "", fmt.Errorf("unknown vehicle %q", vehicle)
+	}
+	for k := range params {
+		if k != "soc" {
+			return "", fmt.Errorf("command %q does not take the %s parameter", chargeLimitCommand, k)
+		}
+	}
+	soc, err := strconv.ParseFloat(params["soc"], 64)
+	if err != nil || soc <= 0 || soc > 100 {
+		return "", fmt.Errorf("invalid soc parameter %q, must be in (0, 100]", params["soc"])
+	}
+	v.chargeControl.mu.Lock()
+	v.chargeControl.limit = soc
+	v.chargeControl.mu.Unlock()
+	slog.Info("charge limit set", "vehicle", v.id, "soc", soc)
+	v.updateChargeControl()
+	return fmt.Sprintf("charge limit set to %g%%", soc), nil
+}
+
+// updateChargeControl stops the ongoing charge, in the background, once the
+// SOC reaches the charge limit.
+func (v *vehicle) updateChargeControl() {
+	limit := v.chargeLimitSOC()
+	if limit == 0 || v.state() != stateCharging {
+		return
+	}
+	soc := v.floatField("S", "ms_v_bat_soc")
+	if soc == nil || *soc < limit {
+		return
+	}
+	now := time.Now()
+	v.chargeControl.mu.Lock()
+	if now.Sub(v.chargeControl.last) < chargeControlRetry {
+		v.chargeControl.mu.Unlock()
+		return
+	}
+	v.chargeControl.last = now
+	v.chargeControl.mu.Unlock()
+
+	slog.Info("charge limit reached, stopping the charge", "vehicle", v.id, "soc", *soc, "limit", limit)
+	go func() {
+		resp, err := sendCommand(v.id, "charge-stop", nil)
+		if err != nil {
+			chargeControlActions.WithLabelValues(v.id, "stop", "error").Inc()
+			slog.Error("error stopping the charge", "vehicle", v.id, "err", err, "response", resp)
+			return
+		}
+		chargeControlActions.WithLabelValues(v.id, "stop", "success").Inc()
+	}()
+}
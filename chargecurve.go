@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxChargeCurves is the number of charge sessions whose curve is kept.
+const maxChargeCurves = 20
+
+// chargeTaperRatio is the fraction of the peak power under which the charge
+// is considered tapering.
+const chargeTaperRatio = 0.8
+
+var (
+	chargePeakPower = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_charge_peak_power_kw",
+		Help: "Highest charge power of the ongoing or last charge session, in kW.",
+	}, []string{"vehicle"})
+	chargeAveragePower = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_charge_average_power_kw",
+		Help: "Time-weighted average charge power of the ongoing or last charge session, in kW.",
+	}, []string{"vehicle"})
+	chargeTaperSOC = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_charge_taper_soc",
+		Help: "State of charge at which the power of the ongoing or last charge session fell below 80% of its peak, in %; absent until it does.",
+	}, []string{"vehicle"})
+)
+
+// chargeCurvePoint is a sample of the charge power.
+type chargeCurvePoint struct {
+	Time    time.Time `json:"time"`
+	SOC     float64   `json:"soc"`
+	PowerKW float64   `json:"power_kw"`
+}
+
+// chargeCurve is the power-vs-SOC curve of a charge session.
+type chargeCurve struct {
+	Start          time.Time          `json:"start"`
+	End            *time.Time         `json:"end,omitempty"`
+	PeakPowerKW    float64            `json:"peak_power_kw"`
+	AveragePowerKW float64            `json:"average_power_kw"`
+	TaperSOC       *float64           `json:"taper_soc,omitempty"`
+	Points         []chargeCurvePoint `json:"points"`
+}
+
+// summarize computes the peak power, the average power and the taper SOC
+// from the points.
+func (c *chargeCurve) summarize() {
+	c.PeakPowerKW, c.AveragePowerKW, c.TaperSOC = 0, 0, nil
+	peak := 0
+	for i, p := range c.Points {
+		if p.PowerKW > c.PeakPowerKW {
+			c.PeakPowerKW, peak = p.PowerKW, i
+		}
+	}
+	for _, p := range c.Points[peak:] {
+		if c.PeakPowerKW > 0 && p.PowerKW < chargeTaperRatio*c.PeakPowerKW {
+			soc := p.SOC
+			c.TaperSOC = &soc
+			break
+		}
+	}
+	// The average is weighted by the time between the samples, the polls
+	// being irregular.
+	var kwh, hours float64
+	for i := 1; i < len(c.Points); i++ {
+		h := c.Points[i].Time.Sub(c.Points[i-1].Time).Hours()
+		kwh += (c.Points[i].PowerKW + c.Points[i-1].PowerKW) / 2 * h
+		hours += h
+	}
+	switch {
+	case hours > 0:
+		c.AveragePowerKW = kwh / hours
+	case len(c.Points) > 0:
+		c.AveragePowerKW = c.Points[0].PowerKW
+	}
+}
+
+// chargeCurveTracker records the charge curve of the sessions from the S
+// records.
+type chargeCurveTracker struct {
+	vehicle string
+
+	mu sync.Mutex
+	// curves are the latest sessions, oldest first, the last one being the
+	// ongoing one while charging.
+	curves   []*chargeCurve
+	charging bool
+}
+
+// update processes the fields of an S record.
+func (c *chargeCurveTracker) update(fields map[string]string, ts time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	charging := isCharging(fields["ms_v_charge_state"])
+	if !charging {
+		if c.charging {
+			end := ts
+			c.curves[len(c.curves)-1].End = &end
+		}
+		c.charging = false
+		return
+	}
+	if !c.charging {
+		c.curves = append(c.curves, &chargeCurve{Start: ts})
+		if len(c.curves) > maxChargeCurves {
+			c.curves = c.curves[len(c.curves)-maxChargeCurves:]
+		}
+		chargeTaperSOC.DeleteLabelValues(c.vehicle)
+		c.charging = true
+	}
+	cur := c.curves[len(c.curves)-1]
+	if n := len(cur.Points); n > 0 && !ts.After(cur.Points[n-1].Time) {
+		return
+	}
+	soc, err1 := strconv.ParseFloat(fields["ms_v_bat_soc"], 64)
+	power, err2 := strconv.ParseFloat(fields["ms_v_charge_power"], 64)
+	if err1 != nil || err2 != nil || power < 0 {
+		return
+	}
+	cur.Points = append(cur.Points, chargeCurvePoint{ts, soc, power})
+	cur.summarize()
+	chargePeakPower.WithLabelValues(c.vehicle).Set(cur.PeakPowerKW)
+	chargeAveragePower.WithLabelValues(c.vehicle).Set(cur.AveragePowerKW)
+	if cur.TaperSOC != nil {
+		chargeTaperSOC.WithLabelValues(c.vehicle).Set(*cur.TaperSOC)
+	}
+}
+
+// snapshot returns a copy of the curves, oldest first.
+func (c *chargeCurveTracker) snapshot() []chargeCurve {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	curves := make([]chargeCurve, len(c.curves))
+	for i, cur := range c.curves {
+		curves[i] = *cur
+		curves[i].Points = append([]chargeCurvePoint{}, cur.Points...)
+	}
+	return curves
+}
+
+// purge forgets the curves.
+func (c *chargeCurveTracker) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.curves = nil
+	c.charging = false
+}
+
+// handleChargeCurve serves /api/v1/vehicles/<id>/charge-curve, the curves
+// of the latest charge sessions, oldest first, or only the last one with
+// ?last=true.
+func handleChargeCurve(w http.ResponseWriter, r *http.Request, v *vehicle) {
+	curves := v.chargeCurve.snapshot()
+	if last, _ := strconv.ParseBool(r.URL.Query().Get("last")); last && len(curves) > 0 {
+		curves = curves[len(curves)-1:]
+	}
+	writeShapedJSON(w, r, struct {
+		Vehicle  string        `json:"vehicle"`
+		Sessions []chargeCurve `json:"sessions"`
+	}{v.id, curves})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	openChargeMapKeyFlag = flag.String("openchargemap-key", os.Getenv("OPENCHARGEMAP_KEY"), "OpenChargeMap API key; enables labeling the DC charge sessions with the network and the station found at the position")
+	openChargeMapURLFlag = flag.String("openchargemap-url", "https://api.openchargemap.io/v3/poi", "OpenChargeMap POI API URL")
+)
+
+var (
+	chargeStationInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_charge_station_info",
+		Help: "Network and station of the ongoing or last DC charge session, from OpenChargeMap.",
+	}, []string{"vehicle", "network", "station"})
+	chargeNetworkSessions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_charge_network_sessions_total",
+		Help: "Number of DC charge sessions by charging network.",
+	}, []string{"vehicle", "network"})
+	chargeNetworkEnergy = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_charge_network_energy_total_kwh",
+		Help: "Energy charged in DC charge sessions by charging network, in kWh.",
+	}, []string{"vehicle", "network"})
+)
+
+const (
+	// dcChargePowerKW is the charge power above which a session is
+	// considered DC: the AC charging is at most 22 kW.
+	dcChargePowerKW = 22
+	// stationMatchMeters is the maximum distance of the matched station.
+	stationMatchMeters = 150
+	// stationCachePrecision is the geohash precision of the cached lookups,
+	// roughly 150m x 150m.
+	stationCachePrecision = 7
+	// unknownNetwork is the network of the stations without an operator.
+	unknownNetwork = "unknown"
+)
+
+// chargeStation is a charging station found by OpenChargeMap. A lookup that
+// found nothing is cached as a station without a name.
+type chargeStation struct {
+	Network string `json:"network,omitempty"`
+	Name    string `json:"name,omitempty"`
+}
+
+// stationCache caches the lookups by geohash, in -state-dir.
+type stationCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	Entries map[string]chargeStation `json:"entries"`
+}
+
+const stationCacheFile = "charge_stations.json"
+
+var stations stationCache
+
+// lookup returns the station at the position, querying OpenChargeMap unless
+// the position is cached.
+func (c *stationCache) lookup(lat, lon float64) (chargeStation, error) {
+	key := geohash(lat, lon, stationCachePrecision)
+	c.mu.Lock()
+	if !c.loaded {
+		if err := readState(stationCacheFile, c); err != nil {
+			slog.Error("error loading the charge station cache", "err", err)
+		}
+		if c.Entries == nil {
+			c.Entries = map[string]chargeStation{}
+		}
+		c.loaded = true
+	}
+	st, ok := c.Entries[key]
+	c.mu.Unlock()
+	if ok {
+		return st, nil
+	}
+
+	st, err := queryOpenChargeMap(lat, lon)
+	if err != nil {
+		return st, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Entries[key] = st
+	if err := writeState(stationCacheFile, c); err != nil {
+		slog.Error("error saving the charge station cache", "err", err)
+	}
+	return st, nil
+}
+
+// openChargeMapPOI is the part of an OpenChargeMap POI that is used.
+type openChargeMapPOI struct {
+	AddressInfo struct {
+		Title string `json:"Title"`
+	} `json:"AddressInfo"`
+	OperatorInfo *struct {
+		Title string `json:"Title"`
+	} `json:"OperatorInfo"`
+}
+
+// queryOpenChargeMap returns the closest station within stationMatchMeters.
+func queryOpenChargeMap(lat, lon float64) (chargeStation, error) {
+	q := url.Values{
+		"output":       {"json"},
+		"latitude":     {strconv.FormatFloat(lat, 'f', -1, 64)},
+		"longitude":    {strconv.FormatFloat(lon, 'f', -1, 64)},
+		"distance":     {strconv.FormatFloat(stationMatchMeters/1000.0, 'f', -1, 64)},
+		"distanceunit": {"km"},
+		"maxresults":   {"1"},
+		"verbose":      {"false"},
+	}
+	req, err := http.NewRequest(http.MethodGet, *openChargeMapURLFlag+"?"+q.Encode(), nil)
+	if err != nil {
+		return chargeStation{}, err
+	}
+	req.Header.Set("X-API-Key", *openChargeMapKeyFlag)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return chargeStation{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return chargeStation{}, fmt.Errorf("OpenChargeMap: %s", resp.Status)
+	}
+	var pois []openChargeMapPOI
+	if err := json.NewDecoder(resp.Body).Decode(&pois); err != nil {
+		return chargeStation{}, fmt.Errorf("OpenChargeMap: %v", err)
+	}
+	if len(pois) == 0 {
+		return chargeStation{}, nil
+	}
+	st := chargeStation{Network: unknownNetwork, Name: pois[0].AddressInfo.Title}
+	if op := pois[0].OperatorInfo; op != nil && op.Title != "" {
+		st.Network = op.Title
+	}
+	return st, nil
+}
+
+// isDCCharge reports whether the fields of an S record are of a DC charge.
+func isDCCharge(fields map[string]string) bool {
+	power, err := strconv.ParseFloat(fields["ms_v_charge_power"], 64)
+	return err == nil && power > dcChargePowerKW
+}
+
+// updateChargeStation finds the station of the ongoing DC charge session,
+// once the position is known, in the background.
+func (v *vehicle) updateChargeStation() {
+	if *openChargeMapKeyFlag == "" {
+		return
+	}
+	v.trips.mu.Lock()
+	lat, lon := v.trips.lat, v.trips.lon
+	v.trips.mu.Unlock()
+	if lat == 0 && lon == 0 {
+		return
+	}
+	start, ok := v.charge.startLookup()
+	if !ok {
+		return
+	}
+	go func() {
+		st, err := stations.lookup(lat, lon)
+		if err != nil {
+			slog.Error("error looking up the charge station", "vehicle", v.id, "err", err)
+			return
+		}
+		if st.Name == "" {
+			slog.Info("no charge station found", "vehicle", v.id, "lat", lat, "lon", lon)
+			return
+		}
+		v.charge.setStation(start, st)
+	}()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	circuitFailuresFlag = flag.Int("circuit-failures", 5, "Consecutive polls of a vehicle rejected as unauthorized or not found, by status or error message, after which the vehicle is no longer polled, only re-checked after -circuit-min-open, doubling up to -circuit-max-open; 0 disables it")
+	circuitMinOpenFlag  = flag.Duration("circuit-min-open", 5*time.Minute, "Time after which a vehicle no longer polled is re-checked the first time")
+	circuitMaxOpenFlag  = flag.Duration("circuit-max-open", 24*time.Hour, "Longest time between the re-checks of a vehicle no longer polled")
+)
+
+var circuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_vehicle_circuit_open",
+	Help: "Whether the vehicle is no longer polled, its polls having been rejected as unauthorized or not found, see -circuit-failures.",
+}, []string{"vehicle"})
+
+// circuitFailure reports whether a response counts as a failure of the
+// circuit, by its status or the reason of the error response in place of the
+// records, see parseAPIError: the credentials were rejected or the vehicle
+// is unknown, which retrying will not fix.
+func circuitFailure(status int, reason string) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusNotFound ||
+		reason == "unauthorized" || reason == "unknown_vehicle"
+}
+
+// circuitBreaker stops the polls of a vehicle after consecutive failures,
+// e.g. one decommissioned but left in -vehicle, re-checking it with a
+// decaying frequency.
+type circuitBreaker struct {
+	vehicle string
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	// until is when the vehicle is re-checked while open, backoff the time
+	// since the previous re-check.
+	until   time.Time
+	backoff time.Duration
+}
+
+// allow reports whether the vehicle is to be polled: the circuit is closed,
+// or it is open and the re-check is due.
+func (c *circuitBreaker) allow(now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.open || !now.Before(c.until)
+}
+
+// record processes the status of the response to a poll, 0 if none was
+// received, and the reason of the error response, if any. While the circuit
+// is open, a re-check that does not succeed, e.g. a server error, postpones
+// the next one; otherwise the responses that are neither successful nor
+// circuit failures leave the circuit as is.
+func (c *circuitBreaker) record(status int, reason string, now time.Time) {
+	if *circuitFailuresFlag <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case status == http.StatusOK && reason == "":
+		if c.open {
+			slog.Info("vehicle polled again", "vehicle", c.vehicle)
+		}
+		c.failures = 0
+		c.open = false
+	case c.open:
+		if circuitFailure(status, reason) {
+			c.failures++
+		}
+		c.backoff = min(2*c.backoff, *circuitMaxOpenFlag)
+		c.until = now.Add(c.backoff)
+		slog.Info("vehicle still failing, re-checking later", "vehicle", c.vehicle, "status", status, "reason", reason, "in", c.backoff)
+	case circuitFailure(status, reason):
+		c.failures++
+		if c.failures >= *circuitFailuresFlag {
+			c.open = true
+			c.backoff = *circuitMinOpenFlag
+			c.until = now.Add(c.backoff)
+			slog.Warn("vehicle no longer polled after consecutive failures", "vehicle", c.vehicle, "status", status, "reason", reason, "failures", c.failures, "recheck", c.backoff)
+		}
+	}
+	val := 0.0
+	if c.open {
+		val = 1
+	}
+	circuitOpen.WithLabelValues(c.vehicle).Set(val)
+}
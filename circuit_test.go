@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// setCircuitFlags sets the circuit flags for the duration of a test.
+func setCircuitFlags(t *testing.T, failures int, minOpen, maxOpen time.Duration) {
+	prevFailures, prevMin, prevMax := *circuitFailuresFlag, *circuitMinOpenFlag, *circuitMaxOpenFlag
+	*circuitFailuresFlag, *circuitMinOpenFlag, *circuitMaxOpenFlag = failures, minOpen, maxOpen
+	t.Cleanup(func() {
+		*circuitFailuresFlag, *circuitMinOpenFlag, *circuitMaxOpenFlag = prevFailures, prevMin, prevMax
+	})
+}
+
+func TestCircuitFailure(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		reason string
+		want   bool
+	}{
+		{http.StatusOK, "", false},
+		{http.StatusUnauthorized, "", true},
+		{http.StatusForbidden, "", true},
+		{http.StatusNotFound, "", true},
+		{http.StatusOK, "unauthorized", true},
+		{http.StatusOK, "unknown_vehicle", true},
+		{http.StatusOK, "Wrong password", false},
+		{http.StatusInternalServerError, "", false},
+		{http.StatusTooManyRequests, "", false},
+		{0, "", false},
+	} {
+		if got := circuitFailure(tc.status, tc.reason); got != tc.want {
+			t.Errorf("circuitFailure(%d, %q) = %v, want %v", tc.status, tc.reason, got, tc.want)
+		}
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	setCircuitFlags(t, 3, time.Minute, 5*time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := circuitBreaker{vehicle: "test"}
+	record := func(status int, reason string) { c.record(status, reason, now) }
+
+	// Other errors neither count nor reset the failures.
+	record(http.StatusUnauthorized, "")
+	record(http.StatusInternalServerError, "")
+	record(0, "")
+	record(http.StatusOK, "unknown_vehicle")
+	if !c.allow(now) {
+		t.Fatal("circuit open after 2 failures")
+	}
+	// A success resets them.
+	record(http.StatusOK, "")
+	record(http.StatusNotFound, "")
+	record(http.StatusNotFound, "")
+	if !c.allow(now) {
+		t.Fatal("circuit open after a success and 2 failures")
+	}
+	record(http.StatusNotFound, "")
+	if c.allow(now) {
+		t.Fatal("circuit closed after 3 failures")
+	}
+
+	// The re-checks back off, doubling up to -circuit-max-open, whether
+	// they fail as before or otherwise.
+	for i, tc := range []struct {
+		status  int
+		reason  string
+		backoff time.Duration
+	}{
+		{http.StatusNotFound, "", 2 * time.Minute},
+		{http.StatusInternalServerError, "", 4 * time.Minute},
+		{http.StatusOK, "unauthorized", 5 * time.Minute},
+		{http.StatusNotFound, "", 5 * time.Minute},
+	} {
+		if c.allow(now.Add(c.backoff - time.Second)) {
+			t.Fatalf("re-check %d allowed before it is due", i)
+		}
+		now = now.Add(c.backoff)
+		if !c.allow(now) {
+			t.Fatalf("re-check %d not allowed when due", i)
+		}
+		record(tc.status, tc.reason)
+		if c.backoff != tc.backoff {
+			t.Errorf("re-check %d: backoff %v, want %v", i, c.backoff, tc.backoff)
+		}
+	}
+
+	// A successful re-check closes the circuit.
+	now = now.Add(c.backoff)
+	record(http.StatusOK, "")
+	if !c.allow(now) {
+		t.Fatal("circuit open after a successful re-check")
+	}
+	record(http.StatusUnauthorized, "")
+	if !c.allow(now) {
+		t.Error("circuit open after a single failure following the success")
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	setCircuitFlags(t, 0, time.Minute, time.Hour)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := circuitBreaker{vehicle: "test"}
+	for i := 0; i < 10; i++ {
+		c.record(http.StatusUnauthorized, "", now)
+	}
+	if !c.allow(now) {
+		t.Error("circuit open with -circuit-failures 0")
+	}
+}
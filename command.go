@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// maxCommandResponse caps how much of a command response is kept.
+const maxCommandResponse = 4096
+
+// vehicleCommands maps the supported command names to the OVMS server API
+// endpoints that relay them to the vehicle, with the names of the query
+// parameters they accept.
+// Reference: https://docs.openvehicles.com/en/latest/server/api.html
+var vehicleCommands = map[string]struct {
+	method string
+	path   string
+	params []string
+}{
+	"charge-start": {http.MethodPut, "/api/charge/", nil},
+	"charge-stop":  {http.MethodDelete, "/api/charge/", nil},
+	"lock":         {http.MethodPut, "/api/lock/", []string{"pin"}},
+	"unlock":       {http.MethodDelete, "/api/lock/", []string{"pin"}},
+}
+
+// streamCommand is a command not relayed by the server API, sent over a
+// protocol v2 connection, the -stream one if up: its code, fixed arguments
+// and the names of its parameters, sent after in order. The last parameter
+// of a text command is free text, commas included.
+// Reference: https://docs.openvehicles.com/en/latest/protocol_v2/commands.html
+type streamCommand struct {
+	code   string
+	args   []string
+	params []string
+	text   bool
+}
+
+// streamCommands maps the names of the stream commands to them.
+var streamCommands = map[string]streamCommand{
+	"wakeup":      {code: "18"},
+	"climate-on":  {code: "26", args: []string{"1"}},
+	"climate-off": {code: "26", args: []string{"0"}},
+}
+
+// chargeLimitCommand sets the charge limit of the vehicle, enforced by the
+// charge limit controller rather than by the vehicle, see
+// updateChargeControl.
+const chargeLimitCommand = "charge-limit"
+
+// streamCommandTimeout is how long the response of a stream command is
+// waited for.
+const streamCommandTimeout = 30 * time.Second
+
+// commandNames returns the sorted names of the supported commands.
+func commandNames() []string {
+	var names []string
+	for name := range vehicleCommands {
+		names = append(names, name)
+	}
+	for name := range streamCommands {
+		names = append(names, name)
+	}
+	names = append(names, chargeLimitCommand)
+	sort.Strings(names)
+	return names
+}
+
+// commandParams returns the names of the parameters of a command, and
+// whether the command exists.
+func commandParams(name string) ([]string, bool) {
+	if name == chargeLimitCommand {
+		return []string{"soc"}, true
+	}
+	if sc, ok := streamCommands[name]; ok {
+		return sc.params, true
+	}
+	c, ok := vehicleCommands[name]
+	return c.params, ok
+}
+
+// sendCommand relays a command to the vehicle through the OVMS server and
+// returns the server response.
+func sendCommand(vehicle, name string, params map[string]string) (string, error) {
+	if sc, ok := streamCommands[name]; ok {
+		return sendStreamCommand(vehicle, name, sc, params)
+	}
+	if name == chargeLimitCommand {
+		return setChargeLimit(vehicle, params)
+	}
+	c, ok := vehicleCommands[name]
+	if !ok {
+		return "", fmt.Errorf("unknown command %q, supported: %s", name, strings.Join(commandNames(), ", "))
+	}
+
+	// Only the parameters of the command are sent, never overwriting the
+	// credentials.
+	q := vehicleAuthQuery(vehicle)
+	for k, v := range params {
+		if !contains(c.params, k) {
+			return "", fmt.Errorf("command %q does not take the %s parameter", name, k)
+		}
+		q.Set(k, v)
+	}
+	path := c.path + url.PathEscape(vehicle)
+	p := vehicleServer(vehicle)
+	req, err := http.NewRequest(c.method, fmt.Sprintf("http://%s%s?%s", p.currentAddr(), path, q.Encode()), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.do(req)
+	if err != nil {
+		return "", fmt.Errorf("error sending %q to %s: %v", name, path, withoutURL(err))
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCommandResponse))
+	if err != nil {
+		return "", fmt.Errorf("error reading the response to %q from %s: %v", name, path, withoutURL(err))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return string(body), fmt.Errorf("%s %s: %s", c.method, path, resp.Status)
+	}
+	return string(body), nil
+}
+
+// sendStreamCommand sends a stream command to the vehicle and returns the
+// text of its response.
+func sendStreamCommand(vehicle, name string, sc streamCommand, params map[string]string) (string, error) {
+	v := findVehicle(vehicle)
+	if v == nil {
+		return "", fmt.Errorf("unknown vehicle %q", vehicle)
+	}
+	if vehiclePassword(vehicle) == "" {
+		return "", fmt.Errorf("command %q needs -vehicle-password or the vehicle_password of %s", name, vehicle)
+	}
+	for k := range params {
+		if !contains(sc.params, k) {
+			return "", fmt.Errorf("command %q does not take the %s parameter", name, k)
+		}
+	}
+	args := append([]string(nil), sc.args...)
+	for i, p := range sc.params {
+		val, ok := params[p]
+		if !ok || val == "" {
+			return "", fmt.Errorf("command %q needs the %s parameter", name, p)
+		}
+		// The messages are lines of comma-separated fields.
+		text := sc.text && i == len(sc.params)-1
+		if strings.ContainsAny(val, "\r\n") || !text && strings.Contains(val, ",") {
+			return "", fmt.Errorf("invalid %s parameter %q", p, val)
+		}
+		args = append(args, val)
+	}
+	return v.stream.command(sc.code, args, streamCommandTimeout)
+}
+
+var (
+	enableCommandsFlag = flag.Bool("enable-commands", false, "Enable POST /api/v1/vehicles/<id>/command, which relays commands to the vehicle; requires -command-token")
+	commandTokenFlag   = flag.String("command-token", os.Getenv("OVMS_EXPORTER_COMMAND_TOKEN"), "Bearer token required by the command endpoint, separate from -admin-token")
+)
+
+var commandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_api_commands_total",
+	Help: "Number of commands received by the command endpoint, by result.",
+}, []string{"vehicle", "command", "result"})
+
+// commandRequest is the body of the command endpoint, e.g.
+// {"command": "charge-limit", "params": {"soc": "80"}}.
+type commandRequest struct {
+	Command string            `json:"command"`
+	Params  map[string]string `json:"params"`
+}
+
+// handleCommand relays a command to the vehicle. It is guarded by
+// -enable-commands and the -command-token rather than the usual
+// authentication: the commands act on the vehicle.
+func handleCommand(w http.ResponseWriter, r *http.Request, vehicleID string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !*enableCommandsFlag || *commandTokenFlag == "" {
+		http.Error(w, "commands are disabled, see -enable-commands and -command-token", http.StatusForbidden)
+		return
+	}
+	if !checkBearer(r, *commandTokenFlag) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	v := findVehicle(vehicleID)
+	if v == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+	var req commandRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, ok := commandParams(req.Command); !ok {
+		http.Error(w, fmt.Sprintf("unknown command %q, supported: %s", req.Command, strings.Join(commandNames(), ", ")), http.StatusBadRequest)
+		return
+	}
+	slog.Info("command", "vehicle", v.id, "command", req.Command, "params", req.Params, "remote", r.RemoteAddr)
+	resp, err := sendCommand(v.id, req.Command, req.Params)
+	result := struct {
+		Command  string `json:"command"`
+		Response string `json:"response"`
+		Error    string `json:"error,omitempty"`
+	}{Command: req.Command, Response: resp}
+	if err != nil {
+		commandsTotal.WithLabelValues(v.id, req.Command, "error").Inc()
+		slog.Error("command failed", "vehicle", v.id, "command", req.Command, "err", err)
+		result.Error = err.Error()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	commandsTotal.WithLabelValues(v.id, req.Command, "success").Inc()
+	writeJSON(w, result)
+}
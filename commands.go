@@ -0,0 +1,49 @@
+package main
+
+import "flag"
+
+// subcommand is a command run instead of the exporter, after the flags of
+// the exporter: ovms_exporter [flags] <command> [command flags] [args].
+type subcommand struct {
+	name     string
+	synopsis string
+	// words are the fixed values of the first argument, for the completion.
+	words []string
+	// flags returns the flags of the command and the function running it
+	// with the arguments left after them.
+	flags func() (*flag.FlagSet, func(args []string) error)
+}
+
+// run parses the flags of the command and runs it.
+func (c *subcommand) run(args []string) error {
+	fs, run := c.flags()
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return run(fs.Args())
+}
+
+// subcommands returns the commands, in the order they are documented.
+func subcommands() []subcommand {
+	return []subcommand{
+		{"token", "Manage the OVMS server API tokens", []string{"create", "list", "revoke"}, tokenCommand},
+		{"simulate", "Run a fake OVMS server serving synthesized records", nil, simulateCommand},
+		{"dump", "Save the raw server responses for -replay-file", nil, dumpCommand},
+		{"watch", "Show the vehicles in the terminal", nil, watchCommand},
+		{"import", "Import the records of CSV exports into the history", nil, importCommand},
+		{"export", "Export the records of -record-log-dir", []string{"csv"}, exportCommand},
+		{"migrate-dashboards", "Rewrite the metric names in dashboards and rule files", nil, migrateCommand},
+		{"completion", "Print the shell completion script", []string{"bash", "zsh", "fish"}, completionCommand},
+		{"man", "Print the man page", nil, manCommand},
+	}
+}
+
+// findSubcommand returns the command with the given name or nil.
+func findSubcommand(name string) *subcommand {
+	for _, c := range subcommands() {
+		if c.name == name {
+			return &c
+		}
+	}
+	return nil
+}
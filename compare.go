@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// vehicleSummary is the current state of a vehicle, as compared by
+// /api/v1/compare.
+type vehicleSummary struct {
+	Vehicle           string               `json:"vehicle"`
+	State             string               `json:"state"`
+	SOC               *float64             `json:"soc,omitempty"`
+	RangeEstKm        *float64             `json:"range_est_km,omitempty"`
+	RangeIdealKm      *float64             `json:"range_ideal_km,omitempty"`
+	OdometerKm        float64              `json:"odometer_km"`
+	EfficiencyWhPerKm *float64             `json:"efficiency_wh_per_km,omitempty"`
+	Degradation       []degradationSummary `json:"degradation"`
+}
+
+// floatField parses a field of the latest record of a code.
+func (v *vehicle) floatField(code, name string) *float64 {
+	f, err := strconv.ParseFloat(v.field(code, name), 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// distanceKmField parses a distance field of the S record, sent in the
+// vehicle units.
+func (v *vehicle) distanceKmField(name string) *float64 {
+	f := v.floatField("S", name)
+	if f != nil && v.field("S", "m_units_distance") == "M" {
+		*f *= kmPerMile
+	}
+	return f
+}
+
+func (v *vehicle) summary() vehicleSummary {
+	s := vehicleSummary{
+		Vehicle:      v.id,
+		State:        v.state(),
+		SOC:          v.floatField("S", "ms_v_bat_soc"),
+		RangeEstKm:   v.distanceKmField("ms_v_bat_range_est"),
+		RangeIdealKm: v.distanceKmField("ms_v_bat_range_ideal"),
+		OdometerKm:   v.odometerKm(),
+		Degradation:  v.degradation.summary(),
+	}
+	if e, ok := v.efficiency.current(); ok {
+		s.EfficiencyWhPerKm = &e
+	}
+	return s
+}
+
+// handleCompare serves /api/v1/compare?vehicles=a,b with the summaries of
+// two or more vehicles side by side.
+func handleCompare(w http.ResponseWriter, r *http.Request) {
+	ids := strings.Split(r.URL.Query().Get("vehicles"), ",")
+	if len(ids) < 2 {
+		http.Error(w, "at least two vehicles are required, e.g. ?vehicles=a,b", http.StatusBadRequest)
+		return
+	}
+	var summaries []vehicleSummary
+	for _, id := range ids {
+		v := findVehicle(strings.TrimSpace(id))
+		if v == nil {
+			http.Error(w, "unknown vehicle "+strconv.Quote(id), http.StatusNotFound)
+			return
+		}
+		summaries = append(summaries, v.summary())
+	}
+	writeShapedJSON(w, r, summaries)
+}
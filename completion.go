@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const completionUsage = `usage: ovms_exporter completion bash|zsh|fish
+
+Prints the completion script of the shell, e.g.:
+
+  ovms_exporter completion bash > /etc/bash_completion.d/ovms_exporter
+  ovms_exporter completion zsh > "${fpath[1]}/_ovms_exporter"
+  ovms_exporter completion fish > ~/.config/fish/completions/ovms_exporter.fish`
+
+const manUsage = `usage: ovms_exporter man
+
+Prints the man page in the roff format, e.g.:
+
+  ovms_exporter man > /usr/local/share/man/man1/ovms_exporter.1`
+
+// envFlags are the flags whose defaults are read from the environment. Their
+// defaults are not documented, they may be secrets.
+var envFlags = map[string]string{
+	"username":          "OVMS_USERNAME",
+	"password":          "OVMS_PASSWORD",
+	"token":             "OVMS_TOKEN",
+	"admin-token":       "OVMS_EXPORTER_ADMIN_TOKEN",
+	"vehicle-password":  "OVMS_VEHICLE_PASSWORD",
+	"openchargemap-key": "OPENCHARGEMAP_KEY",
+	"abrp-token":        "ABRP_TOKEN",
+	"abrp-api-key":      "ABRP_API_KEY",
+	"command-token":     "OVMS_EXPORTER_COMMAND_TOKEN",
+}
+
+// docFlag is a flag as documented by the completion and the man page.
+type docFlag struct {
+	name  string
+	arg   string
+	usage string
+	def   string
+}
+
+// takesValue reports whether the flag is followed by a value.
+func (f docFlag) takesValue() bool {
+	return f.arg != ""
+}
+
+// docFlags returns the flags of a flag set, sorted by name.
+func docFlags(fs *flag.FlagSet) []docFlag {
+	var flags []docFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		arg, usage := flag.UnquoteUsage(f)
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			arg = ""
+		}
+		def := f.DefValue
+		if _, ok := envFlags[f.Name]; ok {
+			def = ""
+		}
+		flags = append(flags, docFlag{f.Name, arg, strings.Join(strings.Fields(usage), " "), def})
+	})
+	return flags
+}
+
+// subcommandFlags returns the documented flags of a command.
+func subcommandFlags(c subcommand) []docFlag {
+	fs, _ := c.flags()
+	return docFlags(fs)
+}
+
+// flagNames returns the flags prefixed with a dash, the ones taking a value
+// only if values is set.
+func flagNames(flags []docFlag, values bool) []string {
+	var names []string
+	for _, f := range flags {
+		if !values || f.takesValue() {
+			names = append(names, "-"+f.name)
+		}
+	}
+	return names
+}
+
+func writeBashCompletion(w io.Writer) {
+	global := docFlags(flag.CommandLine)
+	var names []string
+	for _, c := range subcommands() {
+		names = append(names, c.name)
+	}
+
+	fmt.Fprintln(w, "# bash completion for ovms_exporter, generated by ovms_exporter completion bash.")
+	fmt.Fprintln(w, "_ovms_exporter() {")
+	fmt.Fprintln(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"")
+	fmt.Fprintln(w, "\tlocal cmd=\"\" i")
+	fmt.Fprintln(w, "\tfor ((i = 1; i < COMP_CWORD; i++)); do")
+	fmt.Fprintf(w, "\t\tcase \"${COMP_WORDS[i]}\" in\n\t\t%s) cmd=\"${COMP_WORDS[i]}\"; break ;;\n\t\tesac\n", strings.Join(names, "|"))
+	fmt.Fprintln(w, "\tdone")
+	fmt.Fprintln(w, "\tcase \"$cmd\" in")
+	writeBashCase(w, `""`, global, names)
+	for _, c := range subcommands() {
+		writeBashCase(w, c.name, subcommandFlags(c), c.words)
+	}
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o default -F _ovms_exporter ovms_exporter")
+}
+
+// writeBashCase writes the completion of a command: files after the flags
+// taking a value, the flags and the words otherwise.
+func writeBashCase(w io.Writer, pattern string, flags []docFlag, words []string) {
+	fmt.Fprintf(w, "\t%s)\n", pattern)
+	if values := flagNames(flags, true); len(values) > 0 {
+		fmt.Fprintf(w, "\t\tcase \"$prev\" in\n\t\t%s) COMPREPLY=($(compgen -f -- \"$cur\")); return ;;\n\t\tesac\n", strings.Join(values, "|"))
+	}
+	all := append(flagNames(flags, false), words...)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(all, " "))
+	fmt.Fprintln(w, "\t\t;;")
+}
+
+// zshQuote quotes an _arguments spec.
+func zshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// zshFlagSpecs returns the _arguments specs of the flags.
+func zshFlagSpecs(flags []docFlag) []string {
+	var specs []string
+	for _, f := range flags {
+		desc := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(f.usage)
+		spec := fmt.Sprintf("-%s[%s]", f.name, desc)
+		if f.takesValue() {
+			spec += ":" + f.arg + ":_files"
+		}
+		specs = append(specs, zshQuote(spec))
+	}
+	return specs
+}
+
+func writeZshCompletion(w io.Writer) {
+	fmt.Fprintln(w, "#compdef ovms_exporter")
+	fmt.Fprintln(w, "# zsh completion for ovms_exporter, generated by ovms_exporter completion zsh.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_ovms_exporter() {")
+	fmt.Fprintln(w, "\tlocal curcontext=\"$curcontext\" state line")
+	fmt.Fprintln(w, "\tlocal -a commands")
+	fmt.Fprintln(w, "\tcommands=(")
+	for _, c := range subcommands() {
+		fmt.Fprintf(w, "\t\t%s\n", zshQuote(c.name+":"+c.synopsis))
+	}
+	fmt.Fprintln(w, "\t)")
+	fmt.Fprintln(w, "\t_arguments -C \\")
+	for _, spec := range zshFlagSpecs(docFlags(flag.CommandLine)) {
+		fmt.Fprintf(w, "\t\t%s \\\n", spec)
+	}
+	fmt.Fprintln(w, "\t\t'1: :->command' \\")
+	fmt.Fprintln(w, "\t\t'*:: :->args'")
+	fmt.Fprintln(w, "\tcase $state in")
+	fmt.Fprintln(w, "\tcommand) _describe -t commands command commands ;;")
+	fmt.Fprintln(w, "\targs)")
+	fmt.Fprintln(w, "\t\tcase $line[1] in")
+	for _, c := range subcommands() {
+		specs := zshFlagSpecs(subcommandFlags(c))
+		if len(c.words) > 0 {
+			specs = append(specs, zshQuote("1:argument:("+strings.Join(c.words, " ")+")"))
+		} else {
+			specs = append(specs, "'*:file:_files'")
+		}
+		fmt.Fprintf(w, "\t\t%s) _arguments %s ;;\n", c.name, strings.Join(specs, " "))
+	}
+	fmt.Fprintln(w, "\t\tesac")
+	fmt.Fprintln(w, "\t\t;;")
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "_ovms_exporter \"$@\"")
+}
+
+// fishQuote quotes a fish argument.
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+// writeFishFlags writes the completion of the flags under a condition.
+func writeFishFlags(w io.Writer, condition string, flags []docFlag) {
+	for _, f := range flags {
+		value := ""
+		if f.takesValue() {
+			value = " -r -F"
+		}
+		fmt.Fprintf(w, "complete -c ovms_exporter -n %s -o %s%s -d %s\n", fishQuote(condition), f.name, value, fishQuote(f.usage))
+	}
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprintln(w, "# fish completion for ovms_exporter, generated by ovms_exporter completion fish.")
+	fmt.Fprintln(w, "complete -c ovms_exporter -f")
+	writeFishFlags(w, "__fish_use_subcommand", docFlags(flag.CommandLine))
+	for _, c := range subcommands() {
+		fmt.Fprintf(w, "complete -c ovms_exporter -n __fish_use_subcommand -a %s -d %s\n", c.name, fishQuote(c.synopsis))
+	}
+	for _, c := range subcommands() {
+		condition := "__fish_seen_subcommand_from " + c.name
+		writeFishFlags(w, condition, subcommandFlags(c))
+		if len(c.words) > 0 {
+			fmt.Fprintf(w, "complete -c ovms_exporter -n %s -a %s\n", fishQuote(condition), fishQuote(strings.Join(c.words, " ")))
+		} else {
+			fmt.Fprintf(w, "complete -c ovms_exporter -n %s -F\n", fishQuote(condition))
+		}
+	}
+}
+
+// completionCommand returns the flags of the completion command, none, and its
+// function.
+func completionCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("completion", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), completionUsage) }
+	return fs, func(args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("%s", completionUsage)
+		}
+		w := bufio.NewWriter(os.Stdout)
+		switch args[0] {
+		case "bash":
+			writeBashCompletion(w)
+		case "zsh":
+			writeZshCompletion(w)
+		case "fish":
+			writeFishCompletion(w)
+		default:
+			return fmt.Errorf("unknown shell %q\n%s", args[0], completionUsage)
+		}
+		return w.Flush()
+	}
+}
+
+// roffEscape escapes the text of a man page line.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// writeManFlags writes the flags as a man page list.
+func writeManFlags(w io.Writer, flags []docFlag) {
+	for _, f := range flags {
+		fmt.Fprintln(w, ".TP")
+		if f.takesValue() {
+			fmt.Fprintf(w, ".BI %s \" %s\"\n", roffEscape("-"+f.name), roffEscape(f.arg))
+		} else {
+			fmt.Fprintf(w, ".B %s\n", roffEscape("-"+f.name))
+		}
+		usage := f.usage
+		if f.def != "" && f.def != "false" && f.def != "0" {
+			usage += fmt.Sprintf(" (default %q)", f.def)
+		}
+		if env, ok := envFlags[f.name]; ok {
+			usage += " (default $" + env + ")"
+		}
+		fmt.Fprintln(w, roffEscape(usage))
+	}
+}
+
+func writeMan(w io.Writer) {
+	fmt.Fprintf(w, ".TH OVMS_EXPORTER 1 \"\" \"%s\" \"User Commands\"\n", roffEscape("ovms_exporter "+version))
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `ovms_exporter \- Prometheus exporter for the Open Vehicle Monitoring System`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	fmt.Fprintln(w, ".B ovms_exporter")
+	fmt.Fprintln(w, `[\fIflags\fR] [\fIcommand\fR [\fIcommand flags\fR] [\fIargs\fR]]`)
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, ".B ovms_exporter")
+	fmt.Fprintln(w, "polls the records of the vehicles from an OVMS server and exposes them as")
+	fmt.Fprintln(w, "Prometheus metrics, along with the metrics derived from them. With a")
+	fmt.Fprintln(w, "command, it runs the command instead.")
+	fmt.Fprintln(w, ".SH OPTIONS")
+	writeManFlags(w, docFlags(flag.CommandLine))
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, c := range subcommands() {
+		fmt.Fprintf(w, ".SS %s\n", roffEscape(c.name))
+		fmt.Fprintln(w, roffEscape(c.synopsis)+".")
+		if len(c.words) > 0 {
+			fmt.Fprintln(w, ".br")
+			fmt.Fprintln(w, roffEscape("Arguments: "+strings.Join(c.words, ", ")+"."))
+		}
+		writeManFlags(w, subcommandFlags(c))
+	}
+	fmt.Fprintln(w, ".SH ENVIRONMENT")
+	for _, f := range docFlags(flag.CommandLine) {
+		if env, ok := envFlags[f.name]; ok {
+			fmt.Fprintln(w, ".TP")
+			fmt.Fprintf(w, ".B %s\n", roffEscape(env))
+			fmt.Fprintln(w, roffEscape("Default of -"+f.name+"."))
+		}
+	}
+}
+
+// manCommand returns the flags of the man command, none, and its function.
+func manCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("man", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), manUsage) }
+	return fs, func(args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%s", manUsage)
+		}
+		w := bufio.NewWriter(os.Stdout)
+		writeMan(w)
+		return w.Flush()
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+var configFileFlag = flag.String("config", "", "JSON configuration file: a path, an http(s) URL or git+<repo>#<path>")
+
+// config is the optional configuration loaded from -config.
+type config struct {
+	Geofences    []geofence       `json:"geofences"`
+	Schedules    []*schedule      `json:"schedules"`
+	Tariff       *tariff          `json:"tariff"`
+	Services     []*service       `json:"services"`
+	Burst        *burstConfig     `json:"burst"`
+	Renames      []*renameRule    `json:"renames"`
+	PlannedTrips []*plannedTrip   `json:"planned_trips"`
+	Derived      []*derivedMetric `json:"derived_metrics"`
+	Notifiers    []*notifier      `json:"notifiers"`
+	ChargeLimits []*chargeLimit   `json:"charge_limits"`
+	// VehicleTypes are the OVMS type codes of the vehicles, e.g. RT, for
+	// the servers not sending their F record.
+	VehicleTypes map[string]string `json:"vehicle_types"`
+	// Vehicles override the server, the credentials and the poll interval
+	// of some vehicles.
+	Vehicles []*vehicleConfig `json:"vehicles"`
+
+	renames map[string]*renameRule
+}
+
+// currentConfig is the active configuration.
+var currentConfig atomic.Pointer[config]
+
+func init() {
+	currentConfig.Store(&config{})
+}
+
+// cfg returns the active configuration. The returned value must not be
+// modified since it is shared.
+func cfg() *config {
+	return currentConfig.Load()
+}
+
+func loadConfig(path string) (*config, error) {
+	c := &config{}
+	if path == "" {
+		return c, nil
+	}
+	data, err := readConfigSource(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing %q: %v", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %q: %v", path, err)
+	}
+	return c, nil
+}
+
+func (c *config) validate() error {
+	names := map[string]bool{}
+	for _, g := range c.Geofences {
+		if g.Name == "" {
+			return fmt.Errorf("geofence without a name")
+		}
+		if names[g.Name] {
+			return fmt.Errorf("duplicate geofence %q", g.Name)
+		}
+		names[g.Name] = true
+		if g.RadiusMeters <= 0 {
+			return fmt.Errorf("geofence %q: radius_meters must be positive", g.Name)
+		}
+	}
+	if c.Tariff != nil {
+		if err := c.Tariff.validate(); err != nil {
+			return err
+		}
+	}
+	names = map[string]bool{}
+	for _, s := range c.Schedules {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		if names[s.Name] {
+			return fmt.Errorf("duplicate schedule %q", s.Name)
+		}
+		names[s.Name] = true
+	}
+	if c.Burst != nil {
+		if err := c.Burst.validate(); err != nil {
+			return err
+		}
+	}
+	names = map[string]bool{}
+	for _, p := range c.PlannedTrips {
+		if err := p.validate(); err != nil {
+			return err
+		}
+		if names[p.Vehicle+"/"+p.Name] {
+			return fmt.Errorf("duplicate planned trip %q", p.Name)
+		}
+		names[p.Vehicle+"/"+p.Name] = true
+	}
+	c.renames = map[string]*renameRule{}
+	names = map[string]bool{}
+	for _, r := range c.Renames {
+		if err := r.validate(); err != nil {
+			return err
+		}
+		if c.renames[r.From] != nil {
+			return fmt.Errorf("duplicate rename of %q", r.From)
+		}
+		if names[r.To] {
+			return fmt.Errorf("duplicate rename to %q", r.To)
+		}
+		c.renames[r.From] = r
+		names[r.To] = true
+	}
+	names = map[string]bool{}
+	for _, d := range c.Derived {
+		if err := d.validate(); err != nil {
+			return err
+		}
+		if names[d.Vehicle+"/"+d.Name] {
+			return fmt.Errorf("duplicate derived metric %q", d.Name)
+		}
+		names[d.Vehicle+"/"+d.Name] = true
+	}
+	names = map[string]bool{}
+	for _, s := range c.Services {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		if names[s.Vehicle+"/"+s.Name] {
+			return fmt.Errorf("duplicate service %q", s.Name)
+		}
+		names[s.Vehicle+"/"+s.Name] = true
+	}
+	names = map[string]bool{}
+	for _, n := range c.Notifiers {
+		if err := n.validate(); err != nil {
+			return err
+		}
+		if names[n.Name] {
+			return fmt.Errorf("duplicate notifier %q", n.Name)
+		}
+		names[n.Name] = true
+	}
+	names = map[string]bool{}
+	for _, l := range c.ChargeLimits {
+		if err := l.validate(); err != nil {
+			return err
+		}
+		if names[l.Vehicle] {
+			return fmt.Errorf("duplicate charge limit of %q", l.Vehicle)
+		}
+		names[l.Vehicle] = true
+	}
+	names = map[string]bool{}
+	for _, vc := range c.Vehicles {
+		if err := vc.validate(); err != nil {
+			return err
+		}
+		if names[vc.Vehicle] {
+			return fmt.Errorf("duplicate vehicles entry of %q", vc.Vehicle)
+		}
+		names[vc.Vehicle] = true
+	}
+	return validateVehicleTypes(c.VehicleTypes)
+}
+
+// refreshConfig periodically reloads the config. A config that fails to load
+// or validate is logged and ignored, the previous one stays active.
+func refreshConfig(path string, every time.Duration) {
+	for {
+		time.Sleep(every)
+		c, err := loadConfig(path)
+		if err != nil {
+			slog.Error("error reloading the config", "source", path, "err", err)
+			continue
+		}
+		currentConfig.Store(c)
+		slog.Debug("config reloaded", "source", path)
+	}
+}
+
+// secretFlags are the flags whose values are redacted by handleConfig.
+var secretFlags = map[string]bool{
+	"password":          true,
+	"token":             true,
+	"admin-token":       true,
+	"vehicle-password":  true,
+	"openchargemap-key": true,
+	"abrp-token":        true,
+	"abrp-api-key":      true,
+	"command-token":     true,
+}
+
+// redactURL hides the password of the URLs with user information.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	return u.Redacted()
+}
+
+// handleConfig serves the effective flags and config, with the secrets
+// redacted.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	flags := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		v := f.Value.String()
+		if secretFlags[f.Name] && v != "" {
+			v = "REDACTED"
+		}
+		flags[f.Name] = redactURL(v)
+	})
+	writeJSON(w, struct {
+		Flags  map[string]string `json:"flags"`
+		Config *config           `json:"config"`
+	}{flags, cfg().redacted()})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	configRefreshFlag   = flag.Duration("config-refresh", 0, "How often to reload -config; 0 disables reloading")
+	configPublicKeyFlag = flag.String("config-public-key", "", "Ed25519 public key (PEM file or base64) the config must be signed with; the detached signature is read from <config>.sig")
+	configGitRefFlag    = flag.String("config-git-ref", "", "Branch or tag to use for git+ config sources; empty uses the default branch")
+)
+
+// maxConfigSize caps the size of a config fetched over HTTP.
+const maxConfigSize = 1 << 20
+
+// readConfigSource reads the config from one of:
+//
+//	/path/to/config.json
+//	https://example.com/config.json
+//	git+https://example.com/repo.git#path/to/config.json
+//
+// and verifies its signature if -config-public-key is set.
+func readConfigSource(source string) ([]byte, error) {
+	read := readFileSource
+	switch {
+	case strings.HasPrefix(source, "git+"):
+		repo, path, ok := strings.Cut(strings.TrimPrefix(source, "git+"), "#")
+		if !ok || path == "" {
+			return nil, fmt.Errorf("git config source %q: missing #path", redactURL(source))
+		}
+		dir, err := syncGitRepo(repo)
+		if err != nil {
+			return nil, err
+		}
+		source = filepath.Join(dir, filepath.FromSlash(path))
+	case strings.HasPrefix(source, "https://"), strings.HasPrefix(source, "http://"):
+		read = readHTTPSource
+	}
+
+	data, err := read(source)
+	if err != nil {
+		return nil, err
+	}
+	if *configPublicKeyFlag == "" {
+		return data, nil
+	}
+	sig, err := read(source + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("error reading the config signature: %v", err)
+	}
+	if err := verifyConfig(data, sig); err != nil {
+		return nil, fmt.Errorf("config %q: %v", redactURL(source), err)
+	}
+	return data, nil
+}
+
+func readFileSource(path string) ([]byte, error) {
+	return os.ReadFile(path)
+}
+
+func readHTTPSource(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	// The errors name the URL without its credentials, if any.
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("GET %s: %v", redactURL(url), withoutURL(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", redactURL(url), resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConfigSize {
+		return nil, fmt.Errorf("GET %s: config larger than %d bytes", redactURL(url), maxConfigSize)
+	}
+	return data, nil
+}
+
+// syncGitRepo clones the repository, or updates an existing clone, and
+// returns its directory. The clone lives in -state-dir if set.
+func syncGitRepo(repo string) (string, error) {
+	base := *stateDirFlag
+	if base == "" {
+		base = os.TempDir()
+	}
+	// The directory is named after a hash of the repository URL, which may
+	// carry credentials.
+	sum := sha256.Sum256([]byte(repo))
+	dir := filepath.Join(base, "config-git", hex.EncodeToString(sum[:16]))
+	ref := *configGitRefFlag
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+			return "", err
+		}
+		args := []string{"clone", "--quiet", "--depth", "1"}
+		if *configGitRefFlag != "" {
+			args = append(args, "--branch", *configGitRefFlag)
+		}
+		return dir, runGit(append(args, repo, dir)...)
+	}
+	if err := runGit("-C", dir, "fetch", "--quiet", "--depth", "1", "origin", ref); err != nil {
+		return "", err
+	}
+	return dir, runGit("-C", dir, "reset", "--quiet", "--hard", "FETCH_HEAD")
+}
+
+// runGit runs git, the error naming the repository URLs in the arguments
+// without their credentials, if any, also in the output of git.
+func runGit(args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		redacted := make([]string, len(args))
+		out := strings.TrimSpace(stderr.String())
+		for i, a := range args {
+			redacted[i] = redactURL(a)
+			if redacted[i] != a {
+				out = strings.ReplaceAll(out, a, redacted[i])
+			}
+		}
+		return fmt.Errorf("git %s: %v: %s", strings.Join(redacted, " "), err, out)
+	}
+	return nil
+}
+
+// verifyConfig checks the detached Ed25519 signature of the config. The
+// signature may be raw or base64 encoded.
+func verifyConfig(data, sig []byte) error {
+	pub, err := configPublicKey()
+	if err != nil {
+		return err
+	}
+	if len(sig) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+		if err != nil {
+			return fmt.Errorf("invalid signature encoding: %v", err)
+		}
+		sig = decoded
+	}
+	if !ed25519.Verify(pub, data, sig) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// configPublicKey parses -config-public-key, either a PEM file as written by
+// "openssl pkey -pubout" or the base64 encoded raw key.
+func configPublicKey() (ed25519.PublicKey, error) {
+	s := *configPublicKeyFlag
+	if data, err := os.ReadFile(s); err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -config-public-key: %v", err)
+			}
+			pub, ok := key.(ed25519.PublicKey)
+			if !ok {
+				return nil, errors.New("invalid -config-public-key: not an Ed25519 key")
+			}
+			return pub, nil
+		}
+		s = string(data)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid -config-public-key")
+	}
+	return ed25519.PublicKey(raw), nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCronMatches(t *testing.T) {
+	// 2024-01-07 is a Sunday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+	for _, tt := range []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", at(1, 0, 0), true},
+		{"0 3 * * *", at(1, 3, 0), true},
+		{"0 3 * * *", at(1, 3, 1), false},
+		{"0 3 * * *", at(1, 4, 0), false},
+		{"*/15 * * * *", at(1, 10, 45), true},
+		{"*/15 * * * *", at(1, 10, 50), false},
+		{"10/20 * * * *", at(1, 10, 30), true},
+		{"10/20 * * * *", at(1, 10, 40), false},
+		{"0-10/5 * * * *", at(1, 10, 10), true},
+		{"0-10/5 * * * *", at(1, 10, 15), false},
+		{"30 6 1,15 * *", at(15, 6, 30), true},
+		{"30 6 1,15 * *", at(14, 6, 30), false},
+		{"* * * 2 *", at(1, 0, 0), false},
+		// Monday to Friday.
+		{"0 8 * * 1-5", at(8, 8, 0), true},
+		{"0 8 * * 1-5", at(7, 8, 0), false},
+		// Sunday is 0 or 7.
+		{"0 8 * * 0", at(7, 8, 0), true},
+		{"0 8 * * 7", at(7, 8, 0), true},
+		{"0 8 * * 5-7", at(7, 8, 0), true},
+		{"0 8 * * 7", at(8, 8, 0), false},
+		// Either day field matches when both are restricted.
+		{"0 0 1 * 1", at(1, 0, 0), true},
+		{"0 0 2 * 1", at(8, 0, 0), true},
+		{"0 0 2 * 1", at(9, 0, 0), false},
+		// Only the restricted one when the other is *.
+		{"0 0 2 * *", at(8, 0, 0), false},
+		{"0 0 * * 1", at(2, 0, 0), false},
+	} {
+		s, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.matches(tt.t); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.expr, tt.t.Format("Mon 2006-01-02 15:04"), got, tt.want)
+		}
+	}
+}
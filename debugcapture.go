@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	debugCaptureDirFlag       = flag.String("debug-capture-dir", "", "Directory where the raw responses with parse errors are saved once they exceed -debug-capture-threshold; empty disables the capture")
+	debugCaptureThresholdFlag = flag.Int("debug-capture-threshold", 3, "Number of polls with parse errors within an hour from which the responses are captured")
+	debugCaptureMaxFlag       = flag.Int("debug-capture-max", 50, "Maximum number of captured responses kept per vehicle, the oldest are removed")
+)
+
+var (
+	parseErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_parse_errors_total",
+		Help: "Number of polls whose response or records could not be parsed.",
+	}, []string{"vehicle"})
+	debugCapturesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_debug_captures_total",
+		Help: "Number of raw responses saved to -debug-capture-dir.",
+	}, []string{"vehicle"})
+)
+
+const (
+	// debugCaptureWindow is the window in which the parse errors are counted.
+	debugCaptureWindow = time.Hour
+	// debugCaptureInterval is the minimum time between the captures of a
+	// vehicle.
+	debugCaptureInterval = 10 * time.Minute
+)
+
+// debugCapture saves the raw responses with parse errors, once they are
+// frequent enough to be worth reporting.
+type debugCapture struct {
+	vehicle string
+
+	mu     sync.Mutex
+	errors []time.Time
+	last   time.Time
+}
+
+// check records the parse errors of a poll and captures its raw response
+// if needed.
+func (c *debugCapture) check(malformed bool, diagnostics []recordDiagnostic, raw *rawCapture) {
+	failed := malformed
+	for _, d := range diagnostics {
+		failed = failed || d.Error != ""
+	}
+	if !failed {
+		return
+	}
+	parseErrorsTotal.WithLabelValues(c.vehicle).Inc()
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errors = append(c.errors, now)
+	for len(c.errors) > 0 && now.Sub(c.errors[0]) > debugCaptureWindow {
+		c.errors = c.errors[1:]
+	}
+	if *debugCaptureDirFlag == "" || len(c.errors) < *debugCaptureThresholdFlag || now.Sub(c.last) < debugCaptureInterval {
+		return
+	}
+	c.last = now
+	if err := c.save(raw.report(c.vehicle)); err != nil {
+		slog.Error("error saving the debug capture", "vehicle", c.vehicle, "err", err)
+	}
+}
+
+// save writes the report to <dir>/<vehicle>/<time>.json and removes the
+// oldest captures beyond -debug-capture-max.
+func (c *debugCapture) save(r rawReport) error {
+	dir := filepath.Join(*debugCaptureDirFlag, c.vehicle)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(dir, r.Time.UTC().Format(dumpTimeFormat)+".json")
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		return err
+	}
+	debugCapturesTotal.WithLabelValues(c.vehicle).Inc()
+	slog.Warn("parse errors, captured the raw response", "vehicle", c.vehicle, "file", name, "errors", len(c.errors))
+
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > *debugCaptureMaxFlag {
+		if err := os.Remove(names[0]); err != nil {
+			return err
+		}
+		names = names[1:]
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// firmwareCode is the code of the firmware record, which carries the
+// vehicle type:
+//
+//	F<firmware>,<vin>,<gsm signal>,<can write>,<vehicle type>,<gsm provider>,...
+const firmwareCode = "F"
+
+var (
+	vehicleInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_vehicle_info",
+		Help: "Type, model and module firmware of the vehicle, from the F record or the vehicle_types of the config.",
+	}, []string{"vehicle", "type", "model", "firmware"})
+	tpmsPressurePSI = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_tpms_pressure_psi",
+		Help: "Tire pressure in PSI, from the W record of the Tesla Roadster.",
+	}, []string{"vehicle", "wheel"})
+	tpmsTemperature = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_tpms_temperature",
+		Help: "Tire temperature in °C, from the W record of the Tesla Roadster.",
+	}, []string{"vehicle", "wheel"})
+)
+
+// vehicleDecoder interprets the vendor-specific data of a vehicle type: the
+// custom records the OVMS module of the type sends besides the standard S,
+// D, L and Y ones.
+type vehicleDecoder struct {
+	model string
+	// records decode the custom records by code.
+	records map[string]func(v *vehicle, data []string, ts time.Time)
+	// historical decode the custom historical records by type, the record
+	// number being e.g. a cell number. They are fetched with -battery-cells.
+	historical map[string]func(v *vehicle, number int, data string, ts time.Time)
+}
+
+// vehicleDecoders are the decoders by OVMS vehicle type code. The Nissan Leaf
+// sends no custom record over protocol v2, its entry only names the model.
+var vehicleDecoders = map[string]*vehicleDecoder{
+	"NL": {model: "Nissan Leaf"},
+	"RT": {
+		model: "Renault Twizy",
+		historical: map[string]func(v *vehicle, number int, data string, ts time.Time){
+			cellVoltageRecord:       decodeBatteryRecord(cellVoltageRecord),
+			moduleTemperatureRecord: decodeBatteryRecord(moduleTemperatureRecord),
+		},
+	},
+	"TR": {
+		model: "Tesla Roadster",
+		records: map[string]func(v *vehicle, data []string, ts time.Time){
+			"W": decodeRoadsterTPMS,
+		},
+	},
+}
+
+// decodeBatteryRecord returns the decoder of an extended battery record of
+// the Twizy battery monitor.
+func decodeBatteryRecord(recordType string) func(v *vehicle, number int, data string, ts time.Time) {
+	return func(v *vehicle, number int, data string, ts time.Time) {
+		v.cells.update(recordType, number, data, ts)
+	}
+}
+
+// roadsterWheels are the wheels of the W record, in order.
+var roadsterWheels = []string{"fr", "rr", "fl", "rl"}
+
+// decodeRoadsterTPMS decodes the legacy TPMS record of the Roadster:
+//
+//	W<fr pressure>,<fr temp>,<rr pressure>,<rr temp>,<fl pressure>,<fl temp>,<rl pressure>,<rl temp>,<stale pressure>,<stale temp>
+//
+// The stale flags are -1 without data, 0 stale and 1 fresh.
+func decodeRoadsterTPMS(v *vehicle, data []string, ts time.Time) {
+	if len(data) < 10 {
+		return
+	}
+	for i, wheel := range roadsterWheels {
+		if p, err := strconv.ParseFloat(data[2*i], 64); err == nil && data[8] != "-1" {
+			tpmsPressurePSI.WithLabelValues(v.id, wheel).Set(p)
+		}
+		if t, err := strconv.ParseFloat(data[2*i+1], 64); err == nil && data[9] != "-1" {
+			tpmsTemperature.WithLabelValues(v.id, wheel).Set(t)
+		}
+	}
+}
+
+// decodedCode reports whether the records of a code that is not a standard
+// one are decoded, for some vehicle type.
+func decodedCode(code string) bool {
+	if code == firmwareCode {
+		return true
+	}
+	for _, d := range vehicleDecoders {
+		if d.records[code] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// typeTracker holds the vehicle type reported by the F record.
+type typeTracker struct {
+	mu       sync.Mutex
+	detected string
+	firmware string
+	// info are the labels of ovms_vehicle_info, nil if not set.
+	info []string
+}
+
+// vehicleType returns the type code of the vehicle: the one of the config if
+// any, otherwise the one of the F record, empty if unknown.
+func (v *vehicle) vehicleType() string {
+	if t := cfg().VehicleTypes[v.id]; t != "" {
+		return t
+	}
+	v.vehicleTypes.mu.Lock()
+	defer v.vehicleTypes.mu.Unlock()
+	return v.vehicleTypes.detected
+}
+
+// decoder returns the decoder of the vehicle type, nil if none.
+func (v *vehicle) decoder() *vehicleDecoder {
+	return vehicleDecoders[v.vehicleType()]
+}
+
+// decodeCustomRecord processes a record of a code that is not a standard
+// one: the F record, or a custom record of the decoder of the vehicle type.
+func (v *vehicle) decodeCustomRecord(rec record, ts time.Time) {
+	data := strings.Split(rec.Msg, ",")
+	if rec.Code == firmwareCode {
+		if len(data) < 5 {
+			return
+		}
+		v.vehicleTypes.mu.Lock()
+		v.vehicleTypes.detected, v.vehicleTypes.firmware = strings.TrimSpace(data[4]), data[0]
+		v.vehicleTypes.mu.Unlock()
+		return
+	}
+	if d := v.decoder(); d != nil && d.records[rec.Code] != nil {
+		d.records[rec.Code](v, data, ts)
+	}
+}
+
+// updateVehicleInfo sets ovms_vehicle_info when the type or the firmware
+// changed, e.g. with the config reloaded.
+func (v *vehicle) updateVehicleInfo() {
+	t := v.vehicleType()
+	var model string
+	if d := vehicleDecoders[t]; d != nil {
+		model = d.model
+	}
+	v.vehicleTypes.mu.Lock()
+	defer v.vehicleTypes.mu.Unlock()
+	info := []string{v.id, t, model, v.vehicleTypes.firmware}
+	if t == "" || slices.Equal(info, v.vehicleTypes.info) {
+		return
+	}
+	vehicleInfo.DeletePartialMatch(prometheus.Labels{"vehicle": v.id})
+	vehicleInfo.WithLabelValues(info...).Set(1)
+	v.vehicleTypes.info = info
+}
+
+// validateVehicleTypes checks the vehicle_types of the config.
+func validateVehicleTypes(types map[string]string) error {
+	for id, t := range types {
+		if vehicleDecoders[t] == nil {
+			var known []string
+			for k := range vehicleDecoders {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("vehicle_types: unknown type %q of vehicle %q, expected one of %s", t, id, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
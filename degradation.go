@@ -0,0 +1,285 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var degradationWindowsFlag = flag.String("degradation-windows", "7,30,365", "Comma-separated windows, in days, for the SOH and CAC trend metrics")
+
+// degradationStateFile is the state file name pattern, %s being the vehicle ID.
+const degradationStateFile = "degradation_%s.json"
+
+// legacyDegradationStateFile is the state file of the single vehicle polled
+// before -vehicle took several, taken over by the first one.
+const legacyDegradationStateFile = "degradation.json"
+
+var (
+	sohTrend = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_bat_soh_trend",
+		Help: "Battery state of health over a window, in percent.",
+	}, []string{"vehicle", "window", "stat"})
+	cacTrend = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_bat_cac_trend",
+		Help: "Battery capacity (CAC) over a window, in Ah.",
+	}, []string{"vehicle", "window", "stat"})
+)
+
+// dailyStat aggregates the values seen on one day.
+type dailyStat struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Sum   float64 `json:"sum"`
+	Count int     `json:"count"`
+}
+
+func (d *dailyStat) add(v float64) {
+	if d.Count == 0 || v < d.Min {
+		d.Min = v
+	}
+	if d.Count == 0 || v > d.Max {
+		d.Max = v
+	}
+	d.Sum += v
+	d.Count++
+}
+
+// degradationDay holds the SOH and CAC aggregates of one UTC day.
+type degradationDay struct {
+	Date string    `json:"date"`
+	SOH  dailyStat `json:"soh"`
+	CAC  dailyStat `json:"cac"`
+}
+
+// degradationTracker keeps daily SOH/CAC aggregates for the longest window.
+type degradationTracker struct {
+	vehicle string
+
+	mu      sync.Mutex
+	windows []int
+	Days    []*degradationDay `json:"days"`
+	LastTS  time.Time         `json:"last_ts"`
+}
+
+func parseWindows(s string) ([]int, error) {
+	var windows []int
+	for _, w := range strings.Split(s, ",") {
+		days, err := strconv.Atoi(strings.TrimSpace(w))
+		if err != nil || days <= 0 {
+			return nil, fmt.Errorf("invalid -degradation-windows entry %q", w)
+		}
+		windows = append(windows, days)
+	}
+	return windows, nil
+}
+
+// init loads the persisted state. It must be called after flag.Parse.
+func (d *degradationTracker) init() error {
+	windows, err := parseWindows(*degradationWindowsFlag)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.windows = windows
+	if err := d.migrateState(); err != nil {
+		return fmt.Errorf("error migrating %s: %v", legacyDegradationStateFile, err)
+	}
+	if err := readState(d.stateFile(), d); err != nil {
+		return fmt.Errorf("error loading %s: %v", d.stateFile(), err)
+	}
+	d.export()
+	return nil
+}
+
+// update processes the fields of an S record.
+func (d *degradationTracker) update(fields map[string]string, ts time.Time) {
+	soh, err1 := strconv.ParseFloat(fields["ms_v_bat_soh"], 64)
+	cac, err2 := strconv.ParseFloat(fields["ms_v_bat_cac"], 64)
+	if err1 != nil || err2 != nil || soh <= 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The same record is returned until the vehicle sends a new one.
+	if !ts.After(d.LastTS) {
+		return
+	}
+	d.LastTS = ts
+
+	date := ts.UTC().Format("2006-01-02")
+	if n := len(d.Days); n == 0 || d.Days[n-1].Date != date {
+		d.Days = append(d.Days, &degradationDay{Date: date})
+	}
+	day := d.Days[len(d.Days)-1]
+	day.SOH.add(soh)
+	if cac > 0 {
+		day.CAC.add(cac)
+	}
+
+	d.expire(ts)
+	d.export()
+	if err := writeState(d.stateFile(), d); err != nil {
+		slog.Error("error saving state", "file", d.stateFile(), "err", err)
+	}
+}
+
+func (d *degradationTracker) stateFile() string {
+	return fmt.Sprintf(degradationStateFile, url.PathEscape(d.vehicle))
+}
+
+// migrateState renames the legacy state file, if any, to the state file of
+// the vehicle if it has none, so that the first vehicle of -vehicle keeps the
+// history recorded before the vehicles had their own.
+func (d *degradationTracker) migrateState() error {
+	if *stateDirFlag == "" {
+		return nil
+	}
+	legacy := filepath.Join(*stateDirFlag, legacyDegradationStateFile)
+	if _, err := os.Stat(legacy); os.IsNotExist(err) {
+		return nil
+	}
+	name := filepath.Join(*stateDirFlag, d.stateFile())
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		return err
+	}
+	slog.Info("migrating the degradation history", "vehicle", d.vehicle, "from", legacyDegradationStateFile, "to", d.stateFile())
+	return os.Rename(legacy, name)
+}
+
+// expire drops the days older than the longest window.
+func (d *degradationTracker) expire(now time.Time) {
+	longest := 0
+	for _, w := range d.windows {
+		if w > longest {
+			longest = w
+		}
+	}
+	cutoff := now.UTC().AddDate(0, 0, -longest).Format("2006-01-02")
+	i := 0
+	for i < len(d.Days) && d.Days[i].Date <= cutoff {
+		i++
+	}
+	d.Days = d.Days[i:]
+}
+
+func (d *degradationTracker) export() {
+	if len(d.Days) == 0 {
+		return
+	}
+	last, err := time.Parse("2006-01-02", d.Days[len(d.Days)-1].Date)
+	if err != nil {
+		return
+	}
+	for _, w := range d.windows {
+		soh, cac := d.window(last, w)
+		window := fmt.Sprintf("%dd", w)
+		exportStat(sohTrend.MustCurryWith(prometheus.Labels{"vehicle": d.vehicle}), window, soh)
+		exportStat(cacTrend.MustCurryWith(prometheus.Labels{"vehicle": d.vehicle}), window, cac)
+	}
+}
+
+// window aggregates the days of a window ending on the day last. d.mu must be
+// held.
+func (d *degradationTracker) window(last time.Time, days int) (soh, cac dailyStat) {
+	cutoff := last.AddDate(0, 0, -days).Format("2006-01-02")
+	for _, day := range d.Days {
+		if day.Date <= cutoff {
+			continue
+		}
+		merge(&soh, day.SOH)
+		merge(&cac, day.CAC)
+	}
+	return soh, cac
+}
+
+// degradationSummary is the average SOH and CAC over a window.
+type degradationSummary struct {
+	Window string   `json:"window"`
+	SOH    *float64 `json:"soh_avg,omitempty"`
+	CAC    *float64 `json:"cac_avg,omitempty"`
+}
+
+// summary returns the averages over each of the -degradation-windows.
+func (d *degradationTracker) summary() []degradationSummary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.Days) == 0 {
+		return nil
+	}
+	last, err := time.Parse("2006-01-02", d.Days[len(d.Days)-1].Date)
+	if err != nil {
+		return nil
+	}
+	var s []degradationSummary
+	for _, w := range d.windows {
+		soh, cac := d.window(last, w)
+		ds := degradationSummary{Window: fmt.Sprintf("%dd", w)}
+		if soh.Count > 0 {
+			avg := soh.Sum / float64(soh.Count)
+			ds.SOH = &avg
+		}
+		if cac.Count > 0 {
+			avg := cac.Sum / float64(cac.Count)
+			ds.CAC = &avg
+		}
+		s = append(s, ds)
+	}
+	return s
+}
+
+func merge(dst *dailyStat, src dailyStat) {
+	if src.Count == 0 {
+		return
+	}
+	if dst.Count == 0 || src.Min < dst.Min {
+		dst.Min = src.Min
+	}
+	if dst.Count == 0 || src.Max > dst.Max {
+		dst.Max = src.Max
+	}
+	dst.Sum += src.Sum
+	dst.Count += src.Count
+}
+
+func exportStat(g *prometheus.GaugeVec, window string, s dailyStat) {
+	if s.Count == 0 {
+		return
+	}
+	g.WithLabelValues(window, "min").Set(s.Min)
+	g.WithLabelValues(window, "max").Set(s.Max)
+	g.WithLabelValues(window, "avg").Set(s.Sum / float64(s.Count))
+}
+
+func (d *degradationTracker) snapshot() []degradationDay {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	days := make([]degradationDay, len(d.Days))
+	for i, day := range d.Days {
+		days[i] = *day
+	}
+	return days
+}
+
+// purge forgets all the history, including the persisted state.
+func (d *degradationTracker) purge() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Days = nil
+	d.LastTS = time.Time{}
+	sohTrend.DeletePartialMatch(prometheus.Labels{"vehicle": d.vehicle})
+	cacTrend.DeletePartialMatch(prometheus.Labels{"vehicle": d.vehicle})
+	return removeState(d.stateFile())
+}
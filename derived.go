@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// derivedCode is the record code under which the derived metrics are stored.
+const derivedCode = "derived"
+
+// derivedNameRE matches the names of the derived metrics, exported as
+// ovms_<name>.
+var derivedNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// derivedMetric is a metric computed from the fields of the latest records,
+// e.g. {"name": "soc_kwh", "expr": "ms_v_bat_soc / 100 * capacity_kwh",
+// "constants": {"capacity_kwh": 64}}. The variables are the constants and
+// the fields, named field, code.field or ovms_code_field; a bare field name
+// is looked up in the S, D, L then Y record. A metric of a vehicle replaces
+// the one of the same name for all the vehicles.
+type derivedMetric struct {
+	Name      string             `json:"name"`
+	Vehicle   string             `json:"vehicle,omitempty"`
+	Expr      string             `json:"expr"`
+	Constants map[string]float64 `json:"constants,omitempty"`
+
+	expr expr
+}
+
+func (d *derivedMetric) validate() error {
+	if !derivedNameRE.MatchString(d.Name) {
+		return fmt.Errorf("invalid derived metric name %q", d.Name)
+	}
+	e, err := parseExpr(d.Expr)
+	if err != nil {
+		return fmt.Errorf("derived metric %q: %v", d.Name, err)
+	}
+	for _, name := range exprVars(e) {
+		if _, ok := d.Constants[name]; ok {
+			continue
+		}
+		if _, _, ok := derivedField(name); !ok {
+			return fmt.Errorf("derived metric %q: unknown variable %q", d.Name, name)
+		}
+	}
+	d.expr = e
+	return nil
+}
+
+// derivedCodes are the codes in which the bare field names are looked up.
+var derivedCodes = []string{"S", "D", "L", "Y"}
+
+// derivedField returns the record code and the field of a variable.
+func derivedField(name string) (string, string, bool) {
+	if code, field, ok := strings.Cut(name, "."); ok {
+		return code, field, contains(metricsMap[code], field)
+	}
+	if rest, ok := strings.CutPrefix(name, "ovms_"); ok {
+		if code, field, ok := strings.Cut(rest, "_"); ok && contains(metricsMap[code], field) {
+			return code, field, true
+		}
+	}
+	for _, code := range derivedCodes {
+		if contains(metricsMap[code], name) {
+			return code, name, true
+		}
+	}
+	return "", "", false
+}
+
+// derivedMetrics returns the derived metrics of the config that apply to a
+// vehicle.
+func derivedMetrics(vehicleID string) []*derivedMetric {
+	specific := map[string]bool{}
+	for _, d := range cfg().Derived {
+		if d.Vehicle == vehicleID {
+			specific[d.Name] = true
+		}
+	}
+	var metrics []*derivedMetric
+	for _, d := range cfg().Derived {
+		if d.Vehicle == vehicleID || d.Vehicle == "" && !specific[d.Name] {
+			metrics = append(metrics, d)
+		}
+	}
+	return metrics
+}
+
+// updateDerived evaluates the derived metrics from the latest records and
+// stores their samples. A metric is skipped while a field it uses is
+// missing or not a number.
+func (v *vehicle) updateDerived() {
+	metrics := derivedMetrics(v.id)
+	if len(metrics) == 0 {
+		return
+	}
+	records := v.records.snapshot()
+	var ts time.Time
+	for code, rec := range records {
+		if code != derivedCode && rec.ts.After(ts) {
+			ts = rec.ts
+		}
+	}
+	if ts.IsZero() {
+		return
+	}
+
+	fields := map[string]string{}
+	var samples []string
+	for _, d := range metrics {
+		val, ok := d.expr.eval(func(name string) (float64, bool) {
+			if c, ok := d.Constants[name]; ok {
+				return c, true
+			}
+			code, field, _ := derivedField(name)
+			f, err := strconv.ParseFloat(records[code].fields[field], 64)
+			return f, err == nil
+		})
+		if !ok || math.IsNaN(val) || math.IsInf(val, 0) {
+			continue
+		}
+		s := strconv.FormatFloat(val, 'f', -1, 64)
+		fields[d.Name] = s
+		samples = append(samples, formatSample("ovms_"+d.Name, v.id, s, ts))
+	}
+	v.records.set(derivedCode, parsedRecord{ts, fields, filter.filterSamples(samples)})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Bits of the doors bitfields of the D record.
+// Reference: https://docs.openvehicles.com/en/latest/protocol_v2/messages.html#environment-message-d
+const (
+	doors1LeftDoor   = 0x01
+	doors1RightDoor  = 0x02
+	doors1ChargePort = 0x04
+	doors1Pilot      = 0x08
+	doors1Charging   = 0x10
+	doors2Hood       = 0x40
+	doors2Trunk      = 0x80
+	doors5RearLeft   = 0x01
+	doors5RearRight  = 0x02
+)
+
+var openAlerts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_open_alerts_total",
+	Help: "Number of times a charge port or door was detected left open.",
+}, []string{"vehicle", "kind"})
+
+// doorBits returns the named doors bitfield of a D record.
+func doorBits(fields map[string]string, name string) int {
+	v, _ := strconv.Atoi(fields[name])
+	return v
+}
+
+// openDetector raises alerts on the rising edge of the left-open conditions.
+type openDetector struct {
+	vehicle string
+	// onEvent, if set, is called with the kind of every alert.
+	onEvent func(event string)
+
+	mu              sync.Mutex
+	portWithoutPlug bool
+	openWhileMoving bool
+}
+
+// update processes the fields of a D record.
+func (o *openDetector) update(fields map[string]string) {
+	d1 := doorBits(fields, "doors1")
+	d2 := doorBits(fields, "doors2")
+	d5 := doorBits(fields, "doors5")
+	speed, _ := strconv.ParseFloat(fields["ms_v_pos_speed"], 64)
+
+	var open []string
+	if d1&doors1LeftDoor != 0 {
+		open = append(open, "front left door")
+	}
+	if d1&doors1RightDoor != 0 {
+		open = append(open, "front right door")
+	}
+	if d5&doors5RearLeft != 0 {
+		open = append(open, "rear left door")
+	}
+	if d5&doors5RearRight != 0 {
+		open = append(open, "rear right door")
+	}
+	if d2&doors2Hood != 0 {
+		open = append(open, "hood")
+	}
+	if d2&doors2Trunk != 0 {
+		open = append(open, "trunk")
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	portWithoutPlug := d1&doors1ChargePort != 0 && d1&doors1Pilot == 0
+	if portWithoutPlug && !o.portWithoutPlug {
+		openAlerts.WithLabelValues(o.vehicle, "charge_port_without_cable").Inc()
+		notify("%s: charge port is open without a cable plugged in", o.vehicle)
+		if o.onEvent != nil {
+			o.onEvent(eventChargePortWithoutCable)
+		}
+	}
+	o.portWithoutPlug = portWithoutPlug
+
+	openWhileMoving := speed > 0 && len(open) > 0
+	if openWhileMoving && !o.openWhileMoving {
+		openAlerts.WithLabelValues(o.vehicle, "open_while_moving").Inc()
+		notify("%s: vehicle is moving with open: %s", o.vehicle, strings.Join(open, ", "))
+		if o.onEvent != nil {
+			o.onEvent(eventOpenWhileMoving)
+		}
+	}
+	o.openWhileMoving = openWhileMoving
+}
+
+// doorMetrics returns the gauges derived from the doors bitfields.
+func doorMetrics(format sampleFormatter, vehicle string, fields map[string]string, ts time.Time) []string {
+	connected := 0
+	if doorBits(fields, "doors1")&doors1Pilot != 0 {
+		connected = 1
+	}
+	return []string{
+		format("ovms_charge_cable_connected", vehicle, strconv.Itoa(connected), ts),
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const dumpUsage = `usage: ovms_exporter [flags] dump -out <dir> [-count n]
+
+Polls the server every -poll-duration and writes the raw responses of each
+-vehicle to <dir>/<vehicle>/<time>.json, to be replayed with
+-replay-file <dir>. The responses are saved as received, even when they are
+errors, so that parse failures can be reproduced.
+
+Flags:`
+
+// dumpTimeFormat names the files so that they sort in the order of the polls.
+const dumpTimeFormat = "20060102T150405.000Z"
+
+// dumpResponse polls the records of a vehicle and saves the raw response to
+// dir.
+func dumpResponse(vehicleID, dir string) error {
+	resp, err := vehicleServer(vehicleID).get(protocolURL(vehicleID) + "?" + vehicleAuthQuery(vehicleID).Encode())
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		slog.Warn("unexpected status, saving the response anyway", "vehicle", vehicleID, "status", resp.Status)
+	}
+
+	dir = filepath.Join(dir, vehicleID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, time.Now().UTC().Format(dumpTimeFormat)+".json")
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(resp.Body, *maxResponseFlag))
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	slog.Info("saved response", "vehicle", vehicleID, "file", name, "bytes", n)
+	return nil
+}
+
+// dumpCommand returns the flags of the dump command and its function.
+func dumpCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	out := fs.String("out", "", "Directory where the responses are written")
+	count := fs.Int("count", 0, "Number of polls; 0 polls until interrupted")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), dumpUsage)
+		fs.PrintDefaults()
+	}
+	return fs, func(args []string) error {
+		if *out == "" || len(args) > 0 {
+			fs.Usage()
+			return fmt.Errorf("invalid arguments")
+		}
+		ids, err := parseVehicleIDs(*vehicleIDFlag)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("no -vehicle to dump")
+		}
+
+		for i := 0; *count == 0 || i < *count; i++ {
+			if i > 0 {
+				time.Sleep(*pollDurationFlag)
+			}
+			for _, id := range ids {
+				if err := dumpResponse(id, *out); err != nil {
+					slog.Error("error saving the response", "vehicle", id, "err", err)
+				}
+			}
+		}
+		return nil
+	}
+}
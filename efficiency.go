@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var efficiencyWindowFlag = flag.Float64("efficiency-window-km", 50, "Distance over which ovms_efficiency_wh_per_km is computed")
+
+// minEfficiencyDistanceKm is the distance needed before the efficiency is
+// exported, to avoid wild values right after startup.
+const minEfficiencyDistanceKm = 1
+
+var efficiencyGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_efficiency_wh_per_km",
+	Help: "Battery energy used per distance over the last -efficiency-window-km.",
+}, []string{"vehicle"})
+
+type efficiencySample struct {
+	odometerKm float64
+	energyKWh  float64
+}
+
+// efficiencyTracker computes the rolling efficiency from the odometer and the
+// energy used. ms_v_bat_energy_used is reset by most vehicles at the start of
+// a trip so it is accumulated into a monotonic total first.
+type efficiencyTracker struct {
+	vehicle string
+
+	mu         sync.Mutex
+	lastEnergy float64
+	energyKWh  float64
+	samples    []efficiencySample
+}
+
+// addEnergy processes a new ms_v_bat_energy_used value.
+func (e *efficiencyTracker) addEnergy(used float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if used >= e.lastEnergy {
+		e.energyKWh += used - e.lastEnergy
+	} else {
+		// The counter was reset.
+		e.energyKWh += used
+	}
+	e.lastEnergy = used
+}
+
+// addOdometer processes a new odometer value and updates the gauge.
+func (e *efficiencyTracker) addOdometer(km float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if n := len(e.samples); n > 0 && km <= e.samples[n-1].odometerKm {
+		// Not moving: only keep the energy up to date.
+		e.samples[n-1].energyKWh = e.energyKWh
+		return
+	}
+	e.samples = append(e.samples, efficiencySample{km, e.energyKWh})
+
+	// Drop the samples that fell out of the window, but keep one at or before
+	// the window start.
+	i := 0
+	for i+1 < len(e.samples) && km-e.samples[i+1].odometerKm >= *efficiencyWindowFlag {
+		i++
+	}
+	e.samples = e.samples[i:]
+
+	if v, ok := e.whPerKm(); ok {
+		efficiencyGauge.WithLabelValues(e.vehicle).Set(v)
+	}
+}
+
+// whPerKm returns the efficiency over the window, if enough distance was
+// driven. e.mu must be held.
+func (e *efficiencyTracker) whPerKm() (float64, bool) {
+	if len(e.samples) == 0 {
+		return 0, false
+	}
+	first, last := e.samples[0], e.samples[len(e.samples)-1]
+	d := last.odometerKm - first.odometerKm
+	if d < minEfficiencyDistanceKm {
+		return 0, false
+	}
+	return (last.energyKWh - first.energyKWh) * 1000 / d, true
+}
+
+// current returns the efficiency over the window, if known.
+func (e *efficiencyTracker) current() (float64, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.whPerKm()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Settings of the /events streams.
+const (
+	eventsBuffer    = 64
+	eventsKeepalive = 30 * time.Second
+)
+
+// recordEvent is a parsed record sent to the /events subscribers.
+type recordEvent struct {
+	Vehicle string            `json:"vehicle"`
+	Code    string            `json:"code"`
+	Time    time.Time         `json:"time"`
+	Fields  map[string]string `json:"fields"`
+}
+
+// eventHub fans out the record events to the subscribers. Slow subscribers
+// miss events rather than blocking the polling.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan recordEvent]bool
+}
+
+var events = &eventHub{subs: map[chan recordEvent]bool{}}
+
+func (h *eventHub) subscribe() chan recordEvent {
+	ch := make(chan recordEvent, eventsBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[ch] = true
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan recordEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, ch)
+}
+
+func (h *eventHub) publish(e recordEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// handleEvents streams the parsed records as Server-Sent Events, optionally
+// only those of ?vehicle=.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	vehicle := r.URL.Query().Get("vehicle")
+	if vehicle != "" && findVehicle(vehicle) == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventsKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-ch:
+			if vehicle != "" && e.Vehicle != vehicle {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				slog.Error("error encoding the event", "err", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: record\ndata: %s\n\n", data)
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The bucket sizes of the anonymized export. The values are rounded to the
+// nearest multiple.
+const (
+	exportDistanceKm = 5
+	exportDurationM  = 5
+	exportEnergyKWh  = 0.5
+	exportWhPerKm    = 10
+	exportSOC        = 10
+	exportOdometerKm = 5000
+	exportSOH        = 1
+	exportCAC        = 1
+)
+
+// exportTrip is a trip in the anonymized export. It has no vehicle ID,
+// location or exact time.
+type exportTrip struct {
+	Week        string  `json:"week"`
+	DistanceKm  float64 `json:"distance_km"`
+	DurationMin float64 `json:"duration_min"`
+	EnergyKWh   float64 `json:"energy_kwh"`
+	WhPerKm     float64 `json:"wh_per_km"`
+	StartSOC    float64 `json:"start_soc"`
+	EndSOC      float64 `json:"end_soc"`
+	OdometerKm  float64 `json:"odometer_km"`
+}
+
+// exportBattery is the battery health of one week in the anonymized export.
+type exportBattery struct {
+	Week string  `json:"week"`
+	SOH  float64 `json:"soh"`
+	CAC  float64 `json:"cac"`
+}
+
+// anonymizer coarsens the exported values. With a positive epsilon, Laplace
+// noise scaled to the bucket size over epsilon is added before bucketing;
+// otherwise the values are only bucketed, which is repeatable.
+type anonymizer struct {
+	epsilon float64
+	rand    *rand.Rand
+}
+
+func (a *anonymizer) bucket(v, size float64) float64 {
+	if a.epsilon > 0 {
+		u := a.rand.Float64() - 0.5
+		v -= size / a.epsilon * math.Copysign(math.Log(1-2*math.Abs(u)), u)
+	}
+	// All the exported values are non-negative.
+	return math.Max(0, math.Round(v/size)*size)
+}
+
+// isoWeek formats the ISO week of t, e.g. 2024-W07.
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// exportTrips returns the anonymized trips of the mileage log. The trips
+// logged without the energy are skipped.
+func (a *anonymizer) exportTrips(entries []mileageEntry) []exportTrip {
+	var trips []exportTrip
+	for _, e := range entries {
+		if e.EnergyKWh <= 0 || e.DistanceKm <= 0 {
+			continue
+		}
+		trips = append(trips, exportTrip{
+			Week:        isoWeek(e.Start),
+			DistanceKm:  a.bucket(e.DistanceKm, exportDistanceKm),
+			DurationMin: a.bucket(e.End.Sub(e.Start).Minutes(), exportDurationM),
+			EnergyKWh:   a.bucket(e.EnergyKWh, exportEnergyKWh),
+			WhPerKm:     a.bucket(e.EnergyKWh*1000/e.DistanceKm, exportWhPerKm),
+			StartSOC:    a.bucket(e.StartSOC, exportSOC),
+			EndSOC:      a.bucket(e.EndSOC, exportSOC),
+			OdometerKm:  a.bucket(e.StartOdometerKm, exportOdometerKm),
+		})
+	}
+	// Sorting by week and value hides the order of the trips in a week.
+	sort.Slice(trips, func(i, j int) bool {
+		if trips[i].Week != trips[j].Week {
+			return trips[i].Week < trips[j].Week
+		}
+		return trips[i].WhPerKm < trips[j].WhPerKm
+	})
+	return trips
+}
+
+// exportBatteryWeeks returns the weekly averages of the daily SOH and CAC.
+func (a *anonymizer) exportBatteryWeeks(days []degradationDay) []exportBattery {
+	var weeks []string
+	stats := map[string]*[2]dailyStat{}
+	for _, d := range days {
+		t, err := time.Parse(time.DateOnly, d.Date)
+		if err != nil {
+			continue
+		}
+		w := isoWeek(t)
+		s, ok := stats[w]
+		if !ok {
+			s = &[2]dailyStat{}
+			stats[w] = s
+			weeks = append(weeks, w)
+		}
+		merge(&s[0], d.SOH)
+		merge(&s[1], d.CAC)
+	}
+	var battery []exportBattery
+	for _, w := range weeks {
+		s := stats[w]
+		if s[0].Count == 0 || s[1].Count == 0 {
+			continue
+		}
+		battery = append(battery, exportBattery{
+			Week: w,
+			SOH:  a.bucket(s[0].Sum/float64(s[0].Count), exportSOH),
+			CAC:  a.bucket(s[1].Sum/float64(s[1].Count), exportCAC),
+		})
+	}
+	return battery
+}
+
+func formatExportValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// handleExport serves an anonymized, coarse-grained dataset of a vehicle for
+// sharing with community efficiency studies:
+// /report/export?vehicle=<id>&dataset=trips|battery&format=csv|json&epsilon=<e>
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	v := findVehicle(r.FormValue("vehicle"))
+	if v == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+	a := &anonymizer{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	if s := r.FormValue("epsilon"); s != "" {
+		eps, err := strconv.ParseFloat(s, 64)
+		if err != nil || eps < 0 {
+			http.Error(w, "invalid epsilon", http.StatusBadRequest)
+			return
+		}
+		a.epsilon = eps
+	}
+
+	dataset := r.FormValue("dataset")
+	var header []string
+	var rows [][]string
+	var data interface{}
+	switch dataset {
+	case "", "trips":
+		dataset = "trips"
+		entries, _ := v.mileage.snapshot()
+		trips := a.exportTrips(entries)
+		header = []string{"week", "distance_km", "duration_min", "energy_kwh", "wh_per_km", "start_soc", "end_soc", "odometer_km"}
+		for _, t := range trips {
+			rows = append(rows, []string{t.Week, formatExportValue(t.DistanceKm), formatExportValue(t.DurationMin),
+				formatExportValue(t.EnergyKWh), formatExportValue(t.WhPerKm), formatExportValue(t.StartSOC),
+				formatExportValue(t.EndSOC), formatExportValue(t.OdometerKm)})
+		}
+		data = trips
+	case "battery":
+		battery := a.exportBatteryWeeks(v.degradation.snapshot())
+		header = []string{"week", "soh", "cac"}
+		for _, b := range battery {
+			rows = append(rows, []string{b.Week, formatExportValue(b.SOH), formatExportValue(b.CAC)})
+		}
+		data = battery
+	default:
+		http.Error(w, "unknown dataset "+dataset, http.StatusBadRequest)
+		return
+	}
+
+	switch format := r.FormValue("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "ovms_"+dataset+".csv"))
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+	case "json":
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "ovms_"+dataset+".json"))
+		writeJSON(w, data)
+	default:
+		http.Error(w, "unknown format "+format, http.StatusBadRequest)
+	}
+}
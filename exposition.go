@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// acceptsGzip reports whether the client accepts gzip responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(enc) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// exemplarFields are the fields whose samples carry the position of the
+// vehicle as an exemplar in OpenMetrics, for the spikes to be located without
+// a high-cardinality position label.
+var exemplarFields = map[string]bool{
+	"ms_v_pos_speed":    true,
+	"ms_v_pos_gpsspeed": true,
+	"ms_v_bat_power":    true,
+	"ms_v_inv_power":    true,
+}
+
+// exemplarMetrics returns the exported names of the metrics of the
+// exemplarFields.
+func exemplarMetrics() map[string]bool {
+	names := map[string]bool{}
+	for code, fields := range metricsMap {
+		for _, field := range fields {
+			if exemplarFields[field] {
+				names[metricName(fmt.Sprintf("ovms_%s_%s", code, field))] = true
+			}
+		}
+	}
+	return names
+}
+
+// positionMetricNames are the metrics of the position, whose exclusion by
+// the filter also excludes the position exemplars.
+var positionMetricNames = []string{
+	"ovms_L_ms_v_pos_latitude",
+	"ovms_L_ms_v_pos_longitude",
+	"ovms_position_latitude_degrees",
+	"ovms_position_longitude_degrees",
+}
+
+// positionExemplars returns the labels of the exemplars of each vehicle, from
+// its latest position, e.g. {lat="52.520008",lon="13.404954"}. The vehicles
+// without a known position have none, and so do all of them when the filter
+// excludes a metric of the position.
+func positionExemplars() map[string]string {
+	labels := map[string]string{}
+	for _, name := range positionMetricNames {
+		if !filter.collectMetric(metricName(name)) {
+			return labels
+		}
+	}
+	for _, v := range vehicles {
+		lat, lon := v.field("L", "ms_v_pos_latitude"), v.field("L", "ms_v_pos_longitude")
+		latF, err1 := strconv.ParseFloat(lat, 64)
+		lonF, err2 := strconv.ParseFloat(lon, 64)
+		if err1 != nil || err2 != nil || latF == 0 && lonF == 0 {
+			continue
+		}
+		labels[v.id] = fmt.Sprintf("{lat=%q,lon=%q}", lat, lon)
+	}
+	return labels
+}
+
+// encodeWithExemplars encodes a metric family in OpenMetrics, appending the
+// position exemplar to the samples of the vehicles that have one. The
+// encoder only writes the exemplars of the counters and of the histograms,
+// so they are added to its output, one sample line per metric. Prometheus
+// stores them for any type.
+func encodeWithExemplars(out io.Writer, mf *dto.MetricFamily, exemplars map[string]string) error {
+	var buf bytes.Buffer
+	if _, err := expfmt.MetricFamilyToOpenMetrics(&buf, mf); err != nil {
+		return err
+	}
+	i := 0
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line == "" || strings.HasPrefix(line, "#") || i >= len(mf.Metric) {
+			io.WriteString(out, line)
+			continue
+		}
+		m := mf.Metric[i]
+		i++
+		var vehicle string
+		numeric := true
+		for _, lp := range m.Label {
+			switch lp.GetName() {
+			case "vehicle":
+				vehicle = lp.GetValue()
+			case "value":
+				numeric = false
+			}
+		}
+		e, ok := exemplars[vehicle]
+		if !ok || !numeric || m.Untyped == nil || m.TimestampMs == nil {
+			io.WriteString(out, line)
+			continue
+		}
+		fmt.Fprintf(out, "%s # %s %s %s\n", strings.TrimSuffix(line, "\n"), e,
+			strconv.FormatFloat(m.Untyped.GetValue(), 'g', -1, 64),
+			strconv.FormatFloat(float64(m.GetTimestampMs())/1000, 'f', 3, 64))
+	}
+	return nil
+}
+
+// writeSamples writes the timestamped samples of /metrics_ovms in the format
+// negotiated with the Accept header, gzip-compressed if the client accepts
+// it. The text format is served as is, the other formats, including
+// OpenMetrics, are encoded from the parsed samples. In OpenMetrics, the speed
+// and power samples carry the position as an exemplar.
+func writeSamples(w http.ResponseWriter, r *http.Request, samples string) {
+	format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+	var mfs map[string]*dto.MetricFamily
+	if format != expfmt.FmtText {
+		var p expfmt.TextParser
+		var err error
+		mfs, err = p.TextToMetricFamilies(strings.NewReader(samples))
+		if err != nil {
+			slog.Error("error parsing the samples", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", string(format))
+	w.Header().Add("Vary", "Accept-Encoding")
+	var out io.Writer = w
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+
+	if format == expfmt.FmtText {
+		fmt.Fprint(out, samples)
+		return
+	}
+	names := make([]string, 0, len(mfs))
+	for name := range mfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var withExemplars map[string]bool
+	var exemplars map[string]string
+	if format == expfmt.FmtOpenMetrics {
+		withExemplars, exemplars = exemplarMetrics(), positionExemplars()
+	}
+	enc := expfmt.NewEncoder(out, format)
+	for _, name := range names {
+		if withExemplars[name] {
+			if err := encodeWithExemplars(out, mfs[name], exemplars); err != nil {
+				slog.Error("error encoding the samples", "err", err)
+				return
+			}
+			continue
+		}
+		if err := enc.Encode(mfs[name]); err != nil {
+			slog.Error("error encoding the samples", "err", err)
+			return
+		}
+	}
+	if c, ok := enc.(expfmt.Closer); ok {
+		if err := c.Close(); err != nil {
+			slog.Error("error encoding the samples", "err", err)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a parsed arithmetic expression. eval returns false if a variable
+// is unknown.
+type expr interface {
+	eval(vars func(name string) (float64, bool)) (float64, bool)
+}
+
+type numberExpr float64
+
+func (e numberExpr) eval(func(string) (float64, bool)) (float64, bool) {
+	return float64(e), true
+}
+
+type varExpr string
+
+func (e varExpr) eval(vars func(string) (float64, bool)) (float64, bool) {
+	return vars(string(e))
+}
+
+type negExpr struct{ x expr }
+
+func (e negExpr) eval(vars func(string) (float64, bool)) (float64, bool) {
+	x, ok := e.x.eval(vars)
+	return -x, ok
+}
+
+type binaryExpr struct {
+	op   byte
+	x, y expr
+}
+
+func (e binaryExpr) eval(vars func(string) (float64, bool)) (float64, bool) {
+	x, ok1 := e.x.eval(vars)
+	y, ok2 := e.y.eval(vars)
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+	switch e.op {
+	case '+':
+		return x + y, true
+	case '-':
+		return x - y, true
+	case '*':
+		return x * y, true
+	case '/':
+		return x / y, true
+	}
+	return math.Mod(x, y), true
+}
+
+// exprFuncs are the functions of the expressions, by name and arity.
+var exprFuncs = map[string]struct {
+	args int
+	fn   func(args []float64) float64
+}{
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"round": {1, func(a []float64) float64 { return math.Round(a[0]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"ceil":  {1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+}
+
+type callExpr struct {
+	name string
+	args []expr
+}
+
+func (e callExpr) eval(vars func(string) (float64, bool)) (float64, bool) {
+	args := make([]float64, len(e.args))
+	for i, a := range e.args {
+		v, ok := a.eval(vars)
+		if !ok {
+			return 0, false
+		}
+		args[i] = v
+	}
+	return exprFuncs[e.name].fn(args), true
+}
+
+// exprParser parses expressions with the usual precedence:
+//
+//	expr    = term { ("+" | "-") term }
+//	term    = unary { ("*" | "/" | "%") unary }
+//	unary   = "-" unary | primary
+//	primary = number | name [ "(" expr { "," expr } ")" ] | "(" expr ")"
+//
+// The names may contain dots, e.g. S.ms_v_bat_soc.
+type exprParser struct {
+	s   string
+	pos int
+}
+
+// parseExpr parses an expression.
+func parseExpr(s string) (expr, error) {
+	p := &exprParser{s: s}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.s[p.pos:], p.pos)
+	}
+	return e, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+// accept consumes the next character if it is one of chars.
+func (p *exprParser) accept(chars string) (byte, bool) {
+	p.skipSpace()
+	return p.next(chars)
+}
+
+// next consumes the next character, without skipping the spaces, if it is
+// one of chars.
+func (p *exprParser) next(chars string) (byte, bool) {
+	if p.pos < len(p.s) && strings.IndexByte(chars, p.s[p.pos]) >= 0 {
+		p.pos++
+		return p.s[p.pos-1], true
+	}
+	return 0, false
+}
+
+// skip consumes the characters that are in chars.
+func (p *exprParser) skip(chars string) {
+	for _, ok := p.next(chars); ok; _, ok = p.next(chars) {
+	}
+}
+
+func (p *exprParser) expr() (expr, error) {
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("+-")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op, x, y}
+	}
+}
+
+func (p *exprParser) term() (expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept("*/%")
+		if !ok {
+			return x, nil
+		}
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op, x, y}
+	}
+}
+
+func (p *exprParser) unary() (expr, error) {
+	if _, ok := p.accept("-"); ok {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negExpr{x}, nil
+	}
+	return p.primary()
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && (c == '.' || c >= '0' && c <= '9')
+}
+
+func (p *exprParser) primary() (expr, error) {
+	p.skipSpace()
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if _, ok := p.accept("("); ok {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		return x, nil
+	}
+
+	start := p.pos
+	if isNameChar(p.s[p.pos], true) {
+		for p.pos < len(p.s) && isNameChar(p.s[p.pos], false) {
+			p.pos++
+		}
+		name := p.s[start:p.pos]
+		if _, ok := p.accept("("); !ok {
+			return varExpr(name), nil
+		}
+		f, ok := exprFuncs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %q", name)
+		}
+		var args []expr
+		for {
+			a, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if _, ok := p.accept(","); !ok {
+				break
+			}
+		}
+		if _, ok := p.accept(")"); !ok {
+			return nil, fmt.Errorf("missing ) at offset %d", p.pos)
+		}
+		if len(args) != f.args {
+			return nil, fmt.Errorf("%s takes %d arguments, got %d", name, f.args, len(args))
+		}
+		return callExpr{name, args}, nil
+	}
+
+	p.skip(".0123456789")
+	if _, ok := p.next("eE"); ok {
+		p.next("+-")
+		p.skip("0123456789")
+	}
+	f, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if err != nil {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.s[start:], start)
+	}
+	return numberExpr(f), nil
+}
+
+// exprVars returns the names of the variables of an expression.
+func exprVars(e expr) []string {
+	switch e := e.(type) {
+	case varExpr:
+		return []string{string(e)}
+	case negExpr:
+		return exprVars(e.x)
+	case binaryExpr:
+		return append(exprVars(e.x), exprVars(e.y)...)
+	case callExpr:
+		var names []string
+		for _, a := range e.args {
+			names = append(names, exprVars(a)...)
+		}
+		return names
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestExprEval(t *testing.T) {
+	vars := map[string]float64{
+		"S.ms_v_bat_soc":   80,
+		"S.ms_v_bat_power": -6.5,
+		"x":                3,
+	}
+	lookup := func(name string) (float64, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+	for _, tt := range []struct {
+		expr string
+		want float64
+	}{
+		{"1", 1},
+		{"1.5e2", 150},
+		{"2E-1", 0.2},
+		{".5", 0.5},
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 4 - 3", 3},
+		{"12 / 3 / 2", 2},
+		{"7 % 4", 3},
+		{"-x", -3},
+		{"--x", 3},
+		{"2 * -x", -6},
+		{"-2 * 3 + 1", -5},
+		{"  x*x  ", 9},
+		{"S.ms_v_bat_soc / 100", 0.8},
+		{"abs(S.ms_v_bat_power)", 6.5},
+		{"round(2.5)", 3},
+		{"floor(-1.5)", -2},
+		{"ceil(1.2)", 2},
+		{"min(x, 2)", 2},
+		{"max(x, 2 * x)", 6},
+		{"max(min(x, 1), abs(-4)) + 1", 5},
+	} {
+		e, err := parseExpr(tt.expr)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", tt.expr, err)
+			continue
+		}
+		got, ok := e.eval(lookup)
+		if !ok || math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%q = %v, %v, want %v", tt.expr, got, ok, tt.want)
+		}
+	}
+}
+
+func TestExprEvalUnknownVariable(t *testing.T) {
+	lookup := func(string) (float64, bool) { return 0, false }
+	for _, s := range []string{"y", "1 + y", "-y", "abs(y)", "max(1, y)"} {
+		e, err := parseExpr(s)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", s, err)
+			continue
+		}
+		if _, ok := e.eval(lookup); ok {
+			t.Errorf("%q evaluated with an unknown variable", s)
+		}
+	}
+}
+
+func TestParseExprErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"1 + * 2",
+		"foo(1)",
+		"abs(1, 2)",
+		"min(1)",
+		"abs(1",
+		"1..2",
+		"1e",
+		"$",
+		")",
+	} {
+		if _, err := parseExpr(s); err == nil {
+			t.Errorf("parseExpr(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestExprVars(t *testing.T) {
+	e, err := parseExpr("S.ms_v_bat_soc * max(x, -y) + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := exprVars(e), []string{"S.ms_v_bat_soc", "x", "y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("exprVars = %q, want %q", got, want)
+	}
+}
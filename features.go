@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// features lists the experimental features that can be turned on with
+// -enable-feature, with a short description.
+var features = map[string]string{}
+
+// enabledFeatures implements flag.Value for the comma-separated, repeatable
+// -enable-feature flag.
+type enabledFeatures map[string]bool
+
+func (f enabledFeatures) String() string {
+	var names []string
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func (f enabledFeatures) Set(s string) error {
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			f[name] = true
+		}
+	}
+	return nil
+}
+
+var enabledFeaturesFlag = enabledFeatures{}
+
+func init() {
+	flag.Var(enabledFeaturesFlag, "enable-feature", "Comma-separated experimental features to enable; may be repeated")
+}
+
+// checkFeatures logs the enabled features and warns about the unknown ones,
+// like Prometheus does.
+func checkFeatures() {
+	for _, name := range strings.Split(enabledFeaturesFlag.String(), ",") {
+		if name == "" {
+			continue
+		}
+		if desc, ok := features[name]; ok {
+			slog.Info("experimental feature enabled", "feature", name, "description", desc)
+		} else {
+			slog.Warn("unknown option for -enable-feature", "feature", name)
+		}
+	}
+}
+
+// featureEnabled reports whether the experimental feature is on.
+func featureEnabled(name string) bool {
+	return enabledFeaturesFlag[name]
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	collectGroupsFlag = flag.String("collect-groups", "S,D,L,Y", "Comma-separated record groups to process; the metrics derived from the other groups are not exported either")
+	collectFlag       = flag.String("collect", "", "Regexp of the metric names to export; empty exports all")
+	noCollectFlag     = flag.String("no-collect", "", "Regexp of the metric names not to export, e.g. 'ovms_L_ms_v_pos_(latitude|longitude|altitude)|ovms_position_.*|ovms_distance_from_home_meters|ovms_in_geofence' to exclude the GPS position; it applies to the exported samples, the history and the position exemplars, not to /track, the record log, the archive, the event stream, MQTT or ABRP")
+)
+
+// metricFilter decides which records are processed and which metrics are
+// exported. The regexps are anchored like in Prometheus relabeling.
+type metricFilter struct {
+	groups  map[string]bool
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+}
+
+var filter = &metricFilter{groups: map[string]bool{"S": true, "D": true, "L": true, "Y": true}}
+
+// setupFilter parses the filter flags. It must be called after flag.Parse.
+func setupFilter() error {
+	f := &metricFilter{groups: map[string]bool{}}
+	for _, g := range strings.Split(*collectGroupsFlag, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			if _, ok := metricsMap[g]; !ok {
+				return fmt.Errorf("unknown record group %q in -collect-groups", g)
+			}
+			f.groups[g] = true
+		}
+	}
+	var err error
+	if *collectFlag != "" {
+		if f.include, err = regexp.Compile("^(?:" + *collectFlag + ")$"); err != nil {
+			return fmt.Errorf("invalid -collect: %v", err)
+		}
+	}
+	if *noCollectFlag != "" {
+		if f.exclude, err = regexp.Compile("^(?:" + *noCollectFlag + ")$"); err != nil {
+			return fmt.Errorf("invalid -no-collect: %v", err)
+		}
+	}
+	filter = f
+	return nil
+}
+
+// collectGroup reports whether the records with the code are processed.
+func (f *metricFilter) collectGroup(code string) bool {
+	return f.groups[code]
+}
+
+// collectMetric reports whether the metric is exported.
+func (f *metricFilter) collectMetric(name string) bool {
+	if f.include != nil && !f.include.MatchString(name) {
+		return false
+	}
+	return f.exclude == nil || !f.exclude.MatchString(name)
+}
+
+// filterSamples drops the text format samples of the metrics not exported.
+func (f *metricFilter) filterSamples(samples []string) []string {
+	if f.include == nil && f.exclude == nil {
+		return samples
+	}
+	var kept []string
+	for _, s := range samples {
+		name := s
+		if i := strings.IndexAny(s, "{ "); i >= 0 {
+			name = s[:i]
+		}
+		if f.collectMetric(name) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
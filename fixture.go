@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// fixture is a regression test case of the record decoding: a raw response
+// of the OVMS server, as saved by the dump command, and the fields and the
+// samples it decodes to. The samples are canonical, without the
+// -metric-prefix, the static labels, the renames and the geofences of the
+// exporter that captured it. The fixtures of testdata/fixtures are replayed
+// by TestFixtures.
+type fixture struct {
+	Vehicle        string          `json:"vehicle"`
+	Captured       time.Time       `json:"captured"`
+	Version        string          `json:"version"`
+	ServerTimezone string          `json:"server_timezone"`
+	Response       json.RawMessage `json:"response"`
+	Expected       []fixtureRecord `json:"expected"`
+}
+
+// fixtureRecord is the expected decoding of a record of a fixture.
+type fixtureRecord struct {
+	Code    string            `json:"code"`
+	MsgTime string            `json:"msgtime"`
+	Error   string            `json:"error,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Samples []string          `json:"samples,omitempty"`
+}
+
+// newFixture decodes the records of a raw response into a fixture.
+func newFixture(vehicleID string, captured time.Time, body []byte) (*fixture, error) {
+	var recs []record
+	if err := json.Unmarshal(body, &recs); err != nil {
+		return nil, fmt.Errorf("the last response is not a record array: %v", err)
+	}
+	f := &fixture{
+		Vehicle:        vehicleID,
+		Captured:       captured.UTC(),
+		Version:        versionString(),
+		ServerTimezone: *serverTimezoneFlag,
+		Response:       body,
+	}
+	for _, rec := range recs {
+		r := fixtureRecord{Code: rec.Code, MsgTime: rec.MsgTime}
+		ts, err := parseMsgTime(rec.MsgTime)
+		if err != nil {
+			r.Error = err.Error()
+		} else if fields, samples, ok := decodeRecordAs(canonicalSample, vehicleID, rec, ts); ok {
+			r.Fields, r.Samples = fields, samples
+		} else {
+			r.Error = "unknown record code"
+		}
+		f.Expected = append(f.Expected, r)
+	}
+	return f, nil
+}
+
+// handleCaptureFixture returns the last raw response of a vehicle as a test
+// fixture, for the users of unusual vehicles or firmwares to contribute it
+// to testdata/fixtures.
+// It requires the -admin-token: the response carries the location, which
+// should be edited out before sharing.
+func handleCaptureFixture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "only POST requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	v := findVehicle(r.URL.Query().Get("vehicle"))
+	if v == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+	v.raw.mu.Lock()
+	at, body := v.raw.at, v.raw.body
+	v.raw.mu.Unlock()
+	if len(body) == 0 {
+		http.Error(w, "no response of vehicle "+v.id+" yet", http.StatusServiceUnavailable)
+		return
+	}
+	f, err := newFixture(v.id, at, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("fixture-%s-%s.json", v.id, at.UTC().Format(dumpTimeFormat))))
+	writeJSON(w, f)
+}
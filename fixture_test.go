@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestFixtures replays the responses of the fixtures of testdata/fixtures,
+// captured with /debug/capture-fixture, and compares their decoding.
+func TestFixtures(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "fixtures", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixture in testdata/fixtures")
+	}
+	defer func(loc *time.Location) { serverLocation = loc }(serverLocation)
+	for _, name := range files {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want fixture
+			if err := json.Unmarshal(data, &want); err != nil {
+				t.Fatal(err)
+			}
+			if serverLocation, err = time.LoadLocation(want.ServerTimezone); err != nil {
+				t.Fatal(err)
+			}
+			got, err := newFixture(want.Vehicle, want.Captured, want.Response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got.Expected) != len(want.Expected) {
+				t.Fatalf("got %d records, want %d", len(got.Expected), len(want.Expected))
+			}
+			for i, w := range want.Expected {
+				if g := got.Expected[i]; !reflect.DeepEqual(g, w) {
+					t.Errorf("record %d (%s):\ngot  %+v\nwant %+v", i, w.Code, g, w)
+				}
+			}
+		})
+	}
+}
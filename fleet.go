@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var fleetLowSOCFlag = flag.Float64("fleet-low-soc", 20, "State of charge, in %, under which a vehicle is counted by ovms_fleet_vehicles_low_soc")
+
+var (
+	fleetChargedToday = prometheus.NewDesc("ovms_fleet_charged_today_kwh",
+		"Energy charged by all the vehicles since local midnight, in kWh.", nil, nil)
+	fleetCharging = prometheus.NewDesc("ovms_fleet_vehicles_charging",
+		"Number of vehicles currently charging.", nil, nil)
+	fleetLowSOC = prometheus.NewDesc("ovms_fleet_vehicles_low_soc",
+		"Number of vehicles whose state of charge is under -fleet-low-soc.", nil, nil)
+)
+
+// fleetCollector exports the aggregates of all the vehicles at scrape time,
+// when more than one vehicle is polled.
+type fleetCollector struct{}
+
+func (fleetCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- fleetChargedToday
+	ch <- fleetCharging
+	ch <- fleetLowSOC
+}
+
+func (fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	if len(vehicles) < 2 {
+		return
+	}
+	now := time.Now()
+	var kwh, charging, low float64
+	for _, v := range vehicles {
+		kwh += v.charge.chargedToday(now)
+		if v.state() == stateCharging {
+			charging++
+		}
+		if soc, err := strconv.ParseFloat(v.field("S", "ms_v_bat_soc"), 64); err == nil && soc < *fleetLowSOCFlag {
+			low++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(fleetChargedToday, prometheus.GaugeValue, kwh)
+	ch <- prometheus.MustNewConstMetric(fleetCharging, prometheus.GaugeValue, charging)
+	ch <- prometheus.MustNewConstMetric(fleetLowSOC, prometheus.GaugeValue, low)
+}
+
+func init() {
+	prometheus.MustRegister(fleetCollector{})
+}
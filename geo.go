@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// geohashPrecision is the number of characters of the exported geohash
+// (9 characters is roughly a 5m x 5m cell).
+const geohashPrecision = 9
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geohash encodes the coordinates using the standard geohash algorithm.
+// Reference: https://en.wikipedia.org/wiki/Geohash
+func geohash(lat, lon float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+	hash := make([]byte, 0, precision)
+	even := true
+	bit, ch := 0, 0
+	for len(hash) < precision {
+		r, v := &latRange, lat
+		if even {
+			r, v = &lonRange, lon
+		}
+		mid := (r[0] + r[1]) / 2
+		ch <<= 1
+		if v >= mid {
+			ch |= 1
+			r[0] = mid
+		} else {
+			r[1] = mid
+		}
+		even = !even
+		if bit++; bit == 5 {
+			hash = append(hash, geohashAlphabet[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// recordPosition returns the position of the fields of an L record.
+func recordPosition(fields map[string]string) (lat, lon float64, ok bool) {
+	lat, err1 := strconv.ParseFloat(fields["ms_v_pos_latitude"], 64)
+	lon, err2 := strconv.ParseFloat(fields["ms_v_pos_longitude"], 64)
+	return lat, lon, err1 == nil && err2 == nil
+}
+
+// positionMetrics returns the position gauges derived from an L record.
+func positionMetrics(format sampleFormatter, vehicle string, fields map[string]string, ts time.Time) []string {
+	lat, lon, ok := recordPosition(fields)
+	if !ok {
+		return nil
+	}
+	// The flag is "1" for a fresh position and "0" for a stale one.
+	stale := "0"
+	if fields["stale"] == "0" {
+		stale = "1"
+	}
+
+	metrics := []string{
+		format("ovms_position_latitude_degrees", vehicle, strconv.FormatFloat(lat, 'f', -1, 64), ts),
+		format("ovms_position_longitude_degrees", vehicle, strconv.FormatFloat(lon, 'f', -1, 64), ts),
+		format("ovms_position_stale", vehicle, stale, ts),
+		format("ovms_position_info", vehicle, "1", ts, "geohash", geohash(lat, lon, geohashPrecision), "stale", stale),
+	}
+	if alt, err := strconv.ParseFloat(fields["ms_v_pos_altitude"], 64); err == nil {
+		metrics = append(metrics, format("ovms_position_altitude_meters", vehicle, strconv.FormatFloat(alt, 'f', -1, 64), ts))
+	}
+	return metrics
+}
+
+// earthRadiusMeters is the mean Earth radius.
+const earthRadiusMeters = 6371008.8
+
+// geofence is a named circular area.
+type geofence struct {
+	Name         string  `json:"name"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	RadiusMeters float64 `json:"radius_meters"`
+}
+
+// distanceMeters returns the great-circle distance between two points using
+// the haversine formula.
+func distanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Sqrt(a))
+}
+
+// geofenceMetrics returns the distance from home and the geofence membership
+// of the position of an L record.
+func geofenceMetrics(vehicle string, fields map[string]string, ts time.Time) []string {
+	lat, lon, ok := recordPosition(fields)
+	if !ok {
+		return nil
+	}
+	var metrics []string
+	for _, g := range cfg().Geofences {
+		d := distanceMeters(lat, lon, g.Latitude, g.Longitude)
+		if g.Name == "home" {
+			metrics = append(metrics, formatSample("ovms_distance_from_home_meters", vehicle, strconv.FormatFloat(d, 'f', 1, 64), ts))
+		}
+		in := "0"
+		if d <= g.RadiusMeters {
+			in = "1"
+		}
+		metrics = append(metrics, formatSample("ovms_in_geofence", vehicle, in, ts, "name", g.Name))
+	}
+	return metrics
+}
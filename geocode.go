@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	geocoderURLFlag  = flag.String("geocoder-url", "", "Reverse geocoding API URL, Nominatim compatible, e.g. https://nominatim.openstreetmap.org/reverse; enables the ovms_position_info place label")
+	geocoderRateFlag = flag.Float64("geocoder-rate", 1, "Maximum rate of the requests to -geocoder-url, per second; the public Nominatim allows 1")
+)
+
+var positionInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_position_info",
+	Help: "Place of the current position of the vehicle, from reverse geocoding.",
+}, []string{"vehicle", "place"})
+
+// placeCachePrecision is the geohash precision of the cached places,
+// roughly 150m x 150m.
+const placeCachePrecision = 7
+
+// placeCache caches the places by geohash, in -state-dir.
+type placeCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	Entries map[string]string `json:"entries"`
+}
+
+const placeCacheFile = "places.json"
+
+var (
+	places         placeCache
+	geocoderLimits tokenBucket
+)
+
+// lookup returns the place of a geohash, querying the geocoder unless
+// cached.
+func (c *placeCache) lookup(key string, lat, lon float64) (string, error) {
+	c.mu.Lock()
+	if !c.loaded {
+		if err := readState(placeCacheFile, c); err != nil {
+			slog.Error("error loading the place cache", "err", err)
+		}
+		if c.Entries == nil {
+			c.Entries = map[string]string{}
+		}
+		c.loaded = true
+	}
+	place, ok := c.Entries[key]
+	c.mu.Unlock()
+	if ok {
+		return place, nil
+	}
+
+	wait, err := geocoderLimits.reserve(*geocoderRateFlag, 1, time.Now())
+	if err != nil {
+		return "", err
+	}
+	time.Sleep(wait)
+	place, err = reverseGeocode(lat, lon)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Entries[key] = place
+	if err := writeState(placeCacheFile, c); err != nil {
+		slog.Error("error saving the place cache", "err", err)
+	}
+	return place, nil
+}
+
+// nominatimPlace is the part of a Nominatim reverse response that is used.
+// Reference: https://nominatim.org/release-docs/latest/api/Reverse/
+type nominatimPlace struct {
+	Error       string            `json:"error"`
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	Address     map[string]string `json:"address"`
+}
+
+// label returns a short name of the place: its name or road, and its
+// locality.
+func (p *nominatimPlace) label() string {
+	var parts []string
+	for _, keys := range [][]string{
+		{"road", "pedestrian", "footway", "square"},
+		{"city", "town", "village", "municipality", "county"},
+	} {
+		for _, k := range keys {
+			if a := p.Address[k]; a != "" {
+				parts = append(parts, a)
+				break
+			}
+		}
+	}
+	if p.Name != "" && (len(parts) == 0 || parts[0] != p.Name) {
+		parts = append([]string{p.Name}, parts...)
+	}
+	if len(parts) == 0 {
+		return p.DisplayName
+	}
+	return strings.Join(parts, ", ")
+}
+
+func reverseGeocode(lat, lon float64) (string, error) {
+	q := url.Values{
+		"format": {"jsonv2"},
+		"lat":    {strconv.FormatFloat(lat, 'f', -1, 64)},
+		"lon":    {strconv.FormatFloat(lon, 'f', -1, 64)},
+	}
+	req, err := http.NewRequest(http.MethodGet, *geocoderURLFlag+"?"+q.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	// Required by the Nominatim usage policy.
+	req.Header.Set("User-Agent", "ovms_exporter/"+version)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("geocoder: %s", resp.Status)
+	}
+	var p nominatimPlace
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return "", fmt.Errorf("geocoder: %v", err)
+	}
+	if p.Error != "" {
+		// Nothing at the position, e.g. at sea.
+		return "", nil
+	}
+	return p.label(), nil
+}
+
+// placeTracker tracks the place of a vehicle.
+type placeTracker struct {
+	mu sync.Mutex
+	// key is the geohash of the position being or last looked up.
+	key string
+}
+
+// updatePlace looks up the place of the position, in the background, when
+// the vehicle moved to another geohash cell.
+func (v *vehicle) updatePlace() {
+	if *geocoderURLFlag == "" {
+		return
+	}
+	v.trips.mu.Lock()
+	lat, lon := v.trips.lat, v.trips.lon
+	v.trips.mu.Unlock()
+	if lat == 0 && lon == 0 {
+		return
+	}
+	key := geohash(lat, lon, placeCachePrecision)
+	v.place.mu.Lock()
+	if key == v.place.key {
+		v.place.mu.Unlock()
+		return
+	}
+	v.place.key = key
+	v.place.mu.Unlock()
+	go func() {
+		place, err := places.lookup(key, lat, lon)
+		if err != nil {
+			slog.Error("error looking up the place", "vehicle", v.id, "err", err)
+			v.place.mu.Lock()
+			if v.place.key == key {
+				// Retried on the next update.
+				v.place.key = ""
+			}
+			v.place.mu.Unlock()
+			return
+		}
+		v.place.mu.Lock()
+		defer v.place.mu.Unlock()
+		if v.place.key != key {
+			return
+		}
+		positionInfo.DeletePartialMatch(prometheus.Labels{"vehicle": v.id})
+		if place != "" {
+			positionInfo.WithLabelValues(v.id, place).Set(1)
+		}
+	}()
+}
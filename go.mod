@@ -1,10 +1,14 @@
 module github.com/razvanm/ovms_exporter
 
-go 1.20
+go 1.21
 
 require (
 	github.com/prometheus/client_golang v1.15.1
-	v.io/x/lib v0.1.14
+	github.com/prometheus/client_model v0.3.0
+	github.com/prometheus/common v0.42.0
+	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.21.0
+	google.golang.org/protobuf v1.30.0
 )
 
 require (
@@ -12,9 +16,7 @@ require (
 	github.com/cespare/xxhash/v2 v2.2.0 // indirect
 	github.com/golang/protobuf v1.5.3 // indirect
 	github.com/matttproud/golang_protobuf_extensions v1.0.4 // indirect
-	github.com/prometheus/client_model v0.3.0 // indirect
-	github.com/prometheus/common v0.42.0 // indirect
 	github.com/prometheus/procfs v0.9.0 // indirect
-	golang.org/x/sys v0.6.0 // indirect
-	google.golang.org/protobuf v1.30.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )
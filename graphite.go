@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reference: https://graphite.readthedocs.io/en/latest/feeding-carbon.html
+
+var (
+	graphiteAddrFlag     = flag.String("graphite-addr", "", "Graphite/Carbon plaintext protocol host:port to which the record samples are sent; empty disables it")
+	graphitePrefixFlag   = flag.String("graphite-prefix", "ovms", "Prefix of the Graphite metric paths, followed by the vehicle")
+	graphiteIntervalFlag = flag.Duration("graphite-interval", time.Minute, "Interval of the sends to -graphite-addr")
+)
+
+var graphiteFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ovms_graphite_send_failures_total",
+	Help: "Number of failed sends to -graphite-addr.",
+})
+
+// graphiteUnsafe matches the characters not kept in a Graphite path node.
+var graphiteUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+func setupGraphite() error {
+	if *graphiteAddrFlag == "" {
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(*graphiteAddrFlag); err != nil {
+		return fmt.Errorf("invalid -graphite-addr: %v", err)
+	}
+	if *graphiteIntervalFlag <= 0 {
+		return fmt.Errorf("invalid -graphite-interval %v", *graphiteIntervalFlag)
+	}
+	return nil
+}
+
+// graphitePath returns the Graphite path of a series: the prefix, the
+// vehicle, the metric name without the metric prefix and the values of the
+// other labels, in name order.
+func graphitePath(s *remoteSeries) string {
+	var name, vehicle string
+	var rest []string
+	for _, l := range s.labels {
+		switch l.GetName() {
+		case "__name__":
+			name = strings.TrimPrefix(l.GetValue(), *metricPrefixFlag+"_")
+		case "vehicle":
+			vehicle = l.GetValue()
+		default:
+			rest = append(rest, graphiteUnsafe.ReplaceAllString(l.GetValue(), "_"))
+		}
+	}
+	nodes := []string{*graphitePrefixFlag, graphiteUnsafe.ReplaceAllString(vehicle, "_"), name}
+	return strings.Join(append(nodes, rest...), ".")
+}
+
+// sendGraphite sends the latest samples of the vehicles, all at the time of
+// the send so that the Graphite series stay continuous between the records.
+func sendGraphite(now time.Time) error {
+	var b strings.Builder
+	for _, v := range vehicles {
+		b.WriteString(v.records.text())
+	}
+	series, err := parseRemoteSeries(b.String())
+	if err != nil {
+		return err
+	}
+	if len(series) == 0 {
+		return nil
+	}
+	conn, err := dialOutbound(*graphiteAddrFlag, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(now.Add(30 * time.Second))
+	w := bufio.NewWriter(conn)
+	for _, s := range series {
+		last := s.samples[len(s.samples)-1]
+		fmt.Fprintf(w, "%s %g %d\n", graphitePath(s), last.value, now.Unix())
+	}
+	return w.Flush()
+}
+
+// runGraphite sends the samples to -graphite-addr every -graphite-interval.
+func runGraphite() {
+	if *graphiteAddrFlag == "" {
+		return
+	}
+	for now := range time.Tick(*graphiteIntervalFlag) {
+		if err := sendGraphite(now); err != nil {
+			graphiteFailures.Inc()
+			slog.Error("error sending to Graphite", "addr", *graphiteAddrFlag, "err", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The histograms have both classic buckets and native buckets, the latter
+// being exposed to the Prometheus servers scraping the protobuf format.
+var (
+	speedHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                        "ovms_speed_kmh_histogram",
+		Help:                        "Distribution of the speed while driving, from the L records.",
+		Buckets:                     []float64{10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 110, 120, 130, 140, 160, 180, 200},
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"vehicle"})
+	batPowerHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:                        "ovms_bat_power_watts_histogram",
+		Help:                        "Distribution of the battery power while driving, from the L records; negative when regenerating.",
+		Buckets:                     []float64{-50000, -25000, -10000, -5000, 0, 5000, 10000, 20000, 30000, 50000, 75000, 100000, 150000, 200000},
+		NativeHistogramBucketFactor: 1.1,
+	}, []string{"vehicle"})
+)
+
+// distributionTracker observes the speed and the power of the L records in
+// the histograms. A record is only observed once, when its message time
+// changes.
+type distributionTracker struct {
+	vehicle string
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// update processes the fields of an L record. Only the records taken while
+// driving are observed, for the distributions not to be dominated by the
+// parked vehicle.
+func (t *distributionTracker) update(fields map[string]string, ts time.Time, driving, miles bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !ts.After(t.last) {
+		return
+	}
+	t.last = ts
+	if !driving {
+		return
+	}
+	if speed, err := strconv.ParseFloat(fields["ms_v_pos_speed"], 64); err == nil {
+		if miles {
+			speed *= kmPerMile
+		}
+		speedHistogram.WithLabelValues(t.vehicle).Observe(speed)
+	}
+	// The power is in kW.
+	if power, err := strconv.ParseFloat(fields["ms_v_bat_power"], 64); err == nil {
+		batPowerHistogram.WithLabelValues(t.vehicle).Observe(power * 1000)
+	}
+}
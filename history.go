@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var historyWindowFlag = flag.Duration("history-window", 6*time.Hour, "How long the parsed samples are kept in memory for /api/v1/history; 0 disables it")
+
+// maxHistoryPoints caps the samples kept per metric of a vehicle.
+const maxHistoryPoints = 10000
+
+type historyPoint struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// historyRing is a ring buffer of the samples of a metric, growing up to
+// maxHistoryPoints.
+type historyRing struct {
+	points   []historyPoint
+	start, n int
+}
+
+func (h *historyRing) at(i int) historyPoint {
+	return h.points[(h.start+i)%len(h.points)]
+}
+
+// push adds a sample newer than the others, dropping those older than the
+// cutoff and the oldest one when full.
+func (h *historyRing) push(p historyPoint, cutoff time.Time) {
+	for h.n > 0 && h.at(0).Time.Before(cutoff) {
+		h.start = (h.start + 1) % len(h.points)
+		h.n--
+	}
+	if h.n == len(h.points) && h.n < maxHistoryPoints {
+		points := make([]historyPoint, min(max(2*h.n, 64), maxHistoryPoints))
+		for i := 0; i < h.n; i++ {
+			points[i] = h.at(i)
+		}
+		h.points, h.start = points, 0
+	}
+	if h.n == len(h.points) {
+		h.start = (h.start + 1) % len(h.points)
+		h.n--
+	}
+	h.points[(h.start+h.n)%len(h.points)] = p
+	h.n++
+}
+
+// historyBuffer holds the recent samples of the numeric fields of a
+// vehicle, by exported metric name.
+type historyBuffer struct {
+	mu     sync.Mutex
+	series map[string]*historyRing
+}
+
+// add processes the fields of a record. The samples not newer than the
+// latest one of their metric are skipped, e.g. the same record polled again.
+func (b *historyBuffer) add(code string, fields map[string]string, ts time.Time) {
+	if *historyWindowFlag <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.series == nil {
+		b.series = map[string]*historyRing{}
+	}
+	cutoff := ts.Add(-*historyWindowFlag)
+	for field, val := range fields {
+		f, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			continue
+		}
+		name := metricName("ovms_" + code + "_" + field)
+		if !filter.collectMetric(name) {
+			continue
+		}
+		h, ok := b.series[name]
+		if !ok {
+			h = &historyRing{}
+			b.series[name] = h
+		}
+		if h.n > 0 && !ts.After(h.at(h.n-1).Time) {
+			continue
+		}
+		h.push(historyPoint{ts, f}, cutoff)
+	}
+}
+
+// purge drops the samples.
+func (b *historyBuffer) purge() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.series = nil
+}
+
+// since returns the samples of a metric newer than a time, oldest first,
+// and whether the metric is known.
+func (b *historyBuffer) since(name string, start time.Time) ([]historyPoint, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.series[name]
+	if !ok {
+		return nil, false
+	}
+	points := []historyPoint{}
+	for i := 0; i < h.n; i++ {
+		if p := h.at(i); p.Time.After(start) {
+			points = append(points, p)
+		}
+	}
+	return points, true
+}
+
+type historySeries struct {
+	Vehicle string         `json:"vehicle"`
+	Samples []historyPoint `json:"samples"`
+}
+
+// handleHistory serves /api/v1/history?metric=<name>[&vehicle=<id>]
+// [&since=<time>] with the recent samples of a metric, by its exported name,
+// of the vehicles. since is an RFC 3339 time or a duration back from now,
+// e.g. 1h, and defaults to the whole -history-window.
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	if *historyWindowFlag <= 0 {
+		http.Error(w, "the history is disabled, see -history-window", http.StatusNotFound)
+		return
+	}
+	name := r.FormValue("metric")
+	if name == "" {
+		http.Error(w, "metric is required, e.g. ?metric=ovms_S_ms_v_bat_soc", http.StatusBadRequest)
+		return
+	}
+	now := time.Now()
+	start := now.Add(-*historyWindowFlag)
+	if s := r.FormValue("since"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			start = now.Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+			start = t
+		} else {
+			http.Error(w, "invalid since, expected an RFC 3339 time or a duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	selected := vehicles
+	if id := r.FormValue("vehicle"); id != "" {
+		v := findVehicle(id)
+		if v == nil {
+			http.Error(w, "unknown vehicle", http.StatusNotFound)
+			return
+		}
+		selected = []*vehicle{v}
+	}
+	series := []historySeries{}
+	for _, v := range selected {
+		if points, ok := v.history.since(name, start); ok {
+			series = append(series, historySeries{v.id, points})
+		}
+	}
+	writeShapedJSON(w, r, struct {
+		Metric string          `json:"metric"`
+		Series []historySeries `json:"series"`
+	}{name, series})
+}
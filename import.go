@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const importUsage = `usage: ovms_exporter [flags] import [-remote-write url] <file.csv>...
+
+Imports the records of CSV exports, e.g. of the OVMS web dashboard or of the
+app logs, into the history of -state-dir: the trips of the mileage log, the
+battery degradation and the tires. The records are processed like the polled
+ones, in time order; those not newer than the existing history are skipped,
+so the import is best done before the first run of the exporter. The
+import raises no events or notifications and writes nothing to the record
+log, the archive or the event stream.
+
+The CSV files need a header naming the columns of the time (m_msgtime,
+h_timestamp, timestamp or time), the record code (m_code or code) and the
+message (m_msg, h_data or msg). The vehicle is taken from the m_vehicleid,
+h_vehicleid or vehicle column, from -vehicle otherwise.
+
+With -remote-write, the samples of the records are also sent to a Prometheus
+remote write endpoint, which must accept out-of-order samples. With
+-victoriametrics-url, they are also sent to its import API, which accepts
+the old timestamps.
+
+Flags:`
+
+// importColumns are the accepted names of the CSV columns.
+var importColumns = map[string][]string{
+	"time":    {"m_msgtime", "h_timestamp", "timestamp", "time"},
+	"code":    {"m_code", "code"},
+	"msg":     {"m_msg", "h_data", "msg"},
+	"vehicle": {"m_vehicleid", "h_vehicleid", "vehicle"},
+}
+
+// importTimeLayouts are the accepted formats of the time column.
+// Those without a zone are in -server-timezone.
+var importTimeLayouts = []string{msgTimeLayout, time.RFC3339, "2006-01-02T15:04:05"}
+
+// importedRecord is a record read from a CSV file.
+type importedRecord struct {
+	vehicle string
+	ts      time.Time
+	rec     record
+}
+
+// readImportCSV reads the records of a CSV file.
+func readImportCSV(r io.Reader, defaultVehicle string) ([]importedRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for col, names := range importColumns {
+			if _, ok := cols[col]; !ok && contains(names, name) {
+				cols[col] = i
+			}
+		}
+	}
+	for _, col := range []string{"time", "code", "msg"} {
+		if _, ok := cols[col]; !ok {
+			return nil, fmt.Errorf("no %s column, expected one of %s", col, strings.Join(importColumns[col], ", "))
+		}
+	}
+	if _, ok := cols["vehicle"]; !ok && defaultVehicle == "" {
+		return nil, fmt.Errorf("no vehicle column and no single -vehicle")
+	}
+
+	var records []importedRecord
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		field := func(col string) string {
+			if i, ok := cols[col]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		var ts time.Time
+		for _, layout := range importTimeLayouts {
+			if ts, err = time.ParseInLocation(layout, field("time"), serverLocation); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid time %q", line, field("time"))
+		}
+		ir := importedRecord{
+			vehicle: field("vehicle"),
+			ts:      ts,
+			rec:     record{Code: field("code"), Msg: field("msg"), MsgTime: ts.In(serverLocation).Format(msgTimeLayout)},
+		}
+		if ir.vehicle == "" {
+			ir.vehicle = defaultVehicle
+		}
+		records = append(records, ir)
+	}
+}
+
+// historyEnd returns the time of the latest data in the history of the
+// vehicle.
+func (v *vehicle) historyEnd() time.Time {
+	v.degradation.mu.Lock()
+	end := v.degradation.LastTS
+	v.degradation.mu.Unlock()
+	if entries, _ := v.mileage.snapshot(); len(entries) > 0 && entries[len(entries)-1].End.After(end) {
+		end = entries[len(entries)-1].End
+	}
+	return end
+}
+
+// importCommand returns the flags of the import command and its function.
+func importCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	remoteWriteURL := fs.String("remote-write", "", "Prometheus remote write URL to which the samples are also sent")
+	batch := fs.Int("batch", 1000, "Number of records per remote write or VictoriaMetrics import request")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), importUsage)
+		fs.PrintDefaults()
+	}
+	return fs, func(args []string) error {
+		if len(args) == 0 || *batch <= 0 {
+			fs.Usage()
+			return fmt.Errorf("invalid arguments")
+		}
+		if *stateDirFlag == "" {
+			return fmt.Errorf("import needs -state-dir")
+		}
+		ids, err := parseVehicleIDs(*vehicleIDFlag)
+		if err != nil {
+			return err
+		}
+		defaultVehicle := ""
+		if len(ids) == 1 {
+			defaultVehicle = ids[0]
+		}
+
+		var records []importedRecord
+		for _, name := range args {
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			recs, err := readImportCSV(f, defaultVehicle)
+			f.Close()
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			records = append(records, recs...)
+		}
+		sort.SliceStable(records, func(i, j int) bool { return records[i].ts.Before(records[j].ts) })
+
+		imported := map[string]*vehicle{}
+		ends := map[string]time.Time{}
+		var samples strings.Builder
+		pending, skipped := 0, 0
+		flush := func() error {
+			if (*remoteWriteURL == "" && *victoriaMetricsURLFlag == "") || pending == 0 {
+				return nil
+			}
+			series, err := parseRemoteSeries(samples.String())
+			if err != nil {
+				return err
+			}
+			if *remoteWriteURL != "" {
+				if err := remoteWrite(*remoteWriteURL, series); err != nil {
+					return err
+				}
+			}
+			if *victoriaMetricsURLFlag != "" {
+				if err := vmImport(*victoriaMetricsURLFlag, series); err != nil {
+					return err
+				}
+			}
+			samples.Reset()
+			pending = 0
+			return nil
+		}
+		for _, ir := range records {
+			v, ok := imported[ir.vehicle]
+			if !ok {
+				if v, err = newVehicle(ir.vehicle); err != nil {
+					return err
+				}
+				v.importing = true
+				imported[ir.vehicle] = v
+				ends[ir.vehicle] = v.historyEnd()
+			}
+			if !ir.ts.After(ends[ir.vehicle]) {
+				skipped++
+				continue
+			}
+			v.processRecord(ir.rec, ir.ts)
+			if rec, ok := v.records.get(ir.rec.Code); ok && rec.ts.Equal(ir.ts) {
+				for _, s := range rec.samples {
+					samples.WriteString(s)
+					samples.WriteByte('\n')
+				}
+			}
+			if pending++; pending >= *batch {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		for id, v := range imported {
+			trips, _ := v.mileage.snapshot()
+			slog.Info("imported", "vehicle", id, "trips", len(trips), "history_end", v.historyEnd())
+		}
+		slog.Info("import done", "records", len(records)-skipped, "skipped", skipped)
+		return nil
+	}
+}
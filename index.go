@@ -0,0 +1,62 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	_ "net/http/pprof"
+	"time"
+)
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>OVMS Exporter</title></head>
+<body>
+<h1>OVMS Exporter</h1>
+<p>Version {{.Version}}</p>
+<h2>Vehicles</h2>
+<table>
+<tr><th>Vehicle</th><th>Last fetch</th><th>Status</th></tr>
+{{range .Vehicles}}<tr><td>{{.ID}}</td><td>{{if .LastFetch.IsZero}}never{{else}}{{.LastFetch.Format "2006-01-02 15:04:05 MST"}}{{end}}</td><td>{{.Status}}</td></tr>
+{{end}}</table>
+<h2>Links</h2>
+<ul>
+<li><a href="/ui/">/ui</a></li>
+<li><a href="/metrics">/metrics</a></li>
+<li><a href="/metrics_ovms">/metrics_ovms</a></li>
+<li><a href="/healthz">/healthz</a></li>
+<li><a href="/report/fleet">/report/fleet</a></li>
+<li><a href="/debug/pprof/">/debug/pprof</a></li>
+</ul>
+</body>
+</html>
+`))
+
+type indexVehicle struct {
+	ID        string
+	LastFetch time.Time
+	Status    string
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := struct {
+		Version  string
+		Vehicles []indexVehicle
+	}{Version: version}
+	for _, v := range vehicles {
+		last, status := v.fetchStatus()
+		data.Vehicles = append(data.Vehicles, indexVehicle{v.id, last, status})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, data); err != nil {
+		slog.Error("error rendering the index page", "err", err)
+	}
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+var metricPrefixFlag = flag.String("metric-prefix", "ovms", "Prefix of the exported metric names")
+
+// staticLabels implements flag.Value for the repeatable -label key=value flag.
+type staticLabels []*dto.LabelPair
+
+func (l *staticLabels) String() string {
+	var pairs []string
+	for _, lp := range *l {
+		pairs = append(pairs, lp.GetName()+"="+lp.GetValue())
+	}
+	return strings.Join(pairs, ",")
+}
+
+var labelNameRE = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func (l *staticLabels) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || !labelNameRE.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label %q, expected name=value", s)
+	}
+	switch name {
+	case "vehicle", "value":
+		return fmt.Errorf("label %q is reserved", name)
+	}
+	for _, lp := range *l {
+		if lp.GetName() == name {
+			return fmt.Errorf("duplicate label %q", name)
+		}
+	}
+	*l = append(*l, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	return nil
+}
+
+var staticLabelsFlag staticLabels
+
+func init() {
+	flag.Var(&staticLabelsFlag, "label", "Static name=value label added to every exported series; may be repeated")
+}
+
+func checkMetricPrefix() error {
+	if !labelNameRE.MatchString(*metricPrefixFlag) {
+		return fmt.Errorf("invalid -metric-prefix %q", *metricPrefixFlag)
+	}
+	return nil
+}
+
+// metricName returns the exported name of a metric: the configured rename,
+// otherwise the name with -metric-prefix applied if it starts with "ovms_".
+func metricName(name string) string {
+	if r := cfg().renameRule(name); r != nil {
+		return r.To
+	}
+	if rest, ok := strings.CutPrefix(name, "ovms_"); ok {
+		return *metricPrefixFlag + "_" + rest
+	}
+	return name
+}
+
+// staticLabelsText returns the static labels formatted for the text format,
+// with a leading comma.
+func staticLabelsText() string {
+	var b strings.Builder
+	for _, lp := range staticLabelsFlag {
+		fmt.Fprintf(&b, ",%s=%q", lp.GetName(), lp.GetValue())
+	}
+	return b.String()
+}
+
+// exportGatherer renames the metrics according to -metric-prefix, adds the
+// static labels and applies the metric filter.
+type exportGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g exportGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	var kept []*dto.MetricFamily
+	for _, mf := range mfs {
+		if r := cfg().renameRule(mf.GetName()); r != nil {
+			applyRename(mf, r)
+		} else {
+			mf.Name = proto.String(metricName(mf.GetName()))
+		}
+		if !filter.collectMetric(mf.GetName()) {
+			continue
+		}
+		if len(staticLabelsFlag) > 0 {
+			for _, m := range mf.Metric {
+				m.Label = append(m.Label, staticLabelsFlag...)
+				sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+			}
+		}
+		kept = append(kept, mf)
+	}
+	return kept, err
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	latencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ovms_end_to_end_latency_seconds",
+		Help:    "Delay between the vehicle message time and the moment its samples became visible.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+	}, []string{"vehicle", "code"})
+	lastLatencySeconds = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_last_end_to_end_latency_seconds",
+		Help: "End-to-end latency of the latest new record.",
+	}, []string{"vehicle", "code"})
+)
+
+// latencyTracker measures the end-to-end latency of the records. A record is
+// only observed once, when its message time changes.
+type latencyTracker struct {
+	vehicle string
+
+	mu       sync.Mutex
+	pending  map[string]time.Time
+	observed map[string]time.Time
+}
+
+// seen notes the message time of a record fetched from the server.
+func (t *latencyTracker) seen(code string, ts time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.observed[code].Equal(ts) {
+		return
+	}
+	if t.pending == nil {
+		t.pending = map[string]time.Time{}
+	}
+	t.pending[code] = ts
+}
+
+// published observes the latency of the records seen since the last call,
+// now that their samples are exposed.
+func (t *latencyTracker) published(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.observed == nil {
+		t.observed = map[string]time.Time{}
+	}
+	for code, ts := range t.pending {
+		d := now.Sub(ts).Seconds()
+		latencySeconds.WithLabelValues(t.vehicle, code).Observe(d)
+		lastLatencySeconds.WithLabelValues(t.vehicle, code).Set(d)
+		t.observed[code] = ts
+	}
+	t.pending = nil
+}
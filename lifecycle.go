@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+var (
+	// quit is closed by /-/quit to stop the exporter.
+	quit     = make(chan struct{})
+	quitOnce sync.Once
+)
+
+// handleReload reloads the config, like refreshConfig does periodically.
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if *configFileFlag == "" {
+		w.Write([]byte("no config to reload\n"))
+		return
+	}
+	c, err := loadConfig(*configFileFlag)
+	if err != nil {
+		slog.Error("error reloading the config", "source", *configFileFlag, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	currentConfig.Store(c)
+	slog.Info("config reloaded", "source", *configFileFlag)
+	w.Write([]byte("config reloaded\n"))
+}
+
+// handleReady reports ready once every vehicle was fetched at least once.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	for _, v := range vehicles {
+		if t, _ := v.fetchStatus(); t.IsZero() {
+			http.Error(w, "vehicle "+v.id+" not fetched yet", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Write([]byte("ready\n"))
+}
+
+// handleQuit stops the exporter. It requires the -admin-token.
+func handleQuit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		w.Header().Set("Allow", "POST, PUT")
+		http.Error(w, "only POST or PUT requests allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	w.Write([]byte("exiting\n"))
+	quitOnce.Do(func() { close(quit) })
+}
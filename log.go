@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var (
+	logLevelFlag  = flag.String("log-level", "info", "Log level: debug, info, warn or error")
+	logFormatFlag = flag.String("log-format", "text", "Log format: text or json")
+)
+
+// setupLogging configures the default slog logger from the flags.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevelFlag)); err != nil {
+		return fmt.Errorf("invalid -log-level %q", *logLevelFlag)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch *logFormatFlag {
+	case "text":
+		h = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -log-format %q", *logFormatFlag)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
@@ -1,29 +1,36 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	"v.io/x/lib/vlog"
 )
 
 var (
-	addrFlag         = flag.String("addr", ":8080", "Address to listen on")
 	usernameFlag     = flag.String("username", os.Getenv("OVMS_USERNAME"), "OVMS server username")
 	passwordFlag     = flag.String("password", os.Getenv("OVMS_PASSWORD"), "OVMS server password")
-	vehicleIDFlag    = flag.String("vehicle", "", "OVMS server password")
-	ovmsSeverFlag    = flag.String("server", "api.openvehicles.com:6868", "OVMS server")
+	tokenFlag        = flag.String("token", os.Getenv("OVMS_TOKEN"), "OVMS server API token, used instead of the password")
+	vehicleIDFlag    = flag.String("vehicle", "", "Comma-separated OVMS vehicle IDs")
+	ovmsSeverFlag    = flag.String("server", "api.openvehicles.com:6868", "Comma-separated OVMS servers; the first is used while it is up, the others are fallbacks")
 	pollDurationFlag = flag.Duration("poll-duration", time.Minute, "How frequently to poll OVMS server")
+	ignoreOlderFlag  = flag.Duration("ignore-older-than", 0, "Skip records older than this; 0 keeps all records")
+	maxResponseFlag  = flag.Int64("max-response-size", 10<<20, "Maximum size in bytes of an OVMS server response")
 )
 
 type record struct {
@@ -145,6 +152,49 @@ var yMetrics = []string{
 	"defstale_alert",           // 17	defstale_alert
 }
 
+// fixedPointFields are the fields sent as integers in tenths of their unit,
+// with the divisor that converts them back:
+//   - ms_v_charge_kwh (S): energy of the charge session, in kWh.
+//   - ms_v_pos_trip (D and L): trip distance, in m_units_distance.
+//   - ms_v_pos_odometer (D): odometer, in m_units_distance.
+var fixedPointFields = map[string]float64{
+	"ms_v_charge_kwh":   10,
+	"ms_v_pos_trip":     10,
+	"ms_v_pos_odometer": 10,
+}
+
+// scaleField converts a fixed-point field to its unit.
+func scaleField(name, val string) string {
+	div, ok := fixedPointFields[name]
+	if !ok {
+		return val
+	}
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return val
+	}
+	return strconv.FormatFloat(v/div, 'f', -1, 64)
+}
+
+// booleanFields maps the wire encoding of the boolean fields to 0/1.
+var booleanFields = map[string]map[string]string{
+	"ms_v_charge_timermode": {"no": "0", "yes": "1"},
+	"ms_v_env_cooling":      {"-1": "0", "0": "1"},
+	"ms_v_env_locked":       {"5": "0", "4": "1"},
+	"ms_v_pos_gpslock":      {"no": "0", "yes": "1"},
+}
+
+// normalizeField converts a field to its unit or to 0/1 for booleans.
+func normalizeField(name, val string) string {
+	if m, ok := booleanFields[name]; ok {
+		if b, ok := m[val]; ok {
+			return b
+		}
+		return val
+	}
+	return scaleField(name, val)
+}
+
 var metricsMap = map[string][]string{
 	"S": sMetrics,
 	"D": dMetrics,
@@ -152,98 +202,494 @@ var metricsMap = map[string][]string{
 	"Y": yMetrics,
 }
 
-func fetch() []byte {
-	urlPrefix := fmt.Sprintf("http://%s/api/protocol/%s", *ovmsSeverFlag, *vehicleIDFlag)
-	resp, err := http.Get(fmt.Sprintf("%s?username=%s&password=%s", urlPrefix, url.QueryEscape(*usernameFlag), url.QueryEscape(*passwordFlag)))
+// recordFields maps the field names of a record to their values. For names
+// that appear twice (e.g. ms_v_charge_state in the S record) the first,
+// human readable, value is kept.
+func recordFields(names []string, data []string) map[string]string {
+	fields := make(map[string]string, len(data))
+	for i, val := range data {
+		if i >= len(names) {
+			break
+		}
+		if _, ok := fields[names[i]]; !ok {
+			fields[names[i]] = val
+		}
+	}
+	return fields
+}
+
+// errResponseTooLarge is returned once more than -max-response-size bytes were read.
+var errResponseTooLarge = errors.New("response exceeds -max-response-size")
+
+// maxBytesReader is like io.LimitReader but fails instead of silently
+// truncating the response.
+type maxBytesReader struct {
+	r io.Reader
+	n int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n <= 0 {
+		return 0, errResponseTooLarge
+	}
+	if int64(len(p)) > m.n {
+		p = p[:m.n]
+	}
+	n, err := m.r.Read(p)
+	m.n -= int64(n)
+	return n, err
+}
+
+// isJSONContentType reports whether the Content-Type header denotes JSON.
+func isJSONContentType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
 	if err != nil {
-		vlog.Errorf("Error fetching %q: %v", urlPrefix, err)
-		return nil
+		return false
 	}
+	return mt == "application/json" || mt == "text/json" || strings.HasSuffix(mt, "+json")
+}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+// maxAnnouncementBody caps how much of a maintenance response is read.
+const maxAnnouncementBody = 4096
+
+// protocolURL returns the URL of the records of a vehicle, without the
+// authentication.
+func protocolURL(vehicleID string) string {
+	return fmt.Sprintf("http://%s/api/protocol/%s", vehicleServer(vehicleID).currentAddr(), vehicleID)
+}
+
+// fetch calls fn with every record of the vehicle and reports whether the
+// response was read entirely, and if not whether it was malformed rather
+// than not received, with the status of the response, 0 if none, and the
+// reason of the error response in place of the records, if any. The
+// response body, as read, is also written to raw if not nil.
+func fetch(vehicleID string, raw io.Writer, fn func(rec record)) (ok, malformed bool, status int, reason string) {
+	urlPrefix := protocolURL(vehicleID)
+	var resp *http.Response
+	var err error
+	if *replayFlag != "" {
+		resp, err = replay.response(vehicleID)
+	} else {
+		resp, err = vehicleServer(vehicleID).get(urlPrefix + "?" + vehicleAuthQuery(vehicleID).Encode())
+	}
 	if err != nil {
-		vlog.Errorf("Error reading the response for %q: %v", urlPrefix, err)
-		return nil
+		slog.Error("fetch failed", "vehicle", vehicleID, "url", urlPrefix, "err", withoutURL(err))
+		return false, false, 0, ""
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	var body io.Reader = resp.Body
+	if raw != nil {
+		body = io.TeeReader(resp.Body, raw)
+	}
+
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		// The server is down for maintenance and the body carries the announcement.
+		body, err := io.ReadAll(io.LimitReader(body, maxAnnouncementBody))
+		if err != nil {
+			slog.Error("error reading the response", "vehicle", vehicleID, "url", urlPrefix, "err", err)
+			return false, false, status, ""
+		}
+		setAnnouncement(string(body))
+		return false, false, status, ""
+	}
+	clearAnnouncement()
+
+	// The records are a JSON array, anything else is an error response,
+	// e.g. a plain text or a JSON object for a wrong password.
+	br := bufio.NewReader(&maxBytesReader{r: body, n: *maxResponseFlag})
+	ct := resp.Header.Get("Content-Type")
+	if !isJSONContentType(ct) || !isJSONArray(br) {
+		e := readAPIError(vehicleID, resp.StatusCode, br)
+		slog.Error("OVMS API error instead of the records", "vehicle", vehicleID, "url", urlPrefix, "status", resp.Status, "content_type", ct, "reason", e.reason, "message", e.message)
+		// An unexpected JSON response with the records is likely a parser bug.
+		return false, e.reason == "unexpected" && isJSONContentType(ct), status, e.reason
+	}
+
+	dec := json.NewDecoder(br)
+	if _, err := dec.Token(); err != nil {
+		slog.Error("error decoding the response", "vehicle", vehicleID, "url", urlPrefix, "err", err)
+		return false, true, status, ""
+	}
+	for dec.More() {
+		var rec record
+		if err := dec.Decode(&rec); err != nil {
+			slog.Error("error decoding a record", "vehicle", vehicleID, "url", urlPrefix, "err", err)
+			return false, true, status, ""
+		}
+		fn(rec)
 	}
+	if _, err := dec.Token(); err != nil {
+		slog.Error("error decoding the response", "vehicle", vehicleID, "url", urlPrefix, "err", err)
+		return false, true, status, ""
+	}
+
+	return true, false, status, ""
+}
+
+// sampleFormatter formats a timestamped sample of the given vehicle. labels
+// are extra label name and value pairs.
+type sampleFormatter func(name, vehicle, val string, ts time.Time, labels ...string) string
+
+// formatSample formats a sample as exported, with the -metric-prefix, the
+// static labels and the renames of the config.
+func formatSample(name, vehicle, val string, ts time.Time, labels ...string) string {
+	// The value of the samples with a value label is always 1.
+	if r := cfg().renameRule(name); r != nil && (len(labels) == 0 || labels[0] != "value") {
+		val = r.applyText(val)
+	}
+	return writeSample(metricName(name), vehicle, val, ts, labels, staticLabelsText())
+}
 
-	return body
+// canonicalSample formats a sample as formatSample does with the default
+// flags and no config, for the fixtures to match whatever the setup.
+func canonicalSample(name, vehicle, val string, ts time.Time, labels ...string) string {
+	return writeSample(name, vehicle, val, ts, labels, "")
 }
 
-func promMetric(name string, val string, ts time.Time) string {
-	tsMillis := ts.UnixMilli()
+// writeSample formats a sample, extra being the static labels, if any, with
+// a leading comma.
+func writeSample(name, vehicle, val string, ts time.Time, labels []string, extra string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s{vehicle=%q", name, vehicle)
+	for i := 0; i+1 < len(labels); i += 2 {
+		fmt.Fprintf(&b, ",%s=%q", labels[i], labels[i+1])
+	}
+	b.WriteString(extra)
+	fmt.Fprintf(&b, "} %s %d", val, ts.UnixMilli())
+	return b.String()
+}
+
+func promMetric(format sampleFormatter, name, vehicle, val string, ts time.Time) string {
 	if _, err := strconv.ParseFloat(val, 64); err != nil {
 		// Put the non-numeric value in the label.
-		return fmt.Sprintf("%s{value=%q} 1 %d", name, val, tsMillis)
+		return format(name, vehicle, "1", ts, "value", val)
 	}
 
-	return fmt.Sprintf("%s %s %d", name, val, tsMillis)
+	return format(name, vehicle, val, ts)
 }
 
-func fetchMetrics() string {
+// decodeRecord returns the fields of a record of a known code and its
+// samples.
+func decodeRecord(vehicleID string, rec record, ts time.Time) (map[string]string, []string, bool) {
+	fields, metrics, ok := decodeRecordAs(formatSample, vehicleID, rec, ts)
+	if ok && rec.Code == "L" {
+		metrics = append(metrics, geofenceMetrics(vehicleID, fields, ts)...)
+	}
+	return fields, metrics, ok
+}
+
+// decodeRecordAs returns the fields of a record of a known code and its
+// samples formatted by format, without those of the geofences of the config.
+func decodeRecordAs(format sampleFormatter, vehicleID string, rec record, ts time.Time) (map[string]string, []string, bool) {
+	ctx := context.Background()
+	m, ok := metricsMap[rec.Code]
+	if !ok {
+		return nil, nil, false
+	}
+	data := strings.Split(rec.Msg, ",")
 	var metrics []string
+	for i, val := range data {
+		if i >= len(m) {
+			slog.Log(ctx, pollLogLevel, "ignoring extra fields", "vehicle", vehicleID, "code", rec.Code, "count", len(data)-len(m))
+			break
+		}
+		val = normalizeField(m[i], val)
+		data[i] = val
+		slog.Log(ctx, pollLogLevel-4, "field", "vehicle", vehicleID, "code", rec.Code, "index", i, "name", m[i], "value", val)
+		metrics = append(metrics, promMetric(format, fmt.Sprintf("ovms_%s_%s", rec.Code, m[i]), vehicleID, val, ts))
+	}
+	fields := recordFields(m, data)
+	switch rec.Code {
+	case "D":
+		metrics = append(metrics, doorMetrics(format, vehicleID, fields, ts)...)
+	case "L":
+		metrics = append(metrics, positionMetrics(format, vehicleID, fields, ts)...)
+	}
+	return fields, metrics, true
+}
 
-	data := fetch()
-	if data == nil || len(data) == 0 {
-		return ""
+// processRecord updates the trackers with a record and stores it.
+func (v *vehicle) processRecord(rec record, ts time.Time) {
+	v.latency.seen(rec.Code, ts)
+	v.records.seen(ts)
+	slog.Log(context.Background(), pollLogLevel, "record", "vehicle", v.id, "code", rec.Code, "ts", ts, "data", strings.Split(rec.Msg, ","))
+
+	if rec.Code == notificationCode {
+		v.notification(rec.Msg, ts)
+		return
+	}
+	if _, ok := metricsMap[rec.Code]; !ok {
+		v.decodeCustomRecord(rec, ts)
+		return
 	}
 
-	records := []record{}
-	if err := json.Unmarshal(data, &records); err != nil {
-		vlog.Errorf("JSON error unmashaling %q: ", string(data), err)
-		return ""
+	if !filter.collectGroup(rec.Code) {
+		return
+	}
+	fields, metrics, ok := decodeRecord(v.id, rec, ts)
+	if !ok {
+		return
 	}
+	if !v.importing {
+		v.recordLog.append(rec, ts)
+		v.archive.append(rec.Code, fields, ts)
+		events.publish(recordEvent{Vehicle: v.id, Code: rec.Code, Time: ts, Fields: fields})
+	}
+	switch rec.Code {
+	case "S":
+		v.charge.update(fields, ts)
+		v.chargeCurve.update(fields, ts)
+		v.trips.setUnits(fields)
+		v.degradation.update(fields, ts)
+	case "D":
+		v.trips.updateDrive(fields, ts)
+		// The service reminders are due by the current time, not by that
+		// of the imported records.
+		if !v.importing {
+			v.service.update(v.odometerKm(), time.Now())
+		}
+		v.openDoors.update(fields)
+		v.battery12V.update(fields, ts, v.state() == stateParked)
+	case "L":
+		v.trips.updatePosition(fields)
+		v.track.add(fields, ts)
+		v.distributions.update(fields, ts, v.state() == stateDriving, v.field("S", "m_units_distance") == "M")
+	case "Y":
+		v.tires.update(fields, v.odometerKm(), ts)
+	}
+	v.history.add(rec.Code, fields, ts)
+	v.records.set(rec.Code, parsedRecord{ts, fields, filter.filterSamples(metrics)})
+}
 
-	vlog.Infof("num records: %d", len(records))
+// fetchMetrics polls the records of the vehicle and stores them. It reports
+// whether the fetch succeeded; the records of the codes that were not
+// received are kept.
+func (v *vehicle) fetchMetrics() bool {
+	ctx := context.Background()
+	start := time.Now()
+	numRecords := 0
+	var raw bytes.Buffer
+	var diagnostics []recordDiagnostic
+	var newest time.Time
 
-	for _, rec := range records {
-		ts, err := time.ParseInLocation("2006-01-02 15:04:05", rec.MsgTime, time.UTC)
+	ok, malformed, status, reason := fetch(v.id, &raw, func(rec record) {
+		numRecords++
+		ts, err := parseMsgTime(rec.MsgTime)
 		if err != nil {
-			vlog.Errorf("Error parsing time %q from record %q: %v", rec.MsgTime, rec, err)
-			continue
+			slog.Error("error parsing the record time", "vehicle", v.id, "code", rec.Code, "msgtime", rec.MsgTime, "err", err)
+			diagnostics = append(diagnostics, diagnose(rec, err, "invalid time"))
+			return
 		}
-
-		data := strings.Split(rec.Msg, ",")
-		vlog.Infof("%v: %q", ts, data)
-
-		if m, ok := metricsMap[rec.Code]; ok {
-			for i, val := range data {
-				vlog.VI(1).Infof("%s [%d]: %s=%q", ts, i, m[i], val)
-				metrics = append(metrics, promMetric(fmt.Sprintf("ovms_%s_%s", rec.Code, m[i]), val, ts))
-			}
+		v.checkMsgTime(rec.Code, ts, start)
+		if ts.After(newest) {
+			newest = ts
+		}
+		if *ignoreOlderFlag > 0 && time.Since(ts) > *ignoreOlderFlag {
+			slog.Log(ctx, pollLogLevel, "skipping old record", "vehicle", v.id, "code", rec.Code, "ts", ts)
+			diagnostics = append(diagnostics, diagnose(rec, nil, "older than -ignore-older-than"))
+			return
 		}
+		if !v.codeDue(rec.Code, start) {
+			diagnostics = append(diagnostics, diagnose(rec, nil, "not due per -poll-codes"))
+			return
+		}
+		diagnostics = append(diagnostics, diagnose(rec, nil, ""))
+		v.processRecord(rec, ts)
+	})
+	if ok && numRecords == 0 {
+		// The server answers with no record for the vehicles that never
+		// connected, and possibly while it is being restarted.
+		slog.Warn("empty response, keeping the previous samples", "vehicle", v.id)
+		ok = false
+	}
+	v.circuit.record(status, reason, time.Now())
+	v.raw.set(ok, raw.Bytes(), diagnostics)
+	v.capture.check(malformed, diagnostics, &v.raw)
+	v.setFetchStatus(ok)
+	if !ok {
+		return false
+	}
+	if !newest.IsZero() {
+		v.updateClockSkew(newest, time.Now())
 	}
+	v.utilization.update(v.state(), time.Now(), v.pollInterval())
+	v.updatePlannedTrips()
+	v.updateDerived()
+	v.updateChargeStation()
+	v.updateChargeControl()
+	v.updatePlace()
 
-	return strings.Join(metrics, "\n") + "\n"
+	slog.Log(ctx, pollLogLevel, "fetch done", "vehicle", v.id, "records", numRecords, "duration", time.Since(start))
+	return true
 }
 
 func main() {
 	flag.Parse()
-	vlog.ConfigureLibraryLoggerFromFlags()
+	if *versionFlag {
+		fmt.Println(versionString())
+		return
+	}
+	if err := setupLogging(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := applyProfile(); err != nil {
+		fatal("invalid profile", err)
+	}
+	checkFeatures()
+	if err := checkMetricPrefix(); err != nil {
+		fatal("invalid metric prefix", err)
+	}
+	if err := setupFilter(); err != nil {
+		fatal("invalid metric filter", err)
+	}
+	if err := setupProxy(); err != nil {
+		fatal("invalid proxy", err)
+	}
+	if err := setupPollCodes(); err != nil {
+		fatal("invalid poll codes", err)
+	}
+	if err := checkServers(); err != nil {
+		fatal("invalid server", err)
+	}
+	if err := setupPoll(); err != nil {
+		fatal("invalid poll settings", err)
+	}
+	if err := setupServerTimezone(); err != nil {
+		fatal("invalid server timezone", err)
+	}
+	if err := setupGraphite(); err != nil {
+		fatal("invalid Graphite settings", err)
+	}
+	if err := setupABRP(); err != nil {
+		fatal("invalid ABRP settings", err)
+	}
+	if err := setupMQTT(); err != nil {
+		fatal("invalid MQTT settings", err)
+	}
+	if err := setupOIDC(); err != nil {
+		fatal("invalid OIDC settings", err)
+	}
+
+	c, err := loadConfig(*configFileFlag)
+	if err != nil {
+		fatal("error loading the config", err)
+	}
+	currentConfig.Store(c)
+	if *configFileFlag != "" && *configRefreshFlag > 0 {
+		go refreshConfig(*configFileFlag, *configRefreshFlag)
+	}
+
+	if c := findSubcommand(flag.Arg(0)); c != nil {
+		if err := c.run(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	ids, err := parseVehicleIDs(*vehicleIDFlag)
+	if err != nil {
+		fatal("invalid -vehicle", err)
+	}
+	for _, id := range ids {
+		v, err := newVehicle(id)
+		if err != nil {
+			fatal("error initializing vehicle "+id, err)
+		}
+		vehicles = append(vehicles, v)
+	}
+	// The vehicle passwords can be in the config.
+	if err := setupWakeup(); err != nil {
+		fatal("invalid wakeup settings", err)
+	}
 
-	var metricsText string
-	var mu sync.RWMutex
+	go runScheduler()
+	startStreams()
+	go runGraphite()
 
 	go func() {
 		for {
-			m := fetchMetrics()
-			if m != "" {
-				mu.Lock()
-				metricsText = m
-				mu.Unlock()
-			}
-			vlog.Infof("Sleep for %v...", *pollDurationFlag)
-			time.Sleep(*pollDurationFlag)
+			pollVehicles()
+			pushMetrics()
+			victoriaMetrics.push()
+			abrp.send()
+			mqtt.publish()
+			sendAlerts()
+			pollHeartbeat()
+			d := nextPoll()
+			slog.Log(context.Background(), pollLogLevel, "sleeping", "duration", d)
+			time.Sleep(d)
 		}
 	}()
 
-	http.HandleFunc("/metrics_ovms", func(w http.ResponseWriter, r *http.Request) {
-		mu.RLock()
-		m := metricsText
-		mu.RUnlock()
-		fmt.Fprintf(w, m)
+	handleFunc("/metrics_ovms", func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		for _, v := range vehicles {
+			b.WriteString(v.metricsText())
+		}
+		writeSamples(w, r, b.String())
 	})
+	handleFunc("/", handleIndex)
+	handleFunc("/healthz", handleHealthz)
+	handleFunc("/config", handleConfig)
+	handleFunc("/-/healthy", handleHealthz)
+	handleFunc("/-/ready", handleReady)
+	handleFunc("/-/reload", handleReload)
+	handleFunc("/-/quit", handleQuit)
+	handleFunc("/api/v1/vehicles/", routeVehicleAPI)
+	handleFunc("/api/v1/compare", authenticated(handleCompare))
+	handleFunc("/api/v1/history", authenticated(handleHistory))
+	handleFunc("/debug/raw", handleDebugRaw)
+	handleFunc("/debug/capture-fixture", handleCaptureFixture)
+	handleFunc("/events", authenticated(handleEvents))
+	handleFunc("/ui/", authenticated(uiHandler().ServeHTTP))
+	handleFunc("/ui/state.json", authenticated(handleUIState))
+	handleFunc("/report/fleet", authenticated(handleFleetReport))
+	handleFunc("/report/mileage", authenticated(handleMileage))
+	handleFunc("/report/export", authenticated(handleExport))
+	handleFunc("/track.gpx", authenticated(handleTrack))
+	handleFunc("/track.kml", authenticated(handleTrack))
 
-	http.Handle("/metrics", promhttp.Handler())
-	vlog.Fatal(http.ListenAndServe(*addrFlag, nil))
+	handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(exportGatherer{prometheus.DefaultGatherer}, promhttp.HandlerOpts{EnableOpenMetrics: true})))
+	if err := setupACME(); err != nil {
+		fatal("invalid ACME settings", err)
+	}
+	servePublic()
+	var lns []net.Listener
+	for _, addr := range addrFlag.addrs {
+		ln, err := listen(addr)
+		if err != nil {
+			fatal("error listening", err)
+		}
+		slog.Info("listening", "addr", addr)
+		lns = append(lns, ln)
+	}
+	srv := &http.Server{Handler: middleware(http.DefaultServeMux)}
+	done := make(chan struct{})
+	go func() {
+		<-quit
+		slog.Info("shutting down")
+		sdNotify("STOPPING=1")
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		close(done)
+	}()
+	signalReady()
+	// The main process changes on an upgrade, which needs NotifyAccess=all.
+	sdNotify(fmt.Sprintf("READY=1\nMAINPID=%d", os.Getpid()))
+	go runWatchdog()
+	go handleUpgrades()
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		go func(ln net.Listener) { errs <- serve(srv, ln) }(ln)
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		fatal("http server failed", err)
+	}
+	<-done
 }
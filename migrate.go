@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const migrateUsage = `usage: ovms_exporter [flags] migrate-dashboards [-n] [old=new ...] <file>...
+
+Rewrites the metric names in Grafana dashboard JSON and Prometheus rule files
+in place. The names are mapped with the old=new arguments, the renames of
+-config and -metric-prefix.`
+
+// identifierRE matches the identifiers that may be metric names.
+var identifierRE = regexp.MustCompile(`[a-zA-Z_:][a-zA-Z0-9_:]*`)
+
+// migrator maps the old metric names to the new ones.
+type migrator map[string]string
+
+// name returns the new name of an identifier. The suffixes of the histogram
+// and summary series are kept.
+func (m migrator) name(id string) string {
+	if to, ok := m[id]; ok {
+		return to
+	}
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		if base, ok := strings.CutSuffix(id, suffix); ok {
+			if to, ok := m[base]; ok {
+				return to + suffix
+			}
+		}
+	}
+	// The job name is usually ovms_exporter, it is not a metric.
+	if strings.HasPrefix(id, "ovms_") && id != "ovms_exporter" {
+		return metricName(id)
+	}
+	return id
+}
+
+// rewrite returns the content with the names replaced and the number of
+// replacements.
+func (m migrator) rewrite(content string) (string, int) {
+	n := 0
+	out := identifierRE.ReplaceAllStringFunc(content, func(id string) string {
+		to := m.name(id)
+		if to != id {
+			n++
+		}
+		return to
+	})
+	return out, n
+}
+
+// migrateCommand returns the flags of the migrate-dashboards command and its
+// function.
+func migrateCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("migrate-dashboards", flag.ContinueOnError)
+	dryRun := fs.Bool("n", false, "Only report the replacements, do not write the files")
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), migrateUsage) }
+	return fs, func(args []string) error {
+		m := migrator{}
+		for _, r := range cfg().Renames {
+			m[r.From] = r.To
+		}
+		var files []string
+		for _, arg := range args {
+			if from, to, ok := strings.Cut(arg, "="); ok {
+				m[from] = to
+				continue
+			}
+			files = append(files, arg)
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("%s", migrateUsage)
+		}
+
+		for _, name := range files {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			out, n := m.rewrite(string(data))
+			fmt.Printf("%s: %d replacements\n", name, n)
+			if n == 0 || *dryRun {
+				continue
+			}
+			if err := replaceFile(name, []byte(out)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// replaceFile atomically replaces the content of a file, keeping its mode.
+func replaceFile(name string, data []byte) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(fi.Mode()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// mileageLogFile is the log file name pattern, %s being the vehicle ID.
+const mileageLogFile = "mileage_%s.jsonl"
+
+// mileageEntry is a trip in the mileage log. Each entry includes the hash of
+// the previous one so that edits or removals break the chain.
+type mileageEntry struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	StartLat        float64   `json:"start_lat"`
+	StartLon        float64   `json:"start_lon"`
+	EndLat          float64   `json:"end_lat"`
+	EndLon          float64   `json:"end_lon"`
+	DistanceKm      float64   `json:"distance_km"`
+	StartOdometerKm float64   `json:"start_odometer_km"`
+	EndOdometerKm   float64   `json:"end_odometer_km"`
+	// The energy and SOC are omitted when zero, which keeps the hashes of
+	// the entries logged before they were recorded.
+	EnergyKWh float64 `json:"energy_kwh,omitempty"`
+	StartSOC  float64 `json:"start_soc,omitempty"`
+	EndSOC    float64 `json:"end_soc,omitempty"`
+	PrevHash  string  `json:"prev_hash"`
+	Hash      string  `json:"hash"`
+}
+
+// computeHash returns the hash of the entry, excluding its Hash field.
+func (e mileageEntry) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// mileageLog is the append-only, hash-chained log of the trips of a vehicle.
+// It is kept in -state-dir when set.
+type mileageLog struct {
+	vehicle string
+
+	mu      sync.Mutex
+	entries []mileageEntry
+}
+
+func (l *mileageLog) path() string {
+	if *stateDirFlag == "" {
+		return ""
+	}
+	return filepath.Join(*stateDirFlag, fmt.Sprintf(mileageLogFile, url.PathEscape(l.vehicle)))
+}
+
+func (l *mileageLog) init() error {
+	path := l.path()
+	if path == "" {
+		return nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var e mileageEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		l.entries = append(l.entries, e)
+	}
+	return s.Err()
+}
+
+// add appends a completed trip to the log.
+func (l *mileageLog) add(t trip) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e := mileageEntry{
+		Start:           t.Start,
+		End:             t.End,
+		StartLat:        t.StartLat,
+		StartLon:        t.StartLon,
+		EndLat:          t.EndLat,
+		EndLon:          t.EndLon,
+		DistanceKm:      t.DistanceKm(),
+		StartOdometerKm: t.StartOdometerKm,
+		EndOdometerKm:   t.EndOdometerKm,
+		EnergyKWh:       t.EnergyKWh,
+		StartSOC:        t.StartSOC,
+		EndSOC:          t.EndSOC,
+	}
+	if n := len(l.entries); n > 0 {
+		e.PrevHash = l.entries[n-1].Hash
+	}
+	e.Hash = e.computeHash()
+	l.entries = append(l.entries, e)
+
+	if err := l.write(e); err != nil {
+		slog.Error("error writing the mileage log", "vehicle", l.vehicle, "err", err)
+	}
+}
+
+func (l *mileageLog) write(e mileageEntry) error {
+	path := l.path()
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(*stateDirFlag, 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	data, _ := json.Marshal(e)
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if fsyncEnabled {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// snapshot returns the entries and the index of the first entry that fails
+// the hash chain verification, -1 if the chain is intact.
+func (l *mileageLog) snapshot() ([]mileageEntry, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := append([]mileageEntry(nil), l.entries...)
+	prev := ""
+	for i, e := range entries {
+		if e.PrevHash != prev || e.computeHash() != e.Hash {
+			return entries, i
+		}
+		prev = e.Hash
+	}
+	return entries, -1
+}
+
+// formatLocation formats the coordinates, rounded to about 1km if coarse.
+func formatLocation(lat, lon float64, coarse bool) string {
+	prec := 5
+	if coarse {
+		prec = 2
+	}
+	return strconv.FormatFloat(lat, 'f', prec, 64) + " " + strconv.FormatFloat(lon, 'f', prec, 64)
+}
+
+// handleMileage serves the mileage log of a vehicle as CSV or PDF:
+// /report/mileage?vehicle=<id>&format=csv|pdf&coarse=true
+func handleMileage(w http.ResponseWriter, r *http.Request) {
+	v := findVehicle(r.FormValue("vehicle"))
+	if v == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+	coarse, _ := strconv.ParseBool(r.FormValue("coarse"))
+	entries, broken := v.mileage.snapshot()
+
+	header := []string{"start", "end", "start_location", "end_location", "distance_km", "start_odometer_km", "end_odometer_km", "hash"}
+	var rows [][]string
+	for _, e := range entries {
+		rows = append(rows, []string{
+			e.Start.Format(time.RFC3339),
+			e.End.Format(time.RFC3339),
+			formatLocation(e.StartLat, e.StartLon, coarse),
+			formatLocation(e.EndLat, e.EndLon, coarse),
+			strconv.FormatFloat(e.DistanceKm, 'f', 1, 64),
+			strconv.FormatFloat(e.StartOdometerKm, 'f', 1, 64),
+			strconv.FormatFloat(e.EndOdometerKm, 'f', 1, 64),
+			e.Hash,
+		})
+	}
+	verification := "hash chain verified"
+	if broken >= 0 {
+		verification = fmt.Sprintf("hash chain BROKEN at entry %d", broken+1)
+	}
+
+	switch format := r.FormValue("format"); format {
+	case "", "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "mileage_"+v.id+".csv"))
+		w.Header().Set("X-Mileage-Verification", verification)
+		cw := csv.NewWriter(w)
+		cw.Write(header)
+		cw.WriteAll(rows)
+	case "pdf":
+		var total float64
+		for _, e := range entries {
+			total += e.DistanceKm
+		}
+		lines := []string{
+			fmt.Sprintf("Mileage log for vehicle %s, generated %s", v.id, time.Now().Format(time.RFC3339)),
+			fmt.Sprintf("%d trips, %.1f km, %s", len(entries), total, verification),
+			"",
+			fmt.Sprintf("%-20s %-20s %-22s %-22s %9s %11s", "Start", "End", "From", "To", "km", "Odometer"),
+		}
+		for _, row := range rows {
+			lines = append(lines, fmt.Sprintf("%-20.20s %-20.20s %-22s %-22s %9s %11s", row[0], row[1], row[2], row[3], row[4], row[6]))
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "mileage_"+v.id+".pdf"))
+		if err := writeTextPDF(w, lines); err != nil {
+			slog.Error("error writing the mileage PDF", "vehicle", v.id, "err", err)
+		}
+	default:
+		http.Error(w, "unknown format "+format, http.StatusBadRequest)
+	}
+}
+
+// purge deletes the log, including the file in -state-dir.
+func (l *mileageLog) purge() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = nil
+	if path := l.path(); path != "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reference: https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/mqtt-v3.1.1.html
+
+var (
+	mqttURLFlag      = flag.String("mqtt-url", "", "MQTT broker URL, tcp:// or ssl://[user:password@]host[:port], to which the latest values of the vehicles are published, retained; empty disables it")
+	mqttTopicFlag    = flag.String("mqtt-topic", "ovms", "Prefix of the MQTT topics")
+	mqttLayoutFlag   = flag.String("mqtt-layout", "fields", "Layout of the MQTT topics: fields (<topic>/<vehicle>/<code>/<field>), evcc (<topic>/<vehicle>/soc, status, range, odometer and limitSoc, for an evcc custom vehicle) or teslamate (<topic>/cars/<vehicle>/battery_level etc., as published by TeslaMate, for the evcc and other TeslaMate integrations)")
+	mqttClientIDFlag = flag.String("mqtt-client-id", "ovms_exporter", "MQTT client identifier")
+)
+
+var mqttPublishFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ovms_mqtt_publish_failures_total",
+	Help: "Number of failed publishes to -mqtt-url.",
+})
+
+// mqttLayouts return the topics, relative to -mqtt-topic, and the values
+// published for a vehicle.
+var mqttLayouts = map[string]func(v *vehicle) map[string]string{
+	"fields":    mqttFields,
+	"evcc":      mqttEvcc,
+	"teslamate": mqttTeslaMate,
+}
+
+// mqttDefaultPorts are the ports of the brokers by scheme.
+var mqttDefaultPorts = map[string]string{"tcp": "1883", "mqtt": "1883", "ssl": "8883", "mqtts": "8883"}
+
+// mqttBroker is the parsed -mqtt-url, nil without it.
+var mqttBroker *url.URL
+
+func setupMQTT() error {
+	if *mqttURLFlag == "" {
+		return nil
+	}
+	u, err := url.Parse(*mqttURLFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -mqtt-url: %v", err)
+	}
+	port, ok := mqttDefaultPorts[u.Scheme]
+	if !ok || u.Hostname() == "" {
+		return fmt.Errorf("invalid -mqtt-url %q, expected tcp:// or ssl://host[:port]", u.Redacted())
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	if _, ok := mqttLayouts[*mqttLayoutFlag]; !ok {
+		return fmt.Errorf("invalid -mqtt-layout %q", *mqttLayoutFlag)
+	}
+	mqttBroker = u
+	return nil
+}
+
+// mqttFields publishes every field of the latest records.
+func mqttFields(v *vehicle) map[string]string {
+	values := map[string]string{}
+	for code, rec := range v.records.snapshot() {
+		for name, val := range rec.fields {
+			values[v.id+"/"+code+"/"+name] = val
+		}
+	}
+	return values
+}
+
+// evccStatus returns the charge status in the IEC 61851 letters used by
+// evcc: A not connected, B connected and C charging.
+func (v *vehicle) evccStatus() string {
+	d1, _ := strconv.Atoi(v.field("D", "doors1"))
+	switch {
+	case isCharging(v.field("S", "ms_v_charge_state")):
+		return "C"
+	case d1&doors1Pilot != 0:
+		return "B"
+	}
+	return "A"
+}
+
+// Reference: https://docs.evcc.io/docs/devices/vehicles#custom
+func mqttEvcc(v *vehicle) map[string]string {
+	values := map[string]string{v.id + "/status": v.evccStatus()}
+	mqttSetFloat(values, v.id+"/soc", v.floatField("S", "ms_v_bat_soc"))
+	mqttSetFloat(values, v.id+"/range", v.distanceKmField("ms_v_bat_range_est"))
+	mqttSetFloat(values, v.id+"/limitSoc", v.floatField("S", "ms_v_charge_limit_soc"))
+	if km := v.odometerKm(); km > 0 {
+		mqttSetFloat(values, v.id+"/odometer", &km)
+	}
+	return values
+}
+
+// Reference: https://docs.teslamate.org/docs/integrations/mqtt
+func mqttTeslaMate(v *vehicle) map[string]string {
+	prefix := "cars/" + v.id + "/"
+	status := v.evccStatus()
+	chargingState := "Disconnected"
+	switch status {
+	case "C":
+		chargingState = "Charging"
+	case "B":
+		chargingState = "Stopped"
+	}
+	values := map[string]string{
+		prefix + "plugged_in":     strconv.FormatBool(status != "A"),
+		prefix + "charging_state": chargingState,
+	}
+	soc := v.floatField("S", "ms_v_bat_soc")
+	mqttSetFloat(values, prefix+"battery_level", soc)
+	mqttSetFloat(values, prefix+"usable_battery_level", soc)
+	mqttSetFloat(values, prefix+"est_battery_range_km", v.distanceKmField("ms_v_bat_range_est"))
+	mqttSetFloat(values, prefix+"ideal_battery_range_km", v.distanceKmField("ms_v_bat_range_ideal"))
+	mqttSetFloat(values, prefix+"charge_limit_soc", v.floatField("S", "ms_v_charge_limit_soc"))
+	mqttSetFloat(values, prefix+"charger_power", v.floatField("S", "ms_v_charge_power"))
+	mqttSetFloat(values, prefix+"outside_temp", v.floatField("D", "ms_v_env_temp"))
+	mqttSetFloat(values, prefix+"inside_temp", v.floatField("D", "ms_v_env_cabintemp"))
+	mqttSetFloat(values, prefix+"latitude", v.floatField("L", "ms_v_pos_latitude"))
+	mqttSetFloat(values, prefix+"longitude", v.floatField("L", "ms_v_pos_longitude"))
+	mqttSetFloat(values, prefix+"power", v.floatField("L", "ms_v_bat_power"))
+	if km := v.odometerKm(); km > 0 {
+		mqttSetFloat(values, prefix+"odometer", &km)
+	}
+	if speed := v.floatField("L", "ms_v_pos_speed"); speed != nil {
+		if v.field("S", "m_units_distance") == "M" {
+			*speed *= kmPerMile
+		}
+		mqttSetFloat(values, prefix+"speed", speed)
+	}
+	return values
+}
+
+// mqttSetFloat sets a value, if known.
+func mqttSetFloat(values map[string]string, topic string, f *float64) {
+	if f != nil {
+		values[topic] = strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+}
+
+// mqttPublisher publishes the values of the vehicles with new records over a
+// connection kept open between the polls.
+type mqttPublisher struct {
+	mu   sync.Mutex
+	conn net.Conn
+	// sent is the time of the latest record published by vehicle.
+	sent map[string]time.Time
+}
+
+var mqtt mqttPublisher
+
+// publish publishes the values of the vehicles with records newer than the
+// ones already published. A broken connection is reopened once.
+func (p *mqttPublisher) publish() {
+	if mqttBroker == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sent == nil {
+		p.sent = map[string]time.Time{}
+	}
+	for _, v := range vehicles {
+		last := v.records.lastMessage()
+		if last.IsZero() || !last.After(p.sent[v.id]) {
+			continue
+		}
+		values := mqttLayouts[*mqttLayoutFlag](v)
+		reused := p.conn != nil
+		err := p.publishValues(values)
+		if err != nil && reused {
+			err = p.publishValues(values)
+		}
+		if err != nil {
+			mqttPublishFailures.Inc()
+			slog.Error("error publishing to MQTT", "url", mqttBroker.Redacted(), "vehicle", v.id, "err", err)
+			continue
+		}
+		p.sent[v.id] = last
+	}
+}
+
+// publishValues publishes retained values, in topic order, connecting first
+// if needed. On error the connection is closed.
+func (p *mqttPublisher) publishValues(values map[string]string) error {
+	if p.conn == nil {
+		conn, err := mqttConnect(mqttBroker)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+	topics := make([]string, 0, len(values))
+	for topic := range values {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	w := bufio.NewWriter(p.conn)
+	p.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	for _, topic := range topics {
+		var pkt []byte
+		pkt = mqttAppendString(pkt, strings.TrimSuffix(*mqttTopicFlag, "/")+"/"+topic)
+		pkt = append(pkt, values[topic]...)
+		// PUBLISH, QoS 0, retained.
+		w.Write(mqttPacket(0x31, pkt))
+	}
+	if err := w.Flush(); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+// mqttConnect opens an MQTT session with the broker. The keep alive is
+// disabled: the connection is idle between the polls.
+func mqttConnect(u *url.URL) (net.Conn, error) {
+	conn, err := dialOutbound(u.Host, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "ssl" || u.Scheme == "mqtts" {
+		conn = tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	var pkt []byte
+	pkt = mqttAppendString(pkt, "MQTT")
+	// Protocol level 4 (3.1.1), clean session.
+	flags := byte(0x02)
+	if u.User != nil {
+		flags |= 0x80
+		if _, ok := u.User.Password(); ok {
+			flags |= 0x40
+		}
+	}
+	pkt = append(pkt, 4, flags, 0, 0)
+	pkt = mqttAppendString(pkt, *mqttClientIDFlag)
+	if u.User != nil {
+		pkt = mqttAppendString(pkt, u.User.Username())
+		if password, ok := u.User.Password(); ok {
+			pkt = mqttAppendString(pkt, password)
+		}
+	}
+	if _, err := conn.Write(mqttPacket(0x10, pkt)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	ack := make([]byte, 4)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("reading CONNACK: %v", err)
+	}
+	if ack[0] != 0x20 || ack[1] != 2 {
+		conn.Close()
+		return nil, errors.New("invalid CONNACK")
+	}
+	if ack[3] != 0 {
+		conn.Close()
+		return nil, fmt.Errorf("connection refused, return code %d", ack[3])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// mqttPacket returns a control packet of a type and flags and its variable
+// header and payload.
+func mqttPacket(header byte, body []byte) []byte {
+	pkt := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 0x80
+		}
+		pkt = append(pkt, b)
+		if n == 0 {
+			break
+		}
+	}
+	return append(pkt, body...)
+}
+
+// mqttAppendString appends a length-prefixed UTF-8 string.
+func mqttAppendString(b []byte, s string) []byte {
+	return append(append(b, byte(len(s)>>8), byte(len(s))), s...)
+}
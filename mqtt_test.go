@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestMQTTPacket(t *testing.T) {
+	for _, tc := range []struct {
+		n      int
+		length []byte
+	}{
+		{0, []byte{0x00}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{16383, []byte{0xff, 0x7f}},
+		{16384, []byte{0x80, 0x80, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2097152, []byte{0x80, 0x80, 0x80, 0x01}},
+	} {
+		body := bytes.Repeat([]byte{'x'}, tc.n)
+		pkt := mqttPacket(0x31, body)
+		want := append(append([]byte{0x31}, tc.length...), body...)
+		if !bytes.Equal(pkt, want) {
+			t.Errorf("mqttPacket with %d bytes: header % x, want % x", tc.n, pkt[:1+len(tc.length)], want[:1+len(tc.length)])
+		}
+	}
+}
+
+func TestMQTTAppendString(t *testing.T) {
+	if got, want := mqttAppendString([]byte{1}, "MQTT"), []byte{1, 0, 4, 'M', 'Q', 'T', 'T'}; !bytes.Equal(got, want) {
+		t.Errorf("mqttAppendString = % x, want % x", got, want)
+	}
+	s := string(bytes.Repeat([]byte{'a'}, 300))
+	if got := mqttAppendString(nil, s); got[0] != 1 || got[1] != 44 || len(got) != 302 {
+		t.Errorf("mqttAppendString of 300 bytes starts with % x", got[:2])
+	}
+}
+
+// readMQTTPacket reads a control packet, returning its header and body.
+func readMQTTPacket(r io.Reader) (byte, []byte, error) {
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, err
+	}
+	header := b[0]
+	n, mul := 0, 1
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return 0, nil, err
+		}
+		n += int(b[0]&0x7f) * mul
+		mul *= 128
+		if b[0]&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, n)
+	_, err := io.ReadFull(r, body)
+	return header, body, err
+}
+
+func TestMQTTConnectAndPublish(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	type packet struct {
+		header byte
+		body   []byte
+	}
+	packets := make(chan packet, 10)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for i := 0; i < 3; i++ {
+			header, body, err := readMQTTPacket(conn)
+			if err != nil {
+				close(packets)
+				return
+			}
+			packets <- packet{header, body}
+			if i == 0 {
+				conn.Write([]byte{0x20, 2, 0, 0})
+			}
+		}
+	}()
+
+	u, _ := url.Parse("tcp://user:secret@" + l.Addr().String())
+	mqttBroker = u
+	defer func() { mqttBroker = nil }()
+	var p mqttPublisher
+	if err := p.publishValues(map[string]string{"A/S/soc": "80", "A/D/cabin_temp": "21.5"}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.conn.Close()
+
+	var want []byte
+	want = mqttAppendString(want, "MQTT")
+	want = append(want, 4, 0xc2, 0, 0)
+	want = mqttAppendString(want, *mqttClientIDFlag)
+	want = mqttAppendString(want, "user")
+	want = mqttAppendString(want, "secret")
+	if got := <-packets; got.header != 0x10 || !bytes.Equal(got.body, want) {
+		t.Errorf("CONNECT = %02x % x, want 10 % x", got.header, got.body, want)
+	}
+	for _, topic := range []string{"A/D/cabin_temp", "A/S/soc"} {
+		value := map[string]string{"A/S/soc": "80", "A/D/cabin_temp": "21.5"}[topic]
+		want := append(mqttAppendString(nil, *mqttTopicFlag+"/"+topic), value...)
+		if got := <-packets; got.header != 0x31 || !bytes.Equal(got.body, want) {
+			t.Errorf("PUBLISH = %02x %q, want 31 %q", got.header, got.body, want)
+		}
+	}
+}
+
+func TestMQTTConnectRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		readMQTTPacket(conn)
+		// Bad user name or password.
+		conn.Write([]byte{0x20, 2, 0, 4})
+	}()
+	u, _ := url.Parse("tcp://" + l.Addr().String())
+	if conn, err := mqttConnect(u); err == nil {
+		conn.Close()
+		t.Error("mqttConnect succeeded on a refused connection")
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	serverTimezoneFlag = flag.String("server-timezone", "UTC", "Time zone of the m_msgtime of the OVMS server records, e.g. Europe/Berlin or Local; some self-hosted servers use their local time")
+	maxClockSkewFlag   = flag.Duration("max-clock-skew", time.Minute, "How far ahead of the local clock the newest record time can be before a warning is logged")
+)
+
+var clockSkewGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_server_clock_skew_seconds",
+	Help: "Time of the newest record of the last poll minus the local time; positive when the server clock is ahead, negative also when the vehicle did not report recently.",
+}, []string{"vehicle"})
+
+// serverLocation is the location of -server-timezone.
+var serverLocation = time.UTC
+
+// msgTimeLayout is the format of m_msgtime.
+const msgTimeLayout = "2006-01-02 15:04:05"
+
+const (
+	// msgTimeMaxFuture is how far in the future a record time can be, for
+	// the clock differences, before it is reported.
+	msgTimeMaxFuture = 10 * time.Minute
+	// msgTimeMaxAge is how far in the past a record time can be before it
+	// is reported.
+	msgTimeMaxAge = 5 * 365 * 24 * time.Hour
+)
+
+func setupServerTimezone() error {
+	loc, err := time.LoadLocation(*serverTimezoneFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -server-timezone: %v", err)
+	}
+	serverLocation = loc
+	return nil
+}
+
+// parseMsgTime parses the m_msgtime of a record.
+func parseMsgTime(s string) (time.Time, error) {
+	ts, err := time.ParseInLocation(msgTimeLayout, s, serverLocation)
+	return ts.UTC(), err
+}
+
+// checkMsgTime warns about the record times far in the future or in the
+// past, which usually come from a wrong -server-timezone or a wrong clock of
+// the server. It warns once per code until its times are plausible again.
+func (v *vehicle) checkMsgTime(code string, ts, now time.Time) {
+	var problem string
+	switch {
+	case ts.Sub(now) > msgTimeMaxFuture:
+		problem = "record time in the future, check -server-timezone"
+	case now.Sub(ts) > msgTimeMaxAge:
+		problem = "record time far in the past, check the clock of the server"
+	}
+	if problem != "" && !v.msgTimeWarned[code] {
+		slog.Warn(problem, "vehicle", v.id, "code", code, "ts", ts, "offset", ts.Sub(now).Round(time.Minute))
+	}
+	if v.msgTimeWarned == nil {
+		v.msgTimeWarned = map[string]bool{}
+	}
+	v.msgTimeWarned[code] = problem != ""
+}
+
+// updateClockSkew exports the skew between the newest record time of a poll
+// and the local time. Only a server ahead is reported: the records of a
+// vehicle that did not report recently are legitimately behind. The timestamps
+// of the samples are the record times, so a skewed server clock shifts them.
+func (v *vehicle) updateClockSkew(newest, now time.Time) {
+	skew := newest.Sub(now)
+	clockSkewGauge.WithLabelValues(v.id).Set(skew.Seconds())
+	ahead := skew > *maxClockSkewFlag
+	if ahead && !v.clockSkewWarned {
+		slog.Warn("the server clock is ahead, the samples are timestamped in the future", "vehicle", v.id, "skew", skew.Round(time.Second), "max", *maxClockSkewFlag)
+	}
+	v.clockSkewWarned = ahead
+}
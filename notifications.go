@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// notificationCode is the code of the push notifications of the module:
+// the type letter followed by the text, e.g. "AAlarm triggered".
+// Reference: https://docs.openvehicles.com/en/latest/protocol_v2/messages.html#push-notification-0x50-p
+const notificationCode = "P"
+
+// notificationTypes name the types of the push notifications.
+var notificationTypes = map[string]string{
+	"A": "alert",
+	"I": "info",
+	"E": "error",
+}
+
+// Events of the push notifications, by type.
+const (
+	eventNotificationAlert = "notification_alert"
+	eventNotificationInfo  = "notification_info"
+	eventNotificationError = "notification_error"
+)
+
+var notificationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_notifications_total",
+	Help: "Number of push notifications sent by the module, by type.",
+}, []string{"vehicle", "type"})
+
+// notificationTracker counts the push notifications. The polls return the
+// latest notification again and again, so only the newer ones count.
+type notificationTracker struct {
+	vehicle string
+
+	mu sync.Mutex
+	// last is the time of the latest notification counted, initially the
+	// start of the exporter: the earlier ones are not counted.
+	last time.Time
+}
+
+// update processes a notification, reporting whether it is new.
+func (n *notificationTracker) update(ts time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !ts.After(n.last) {
+		return false
+	}
+	n.last = ts
+	return true
+}
+
+// notification processes a push notification record.
+func (v *vehicle) notification(msg string, ts time.Time) {
+	if msg == "" || !v.notifications.update(ts) {
+		return
+	}
+	typ, ok := notificationTypes[msg[:1]]
+	if !ok {
+		typ = "other"
+	}
+	text := strings.TrimSpace(msg[1:])
+	if typ == "error" {
+		// E<code>,<data>
+		text = "error " + strings.Replace(text, ",", " ", 1)
+	}
+	notificationsTotal.WithLabelValues(v.id, typ).Inc()
+	slog.Info("notification", "vehicle", v.id, "type", typ, "text", text)
+	v.notify("notification_"+typ, text)
+}
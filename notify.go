@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var notificationsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_notifier_sends_total",
+	Help: "Number of messages sent by the notifiers, by result.",
+}, []string{"notifier", "result"})
+
+// eventMessages describe the events in the default notification message.
+var eventMessages = map[string]string{
+	eventChargeStart:            "charge started",
+	eventTripStart:              "trip started",
+	eventChargePortWithoutCable: "charge port open without a cable",
+	eventOpenWhileMoving:        "door open while moving",
+	eventNotificationAlert:      "alert",
+	eventNotificationInfo:       "info",
+	eventNotificationError:      "error",
+	event12VAlert:               "12V battery needs attention",
+}
+
+// defaultNotifyTemplate is the message of the notifiers without a template.
+const defaultNotifyTemplate = `{{.Vehicle}}: {{.Message}}{{with .Field "S" "ms_v_bat_soc"}} (SOC {{.}}%){{end}}`
+
+// notifier sends a message on some of the events, e.g.
+//
+//	{"name": "phone", "type": "telegram", "bot_token": "...", "chat_id": "...",
+//	 "events": ["charge_port_without_cable", "open_while_moving"]}
+//
+// The message is the text/template template, with the fields of
+// notifyData, e.g. `{{.Vehicle}} {{.Message}} at {{.Field "L"
+// "ms_v_pos_latitude"}}`.
+type notifier struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Vehicle  string   `json:"vehicle"`
+	Events   []string `json:"events"`
+	Template string   `json:"template"`
+	// URL replaces the API URL of the telegram and pushover types, e.g. of
+	// a local Telegram Bot API server.
+	URL string `json:"url"`
+
+	// telegram: https://core.telegram.org/bots/api#sendmessage
+	BotToken string `json:"bot_token"`
+	ChatID   string `json:"chat_id"`
+
+	// pushover: https://pushover.net/api
+	AppToken string `json:"app_token"`
+	UserKey  string `json:"user_key"`
+
+	// smtp
+	SMTPAddr string   `json:"smtp_addr"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+
+	tmpl *template.Template
+}
+
+// notifierTypes are the required fields of the notifier types.
+var notifierTypes = map[string]func(n *notifier) []string{
+	"telegram": func(n *notifier) []string { return []string{n.BotToken, n.ChatID} },
+	"pushover": func(n *notifier) []string { return []string{n.AppToken, n.UserKey} },
+	"smtp":     func(n *notifier) []string { return []string{n.SMTPAddr, n.From, strings.Join(n.To, ",")} },
+}
+
+func (n *notifier) validate() error {
+	if n.Name == "" {
+		return fmt.Errorf("notifier without a name")
+	}
+	required, ok := notifierTypes[n.Type]
+	if !ok {
+		return fmt.Errorf("notifier %q: unknown type %q, expected telegram, pushover or smtp", n.Name, n.Type)
+	}
+	for _, f := range required(n) {
+		if f == "" {
+			return fmt.Errorf("notifier %q: missing settings of type %s", n.Name, n.Type)
+		}
+	}
+	if len(n.Events) == 0 {
+		return fmt.Errorf("notifier %q: no events", n.Name)
+	}
+	for _, e := range n.Events {
+		if _, ok := eventMessages[e]; !ok {
+			return fmt.Errorf("notifier %q: unknown event %q", n.Name, e)
+		}
+	}
+	text := n.Template
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	var err error
+	if n.tmpl, err = template.New(n.Name).Parse(text); err != nil {
+		return fmt.Errorf("notifier %q: invalid template: %v", n.Name, err)
+	}
+	return nil
+}
+
+// notifyData is the data of the notification templates.
+type notifyData struct {
+	Vehicle string
+	Event   string
+	Message string
+	Time    time.Time
+
+	v *vehicle
+}
+
+// Field returns a field of the latest record of a code, empty if unknown.
+func (d notifyData) Field(code, name string) string {
+	return d.v.field(code, name)
+}
+
+// notify sends the message of an event to the notifiers of the event, in
+// the background, none while importing.
+func (v *vehicle) notify(event, message string) {
+	if v.importing {
+		return
+	}
+	for _, n := range cfg().Notifiers {
+		if !contains(n.Events, event) || (n.Vehicle != "" && n.Vehicle != v.id) {
+			continue
+		}
+		var b strings.Builder
+		if err := n.tmpl.Execute(&b, notifyData{v.id, event, message, time.Now(), v}); err != nil {
+			notificationsSent.WithLabelValues(n.Name, "error").Inc()
+			slog.Error("error formatting the notification", "notifier", n.Name, "vehicle", v.id, "event", event, "err", err)
+			continue
+		}
+		go func(n *notifier, text string) {
+			if err := n.send(text); err != nil {
+				notificationsSent.WithLabelValues(n.Name, "error").Inc()
+				slog.Error("error sending the notification", "notifier", n.Name, "vehicle", v.id, "event", event, "err", err)
+				return
+			}
+			notificationsSent.WithLabelValues(n.Name, "ok").Inc()
+		}(n, b.String())
+	}
+}
+
+// event handles an event detected by the trackers, ignored while importing.
+func (v *vehicle) event(event string) {
+	if v.importing {
+		return
+	}
+	v.burst.trigger(event)
+	v.notify(event, eventMessages[event])
+}
+
+// send sends a message.
+func (n *notifier) send(text string) error {
+	switch n.Type {
+	case "telegram":
+		u := n.URL
+		if u == "" {
+			u = "https://api.telegram.org"
+		}
+		return postForm(strings.TrimSuffix(u, "/")+"/bot"+n.BotToken+"/sendMessage", url.Values{"chat_id": {n.ChatID}, "text": {text}})
+	case "pushover":
+		u := n.URL
+		if u == "" {
+			u = "https://api.pushover.net/1/messages.json"
+		}
+		return postForm(u, url.Values{"token": {n.AppToken}, "user": {n.UserKey}, "message": {text}})
+	case "smtp":
+		host, _, _ := strings.Cut(n.SMTPAddr, ":")
+		var auth smtp.Auth
+		if n.Username != "" {
+			auth = smtp.PlainAuth("", n.Username, n.Password, host)
+		}
+		return sendMail(n.SMTPAddr, host, auth, n.From, n.To, mailMessage(n.From, n.To, text, time.Now()))
+	}
+	return fmt.Errorf("unknown notifier type %q", n.Type)
+}
+
+// mailMessage returns the mail of a message, its subject being the first
+// line, cut at any line break so that it cannot add headers, and encoded if
+// not plain ASCII.
+func mailMessage(from string, to []string, text string, now time.Time) []byte {
+	subject := text
+	if i := strings.IndexAny(subject, "\r\n"); i >= 0 {
+		subject = subject[:i]
+	}
+	return []byte(fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		from, strings.Join(to, ", "), mime.QEncoding.Encode("utf-8", subject), now.Format(time.RFC1123Z), strings.ReplaceAll(text, "\n", "\r\n")))
+}
+
+// sendMail sends a mail as smtp.SendMail, the connection going through the
+// proxy if any, see dialOutbound.
+func sendMail(addr, host string, auth smtp.Auth, from string, to []string, msg []byte) error {
+	conn, err := dialOutbound(addr, 30*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return fmt.Errorf("smtp: server doesn't support AUTH")
+		}
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// postForm posts a form and checks the response status. The URL is not
+// part of the errors, it may carry a token.
+func postForm(u string, form url.Values) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(u, form)
+	if err != nil {
+		return withoutURL(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
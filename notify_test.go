@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"mime"
+	"net/mail"
+	"testing"
+	"time"
+)
+
+func TestMailMessage(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		text, subject string
+	}{
+		{"Charging stopped\nSOC 80%", "Charging stopped"},
+		{"Charging stopped\r\nSOC 80%", "Charging stopped"},
+		{"Charge done\rBcc: victim@example.com\nbody", "Charge done"},
+		{"Température 5 °C", "Température 5 °C"},
+	} {
+		msg := mailMessage("ovms@example.com", []string{"a@example.com", "b@example.com"}, tc.text, now)
+		m, err := mail.ReadMessage(bufio.NewReader(bytes.NewReader(msg)))
+		if err != nil {
+			t.Fatalf("mailMessage(%q): %v", tc.text, err)
+		}
+		if got := len(m.Header); got != 5 {
+			t.Errorf("mailMessage(%q) has %d headers, want 5: %v", tc.text, got, m.Header)
+		}
+		subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if subject != tc.subject {
+			t.Errorf("mailMessage(%q) subject %q, want %q", tc.text, subject, tc.subject)
+		}
+		if got := m.Header.Get("To"); got != "a@example.com, b@example.com" {
+			t.Errorf("mailMessage(%q) To %q", tc.text, got)
+		}
+	}
+}
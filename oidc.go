@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	oidcIssuerFlag   = flag.String("oidc-issuer", "", "OIDC issuer URL whose tokens are required by the UI and the APIs; empty disables OIDC")
+	oidcAudienceFlag = flag.String("oidc-audience", "", "Audience (client ID) the OIDC tokens must be issued for; required with -oidc-issuer")
+)
+
+// Settings of the OIDC token validation.
+const (
+	oidcLeeway         = time.Minute
+	oidcKeysMaxAge     = time.Hour
+	oidcKeysMinRefresh = time.Minute
+)
+
+// oidcVerifier validates the JWTs signed by the issuer, with the keys from its
+// discovery document.
+type oidcVerifier struct {
+	issuer   string
+	audience string
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// refreshing is whether the old keys are being refreshed in the
+	// background.
+	refreshing bool
+
+	// refreshMu serializes the fetches of the keys, made without holding
+	// mu so that a slow issuer only delays the tokens of unknown keys.
+	refreshMu sync.Mutex
+}
+
+var oidc *oidcVerifier
+
+func setupOIDC() error {
+	if *oidcIssuerFlag == "" {
+		return nil
+	}
+	// Without an audience, the tokens the issuer minted for any client would
+	// be accepted.
+	if *oidcAudienceFlag == "" {
+		return fmt.Errorf("-oidc-issuer needs -oidc-audience")
+	}
+	oidc = &oidcVerifier{
+		issuer:   strings.TrimSuffix(*oidcIssuerFlag, "/"),
+		audience: *oidcAudienceFlag,
+	}
+	return nil
+}
+
+func getJSON(url string, v interface{}) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	b64 := base64.RawURLEncoding
+	switch k.Kty {
+	case "RSA":
+		n, err1 := b64.DecodeString(k.N)
+		e, err2 := b64.DecodeString(k.E)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid RSA key %q", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err1 := b64.DecodeString(k.X)
+		y, err2 := b64.DecodeString(k.Y)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid EC key %q", k.Kid)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// fetchKeys fetches the keys of the issuer.
+func (o *oidcVerifier) fetchKeys() (map[string]crypto.PublicKey, error) {
+	var discovery struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := getJSON(o.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("discovery document of %q is for %q", o.issuer, discovery.Issuer)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(discovery.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if pk, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pk
+		}
+	}
+	return keys, nil
+}
+
+// refreshKeys fetches the keys of the issuer unless they were fetched after
+// since, e.g. by a concurrent refresh.
+func (o *oidcVerifier) refreshKeys(since time.Time) error {
+	o.refreshMu.Lock()
+	defer o.refreshMu.Unlock()
+	o.mu.Lock()
+	fetched := o.fetched
+	o.mu.Unlock()
+	if fetched.After(since) {
+		return nil
+	}
+	keys, err := o.fetchKeys()
+	if err != nil {
+		return fmt.Errorf("error fetching the keys of %s: %v", o.issuer, err)
+	}
+	o.mu.Lock()
+	o.keys, o.fetched = keys, time.Now()
+	o.mu.Unlock()
+	return nil
+}
+
+// key returns the key with the given ID, refreshing the keys when the ID is
+// unknown. The old keys are refreshed in the background, still being used
+// meanwhile.
+func (o *oidcVerifier) key(kid string) (crypto.PublicKey, error) {
+	o.mu.Lock()
+	k, ok := o.keys[kid]
+	fetched := o.fetched
+	stale := time.Since(fetched) > oidcKeysMaxAge && !o.refreshing
+	if ok && stale {
+		o.refreshing = true
+	}
+	o.mu.Unlock()
+	switch {
+	case ok && stale:
+		go func() {
+			if err := o.refreshKeys(fetched); err != nil {
+				slog.Error("error refreshing the OIDC keys", "err", err)
+			}
+			o.mu.Lock()
+			o.refreshing = false
+			o.mu.Unlock()
+		}()
+	case !ok && time.Since(fetched) > oidcKeysMinRefresh:
+		if err := o.refreshKeys(fetched); err != nil {
+			return nil, err
+		}
+		o.mu.Lock()
+		k, ok = o.keys[kid]
+		o.mu.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return k, nil
+}
+
+// audience is the aud claim, a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// verify checks the signature and the claims of a JWT and returns its
+// subject.
+func (o *oidcVerifier) verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed signature")
+	}
+	key, err := o.key(header.Kid)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if header.Alg != "RS256" || rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) != nil {
+			return "", errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Alg != "ES256" || len(sig) != 64 ||
+			!ecdsa.Verify(k, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return "", errors.New("invalid signature")
+		}
+	default:
+		return "", errors.New("unsupported key")
+	}
+
+	var claims struct {
+		Issuer    string   `json:"iss"`
+		Subject   string   `json:"sub"`
+		Audience  audience `json:"aud"`
+		Expiry    int64    `json:"exp"`
+		NotBefore int64    `json:"nbf"`
+	}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != o.issuer:
+		return "", fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	case o.audience != "" && !contains(claims.Audience, o.audience):
+		return "", fmt.Errorf("unexpected audience %q", claims.Audience)
+	case claims.Expiry == 0 || now.Add(-oidcLeeway).After(time.Unix(claims.Expiry, 0)):
+		return "", errors.New("token expired")
+	case claims.NotBefore != 0 && now.Add(oidcLeeway).Before(time.Unix(claims.NotBefore, 0)):
+		return "", errors.New("token not valid yet")
+	}
+	return claims.Subject, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return errors.New("malformed token")
+	}
+	return json.Unmarshal(data, v)
+}
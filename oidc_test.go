@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testIssuer is an OIDC issuer serving the discovery document and the keys,
+// signing the tokens of the tests.
+type testIssuer struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := base64.RawURLEncoding
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": iss.URL, "jwks_uri": iss.URL + "/keys"})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]jwk{"keys": {
+			{Kid: "rsa", Kty: "RSA", N: b64.EncodeToString(rsaKey.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{Kid: "ec", Kty: "EC", Crv: "P-256", X: b64.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))), Y: b64.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32)))},
+		}})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// token returns a JWT of the claims signed with the key kid, "rsa" or "ec",
+// under the algorithm alg.
+func (iss *testIssuer) token(t *testing.T, kid, alg string, claims map[string]interface{}) string {
+	b64 := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch kid {
+	case "ec":
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+// tamper replaces the claims of a token, keeping its signature.
+func tamper(token string, claims map[string]interface{}) string {
+	parts := strings.Split(token, ".")
+	payload, _ := json.Marshal(claims)
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	return strings.Join(parts, ".")
+}
+
+func TestOIDCVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	o := &oidcVerifier{issuer: iss.URL, audience: "exporter"}
+	now := time.Now().Unix()
+	claims := func(changes map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{"iss": iss.URL, "sub": "alice", "aud": "exporter", "exp": now + 300}
+		for k, v := range changes {
+			if v == nil {
+				delete(c, k)
+			} else {
+				c[k] = v
+			}
+		}
+		return c
+	}
+	for _, tt := range []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"rsa", iss.token(t, "rsa", "RS256", claims(nil)), ""},
+		{"ec", iss.token(t, "ec", "ES256", claims(nil)), ""},
+		{"audience array", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"aud": []string{"other", "exporter"}})), ""},
+		{"issuer with slash", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"iss": iss.URL + "/"})), ""},
+		{"expired within leeway", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"exp": now - 30})), ""},
+		{"other audience", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"aud": "other"})), "unexpected audience"},
+		{"no audience", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"aud": nil})), "unexpected audience"},
+		{"other issuer", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"iss": "https://evil.example"})), "unexpected issuer"},
+		{"expired", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"exp": now - 3600})), "token expired"},
+		{"no expiry", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"exp": nil})), "token expired"},
+		{"not valid yet", iss.token(t, "rsa", "RS256", claims(map[string]interface{}{"nbf": now + 3600})), "not valid yet"},
+		{"algorithm mismatch", iss.token(t, "rsa", "ES256", claims(nil)), "invalid signature"},
+		{"unknown key", iss.token(t, "other", "RS256", claims(nil)), "unknown key"},
+		{"tampered", tamper(iss.token(t, "rsa", "RS256", claims(nil)), claims(map[string]interface{}{"sub": "mallory"})), "invalid signature"},
+		{"malformed", "a.b", "malformed token"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sub, err := o.verify(tt.token)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("verify: %v", err)
+			case tt.wantErr == "" && sub != "alice":
+				t.Errorf("verify = %q, want alice", sub)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("verify error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
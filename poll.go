@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	pollConcurrencyFlag = flag.Int("poll-concurrency", 4, "Number of vehicles polled at the same time, so that a slow vehicle does not delay the others")
+	pollTimeoutFlag     = flag.Duration("poll-timeout", 30*time.Second, "Timeout of each request to the OVMS server, including reading the response; 0 disables it")
+)
+
+// pollSlots bounds the number of vehicles polled at the same time. It is
+// taken in the goroutine of each vehicle rather than with an errgroup.Group
+// and SetLimit, whose Go blocks the caller while the slots are busy: a slow
+// vehicle would then keep pollVehicles from returning after the poll
+// interval. The polls return no errors for a group to collect either.
+var pollSlots chan struct{}
+
+func setupPoll() error {
+	if *pollConcurrencyFlag < 1 {
+		return fmt.Errorf("-poll-concurrency must be at least 1")
+	}
+	if *pollTimeoutFlag < 0 {
+		return fmt.Errorf("-poll-timeout must not be negative")
+	}
+	pollSlots = make(chan struct{}, *pollConcurrencyFlag)
+	return nil
+}
+
+// pollVehicles polls the vehicles that are due, at most -poll-concurrency at
+// a time. It returns once they are all done, or after the poll interval, the
+// vehicles still being polled or waiting for a slot then being skipped by
+// the next polls until they are done.
+func pollVehicles() {
+	var wg sync.WaitGroup
+	for _, v := range vehicles {
+		pollHeartbeat()
+		if !v.pollDue(time.Now()) {
+			continue
+		}
+		wg.Add(1)
+		go func(v *vehicle) {
+			defer func() {
+				v.fetchMu.Lock()
+				v.polling = false
+				v.fetchMu.Unlock()
+				wg.Done()
+			}()
+			pollSlots <- struct{}{}
+			defer func() { <-pollSlots }()
+			v.pollStarted(time.Now())
+			v.poll()
+		}(v)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(nextPoll()):
+	}
+}
+
+// poll fetches the records of the vehicle, unless it streams them or its
+// circuit is open.
+func (v *vehicle) poll() {
+	if !v.circuit.allow(time.Now()) {
+		return
+	}
+	if v.wakeupIfStale(time.Now()) && !v.stream.isConnected() {
+		time.Sleep(*wakeupWaitFlag)
+	}
+	if v.stream.isConnected() {
+		return
+	}
+	if v.fetchMetrics() {
+		v.fetchCells()
+		v.published()
+	}
+	pollHeartbeat()
+}
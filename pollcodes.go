@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var pollCodesFlag = flag.String("poll-codes", "", "Comma-separated code=interval, e.g. Y=1h, processing the records of these codes at most once per interval instead of at every poll; the server returns all the codes at once, so the vehicles are still polled at their poll interval and an interval shorter than it has no effect")
+
+// pollCodes are the intervals of -poll-codes by record code.
+var pollCodes = map[string]time.Duration{}
+
+// pollCodeSlack is subtracted from the intervals so that a code is not
+// skipped because its poll is a bit early.
+const pollCodeSlack = time.Second
+
+func setupPollCodes() error {
+	for _, s := range strings.Split(*pollCodesFlag, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		code, interval, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("invalid -poll-codes entry %q, expected code=interval", s)
+		}
+		if _, ok := metricsMap[code]; !ok {
+			return fmt.Errorf("unknown record code %q in -poll-codes", code)
+		}
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid interval %q of %s in -poll-codes", interval, code)
+		}
+		pollCodes[code] = d
+	}
+	return nil
+}
+
+// nextPoll returns the time until the next poll: the poll interval, or the
+// shortest poll_interval of the vehicles. The vehicles whose interval did
+// not elapse are skipped, see pollDue.
+func nextPoll() time.Duration {
+	d := pollInterval()
+	for _, v := range vehicles {
+		d = min(d, v.pollInterval())
+	}
+	return d
+}
+
+// codeDue reports whether a record of the code is to be processed, the
+// code not being in -poll-codes or its interval having elapsed since the
+// last processed one, and records it.
+func (v *vehicle) codeDue(code string, now time.Time) bool {
+	interval, ok := pollCodes[code]
+	if !ok {
+		return true
+	}
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+	if v.codeProcessed == nil {
+		v.codeProcessed = map[string]time.Time{}
+	}
+	if now.Sub(v.codeProcessed[code]) < interval-pollCodeSlack {
+		return false
+	}
+	v.codeProcessed[code] = now
+	return true
+}
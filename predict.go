@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Coefficients of the consumption model used by the trip prediction.
+const (
+	// elevationKWhPerM is the energy to climb one meter: about 1.8 t at
+	// 9.81 m/s², with a 90% efficient drivetrain.
+	elevationKWhPerM = 1800 * 9.81 / 3.6e6 / 0.9
+	// coldPenaltyPerDegree is the extra consumption for each degree below
+	// coldThresholdC, capped at maxColdPenalty.
+	coldPenaltyPerDegree = 0.01
+	coldThresholdC       = 15
+	maxColdPenalty       = 0.4
+	// defaultReserveSOC is the SOC, in percent, to keep at arrival.
+	defaultReserveSOC = 10
+)
+
+var tripFeasible = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_trip_feasible",
+	Help: "1 if the planned trip can be driven with the current SOC, 0 otherwise.",
+}, []string{"vehicle", "trip"})
+
+// plannedTrip is a trip evaluated after every fetch for ovms_trip_feasible.
+type plannedTrip struct {
+	Name           string   `json:"name"`
+	Vehicle        string   `json:"vehicle,omitempty"`
+	DistanceKm     float64  `json:"distance_km"`
+	ElevationGainM float64  `json:"elevation_gain_m,omitempty"`
+	TemperatureC   *float64 `json:"temperature_c,omitempty"`
+	ReserveSOC     *float64 `json:"reserve_soc,omitempty"`
+}
+
+func (p *plannedTrip) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("planned trip without a name")
+	}
+	if p.DistanceKm <= 0 {
+		return fmt.Errorf("planned trip %q: distance_km must be positive", p.Name)
+	}
+	return nil
+}
+
+// prediction is the outcome of a trip prediction.
+type prediction struct {
+	Vehicle             string  `json:"vehicle"`
+	DistanceKm          float64 `json:"distance_km"`
+	ElevationGainM      float64 `json:"elevation_gain_m"`
+	TemperatureC        float64 `json:"temperature_c"`
+	ReserveSOC          float64 `json:"reserve_soc"`
+	WhPerKm             float64 `json:"wh_per_km"`
+	RequiredKWh         float64 `json:"required_kwh"`
+	AvailableKWh        float64 `json:"available_kwh"`
+	ArrivalSOC          float64 `json:"arrival_soc"`
+	Feasible            bool    `json:"feasible"`
+	EfficiencyEstimated bool    `json:"efficiency_estimated"`
+}
+
+// predict estimates whether the trip can be driven. The usable energy is
+// derived from the SOC, the capacity (CAC) and the pack voltage; the
+// consumption is the learned efficiency, or the one implied by the estimated
+// range if not enough distance was driven yet.
+func (v *vehicle) predict(p plannedTrip) (*prediction, error) {
+	soc := v.floatField("S", "ms_v_bat_soc")
+	cac := v.floatField("S", "ms_v_bat_cac")
+	voltage := v.floatField("S", "ms_v_bat_voltage")
+	if soc == nil || cac == nil || voltage == nil || *cac <= 0 || *voltage <= 0 {
+		return nil, fmt.Errorf("the SOC, CAC and battery voltage of %s are not known yet", v.id)
+	}
+	capacityKWh := *cac * *voltage / 1000
+	availableKWh := capacityKWh * *soc / 100
+
+	pr := &prediction{
+		Vehicle:        v.id,
+		DistanceKm:     p.DistanceKm,
+		ElevationGainM: p.ElevationGainM,
+		ReserveSOC:     defaultReserveSOC,
+		AvailableKWh:   availableKWh,
+	}
+	if p.ReserveSOC != nil {
+		pr.ReserveSOC = *p.ReserveSOC
+	}
+	if p.TemperatureC != nil {
+		pr.TemperatureC = *p.TemperatureC
+	} else if t := v.floatField("D", "ms_v_env_temp"); t != nil {
+		pr.TemperatureC = *t
+	} else {
+		pr.TemperatureC = coldThresholdC
+	}
+
+	if e, ok := v.efficiency.current(); ok && e > 0 {
+		pr.WhPerKm = e
+	} else if r := v.distanceKmField("ms_v_bat_range_est"); r != nil && *r > 0 {
+		pr.WhPerKm = availableKWh * 1000 / *r
+		pr.EfficiencyEstimated = true
+	} else {
+		return nil, fmt.Errorf("the consumption of %s is not known yet", v.id)
+	}
+
+	penalty := math.Min(math.Max(coldThresholdC-pr.TemperatureC, 0)*coldPenaltyPerDegree, maxColdPenalty)
+	pr.RequiredKWh = p.DistanceKm*pr.WhPerKm/1000*(1+penalty) + math.Max(p.ElevationGainM, 0)*elevationKWhPerM
+	pr.ArrivalSOC = (availableKWh - pr.RequiredKWh) / capacityKWh * 100
+	pr.Feasible = pr.ArrivalSOC >= pr.ReserveSOC
+	return pr, nil
+}
+
+// updatePlannedTrips evaluates the planned trips of the config.
+func (v *vehicle) updatePlannedTrips() {
+	for _, p := range cfg().PlannedTrips {
+		if p.Vehicle != "" && p.Vehicle != v.id {
+			continue
+		}
+		pr, err := v.predict(*p)
+		if err != nil {
+			tripFeasible.DeleteLabelValues(v.id, p.Name)
+			continue
+		}
+		tripFeasible.WithLabelValues(v.id, p.Name).Set(boolToFloat(pr.Feasible))
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// handlePredict serves /api/v1/vehicles/<id>/predict?distance_km=&
+// elevation_gain_m=&temperature_c=&reserve_soc=.
+func handlePredict(w http.ResponseWriter, r *http.Request, v *vehicle) {
+	q := r.URL.Query()
+	var p plannedTrip
+	var err error
+	if p.DistanceKm, err = strconv.ParseFloat(q.Get("distance_km"), 64); err != nil || p.DistanceKm <= 0 {
+		http.Error(w, "distance_km must be a positive number", http.StatusBadRequest)
+		return
+	}
+	if s := q.Get("elevation_gain_m"); s != "" {
+		if p.ElevationGainM, err = strconv.ParseFloat(s, 64); err != nil {
+			http.Error(w, "invalid elevation_gain_m", http.StatusBadRequest)
+			return
+		}
+	}
+	for name, dst := range map[string]**float64{"temperature_c": &p.TemperatureC, "reserve_soc": &p.ReserveSOC} {
+		if s := q.Get(name); s != "" {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*dst = &f
+		}
+	}
+	pr, err := v.predict(p)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	writeShapedJSON(w, r, pr)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	profileDefault  = "default"
+	profileLowPower = "low-power"
+
+	// lowPowerPollDuration is used in the low-power profile unless
+	// -poll-duration is set explicitly.
+	lowPowerPollDuration = 15 * time.Minute
+)
+
+var profileFlag = flag.String("profile", profileDefault, "Operating profile: default or low-power (longer poll interval, no fsync, less logging)")
+
+var (
+	// pollLogLevel is the level of the routine per-poll log messages.
+	pollLogLevel = slog.LevelInfo
+
+	// fsyncEnabled controls whether on-disk state is fsync'ed after writes.
+	fsyncEnabled = true
+)
+
+// applyProfile adjusts the settings according to -profile. It must be called
+// after flag.Parse.
+func applyProfile() error {
+	switch *profileFlag {
+	case profileDefault:
+	case profileLowPower:
+		if !isFlagSet("poll-duration") {
+			*pollDurationFlag = lowPowerPollDuration
+		}
+		pollLogLevel = slog.LevelDebug
+		fsyncEnabled = false
+	default:
+		return fmt.Errorf("unknown profile %q", *profileFlag)
+	}
+	return nil
+}
+
+// isFlagSet reports whether the named flag was set on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
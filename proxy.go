@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+var proxyURLFlag = flag.String("proxy-url", "", "Proxy of all the outbound connections, http://, https:// or socks5://[user:password@]host[:port]; without it the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are honored")
+
+// proxyURL is the parsed -proxy-url, nil without it.
+var proxyURL *url.URL
+
+// proxyDefaultPorts are the ports of the proxies by scheme.
+var proxyDefaultPorts = map[string]string{"http": "80", "https": "443", "socks5": "1080"}
+
+func setupProxy() error {
+	if *proxyURLFlag == "" {
+		return nil
+	}
+	u, err := url.Parse(*proxyURLFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -proxy-url: %v", err)
+	}
+	port, ok := proxyDefaultPorts[u.Scheme]
+	if !ok || u.Hostname() == "" {
+		return fmt.Errorf("invalid -proxy-url %q, expected http://, https:// or socks5://host[:port]", u.Redacted())
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	proxyURL = u
+	// All the HTTP clients use the default transport.
+	http.DefaultTransport.(*http.Transport).Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// outboundProxy returns the proxy of a TCP connection to addr, if any: the
+// -proxy-url, otherwise the HTTPS_PROXY unless addr is in NO_PROXY.
+func outboundProxy(addr string) (*url.URL, error) {
+	if proxyURL != nil {
+		return proxyURL, nil
+	}
+	u, err := http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+	if u != nil && u.Port() == "" {
+		if port, ok := proxyDefaultPorts[u.Scheme]; ok {
+			u.Host = net.JoinHostPort(u.Hostname(), port)
+		}
+	}
+	return u, err
+}
+
+// dialOutbound opens a TCP connection to addr, through the proxy if any. The
+// HTTP proxies are asked to CONNECT.
+func dialOutbound(addr string, timeout time.Duration) (net.Conn, error) {
+	p, err := outboundProxy(addr)
+	if err != nil {
+		return nil, err
+	}
+	d := &net.Dialer{Timeout: timeout}
+	if p == nil {
+		return d.Dial("tcp", addr)
+	}
+	switch p.Scheme {
+	case "socks5":
+		pd, err := proxy.FromURL(p, d)
+		if err != nil {
+			return nil, err
+		}
+		return pd.Dial("tcp", addr)
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", p.Scheme)
+	}
+
+	conn, err := d.Dial("tcp", p.Host)
+	if err != nil {
+		return nil, err
+	}
+	if p.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: p.Hostname()})
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: http.Header{}}
+	if p.User != nil {
+		password, _ := p.User.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(p.User.Username()+":"+password)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: CONNECT %s: %s", p.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{conn, br}, nil
+}
+
+// bufferedConn is a connection whose reads go through a reader that may
+// hold data already received.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+var publicAddrFlag = flag.String("public-addr", "", "Address of the read-only public status page; empty disables it")
+
+// publicSOCBucket is the width, in percent, of the SOC ranges shown on the
+// public status page.
+const publicSOCBucket = 20
+
+var publicTemplate = template.Must(template.New("public").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Vehicle status</title><meta http-equiv="refresh" content="60"></head>
+<body>
+<table>
+<tr><th>Vehicle</th><th>Battery</th><th>Charging</th></tr>
+{{range .}}<tr><td>{{.Vehicle}}</td><td>{{or .SOC "unknown"}}</td><td>{{if .Charging}}yes{{else}}no{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// publicStatus is the redacted status of a vehicle: no location, no exact
+// values.
+type publicStatus struct {
+	Vehicle  string `json:"vehicle"`
+	SOC      string `json:"soc,omitempty"`
+	Charging bool   `json:"charging"`
+}
+
+func socBucket(soc string) string {
+	f, err := strconv.ParseFloat(soc, 64)
+	if err != nil {
+		return ""
+	}
+	low := int(f) / publicSOCBucket * publicSOCBucket
+	if low >= 100 {
+		low = 100 - publicSOCBucket
+	}
+	return fmt.Sprintf("%d-%d%%", low, low+publicSOCBucket)
+}
+
+func publicStatuses() []publicStatus {
+	var s []publicStatus
+	for _, v := range vehicles {
+		s = append(s, publicStatus{
+			Vehicle:  v.id,
+			SOC:      socBucket(v.field("S", "ms_v_bat_soc")),
+			Charging: v.state() == stateCharging,
+		})
+	}
+	return s
+}
+
+func handlePublic(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := publicTemplate.Execute(w, publicStatuses()); err != nil {
+		slog.Error("error rendering the public status page", "err", err)
+	}
+}
+
+func handlePublicJSON(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, publicStatuses())
+}
+
+// servePublic serves the public status page on -public-addr. Nothing else is
+// reachable on that listener.
+func servePublic() {
+	if *publicAddrFlag == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", instrument("public:/", http.HandlerFunc(handlePublic)))
+	mux.Handle("/status.json", instrument("public:/status.json", http.HandlerFunc(handlePublicJSON)))
+	ln, err := listen(*publicAddrFlag)
+	if err != nil {
+		fatal("error listening for the public status page", err)
+	}
+	slog.Info("listening for the public status page", "addr", *publicAddrFlag)
+	srv := &http.Server{Handler: middleware(mux)}
+	go func() {
+		<-quit
+		srv.Close()
+	}()
+	go func() {
+		if err := serve(srv, ln); err != http.ErrServerClosed {
+			fatal("public http server failed", err)
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	pushgatewayURLFlag = flag.String("pushgateway-url", "", "Prometheus Pushgateway URL to which the metrics are pushed after every poll, for the hosts that cannot be scraped; empty disables it")
+	pushgatewayJobFlag = flag.String("pushgateway-job", "ovms_exporter", "Job label of the metrics pushed to -pushgateway-url")
+)
+
+var pushFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ovms_pushgateway_push_failures_total",
+	Help: "Number of failed pushes to -pushgateway-url.",
+})
+
+// samplesGatherer gathers the record samples of the vehicles, without their
+// timestamps: the Pushgateway would keep the pushed samples stale.
+type samplesGatherer struct{}
+
+func (samplesGatherer) Gather() ([]*dto.MetricFamily, error) {
+	var b strings.Builder
+	for _, v := range vehicles {
+		b.WriteString(v.metricsText())
+	}
+	var p expfmt.TextParser
+	mfs, err := p.TextToMetricFamilies(strings.NewReader(b.String()))
+	if err != nil {
+		return nil, err
+	}
+	families := make([]*dto.MetricFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			m.TimestampMs = nil
+		}
+		families = append(families, mf)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	return families, nil
+}
+
+// pushMetrics replaces the metrics of the job on the Pushgateway with the
+// current ones.
+func pushMetrics() {
+	if *pushgatewayURLFlag == "" {
+		return
+	}
+	p := push.New(*pushgatewayURLFlag, *pushgatewayJobFlag).
+		Client(&http.Client{Timeout: 30 * time.Second}).
+		Gatherer(prometheus.Gatherers{exportGatherer{prometheus.DefaultGatherer}, samplesGatherer{}})
+	if err := p.Push(); err != nil {
+		pushFailures.Inc()
+		slog.Error("error pushing to the Pushgateway", "url", redactURL(*pushgatewayURLFlag), "err", err)
+	}
+}
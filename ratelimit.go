@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	serverRateFlag  = flag.Float64("server-rate", 1, "Maximum rate of the requests to the OVMS server, per second and across all the vehicles; 0 disables the limit")
+	serverBurstFlag = flag.Int("server-burst", 5, "Number of requests to the OVMS server that can be sent at once, above -server-rate")
+)
+
+var (
+	serverThrottledTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ovms_server_throttled_total",
+		Help: "Number of requests the OVMS server answered with 429 Too Many Requests.",
+	})
+	serverRateLimitWait = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "ovms_server_rate_limit_wait_seconds_total",
+		Help: "Time spent waiting for the client-side rate limit before the requests to the OVMS server.",
+	})
+)
+
+// errThrottled is the error of the requests held back by the server.
+var errThrottled = errors.New("throttled by the OVMS server")
+
+// defaultRetryAfter is the back off after a 429 without a usable
+// Retry-After.
+const defaultRetryAfter = time.Minute
+
+// tokenBucket limits the rate of the requests and holds them back while the
+// server asked to retry later.
+type tokenBucket struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// until is the end of the back off asked by the server.
+	until time.Time
+}
+
+var serverLimiter tokenBucket
+
+// reserve takes a token and returns how long to wait before using it, or
+// an error during a back off.
+func (b *tokenBucket) reserve(rate float64, burst int, now time.Time) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Before(b.until) {
+		return 0, fmt.Errorf("%w, retrying after %s", errThrottled, b.until.Format(time.RFC3339))
+	}
+	if rate <= 0 {
+		return 0, nil
+	}
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else {
+		b.tokens = min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0, nil
+	}
+	return time.Duration(-b.tokens / rate * float64(time.Second)), nil
+}
+
+// backOff holds the requests back until the time.
+func (b *tokenBucket) backOff(until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if until.After(b.until) {
+		b.until = until
+	}
+}
+
+// retryAfter parses a Retry-After header, in seconds or an HTTP date.
+func retryAfter(h string, now time.Time) time.Duration {
+	if s, err := strconv.Atoi(h); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return defaultRetryAfter
+}
+
+// serverDo sends a request to the OVMS server, within the rate limit,
+// failing over to the next -server when it cannot be reached or answers
+// with a server error. A 429 response is returned as an error and holds the
+// following requests back as long as the server asked.
+func serverDo(req *http.Request) (*http.Response, error) {
+	return servers.do(req)
+}
+
+// do sends a request to the servers of the pool, see serverDo.
+func (p *serverPool) do(req *http.Request) (*http.Response, error) {
+	addrs := p.addrs()
+	order := p.order(len(addrs), time.Now())
+	var resp *http.Response
+	var err error
+	for n, i := range order {
+		r := req.Clone(req.Context())
+		r.URL.Host = addrs[i]
+		r.Host = ""
+		resp, err = serverDoOnce(r)
+		if !serverFailed(resp, err) || n == len(order)-1 {
+			if err == nil {
+				p.use(i, order[0], addrs, time.Now())
+			}
+			return resp, err
+		}
+		reason := withoutURL(err)
+		if err == nil {
+			resp.Body.Close()
+			reason = fmt.Errorf("%s", resp.Status)
+		}
+		slog.Warn("OVMS server failed, trying the next one", "server", addrs[i], "err", reason)
+	}
+	return resp, err
+}
+
+// withoutURL returns the error of a request without its URL, which carries
+// the credentials in the query or, for Telegram, the path.
+func withoutURL(err error) error {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		return uerr.Err
+	}
+	return err
+}
+
+// serverDoOnce sends a request, within the rate limit.
+func serverDoOnce(req *http.Request) (*http.Response, error) {
+	wait, err := serverLimiter.reserve(*serverRateFlag, *serverBurstFlag, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		slog.Log(context.Background(), pollLogLevel, "waiting for the rate limit", "duration", wait)
+		serverRateLimitWait.Add(wait.Seconds())
+		time.Sleep(wait)
+	}
+	client := &http.Client{Timeout: *pollTimeoutFlag}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		now := time.Now()
+		d := retryAfter(resp.Header.Get("Retry-After"), now)
+		serverLimiter.backOff(now.Add(d))
+		serverThrottledTotal.Inc()
+		return nil, fmt.Errorf("%w, retrying in %s", errThrottled, d)
+	}
+	return resp, nil
+}
+
+// serverGet sends a GET request to the OVMS server, see serverDo.
+func serverGet(url string) (*http.Response, error) {
+	return servers.get(url)
+}
+
+// get sends a GET request to the servers of the pool, see serverDo.
+func (p *serverPool) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return p.do(req)
+}
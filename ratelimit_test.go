@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	type step struct {
+		at   time.Duration
+		wait time.Duration
+	}
+	for _, tc := range []struct {
+		name  string
+		rate  float64
+		burst int
+		steps []step
+	}{
+		{"burst", 1, 3, []step{{0, 0}, {0, 0}, {0, 0}, {0, time.Second}, {0, 2 * time.Second}}},
+		{"refill", 2, 1, []step{{0, 0}, {0, 500 * time.Millisecond}, {time.Second, 0}, {time.Second, 500 * time.Millisecond}}},
+		{"capped at burst", 1, 2, []step{{0, 0}, {time.Hour, 0}, {time.Hour, 0}, {time.Hour, time.Second}}},
+		{"unlimited", 0, 1, []step{{0, 0}, {0, 0}, {0, 0}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var b tokenBucket
+			for i, s := range tc.steps {
+				wait, err := b.reserve(tc.rate, tc.burst, start.Add(s.at))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if wait != s.wait {
+					t.Errorf("request %d at %v waits %v, want %v", i, s.at, wait, s.wait)
+				}
+			}
+		})
+	}
+}
+
+func TestTokenBucketBackOff(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var b tokenBucket
+	b.backOff(start.Add(time.Minute))
+	// An earlier back off does not shorten the current one.
+	b.backOff(start.Add(time.Second))
+	if _, err := b.reserve(1, 1, start.Add(30*time.Second)); !errors.Is(err, errThrottled) {
+		t.Errorf("reserve during the back off = %v, want %v", err, errThrottled)
+	}
+	if wait, err := b.reserve(1, 1, start.Add(time.Minute)); err != nil || wait != 0 {
+		t.Errorf("reserve after the back off = %v, %v, want 0, nil", wait, err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), defaultRetryAfter},
+		{"0", defaultRetryAfter},
+		{"-5", defaultRetryAfter},
+		{"", defaultRetryAfter},
+		{"soon", defaultRetryAfter},
+	} {
+		if got := retryAfter(tc.header, now); got != tc.want {
+			t.Errorf("retryAfter(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
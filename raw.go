@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordDiagnostic describes how a record of the last fetch was parsed.
+type recordDiagnostic struct {
+	Code           string `json:"code"`
+	MsgTime        string `json:"msgtime"`
+	Error          string `json:"error,omitempty"`
+	Skipped        string `json:"skipped,omitempty"`
+	Fields         int    `json:"fields"`
+	ExpectedFields int    `json:"expected_fields,omitempty"`
+}
+
+// rawCapture keeps the last raw response of the OVMS server.
+type rawCapture struct {
+	mu      sync.Mutex
+	at      time.Time
+	ok      bool
+	body    []byte
+	records []recordDiagnostic
+}
+
+func (c *rawCapture) set(ok bool, body []byte, records []recordDiagnostic) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.at = time.Now()
+	c.ok = ok
+	c.body = body
+	c.records = records
+}
+
+// diagnose returns the diagnostic of a record, skipped being the reason the
+// record is ignored, if any.
+func diagnose(rec record, err error, skipped string) recordDiagnostic {
+	d := recordDiagnostic{
+		Code:    rec.Code,
+		MsgTime: rec.MsgTime,
+		Skipped: skipped,
+		Fields:  len(strings.Split(rec.Msg, ",")),
+	}
+	if err != nil {
+		d.Error = err.Error()
+	}
+	if m, ok := metricsMap[rec.Code]; ok {
+		d.ExpectedFields = len(m)
+		switch {
+		case skipped != "":
+		case !filter.collectGroup(rec.Code):
+			d.Skipped = "group not collected"
+		case d.Fields > len(m):
+			d.Skipped = "extra fields ignored"
+		}
+	} else if skipped == "" && !decodedCode(rec.Code) {
+		d.Skipped = "unknown record code"
+	}
+	return d
+}
+
+// handleDebugRaw serves the last raw response of a vehicle with the parse
+// diagnostics of its records. It requires the -admin-token since the
+// response carries the location.
+func handleDebugRaw(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	v := findVehicle(r.URL.Query().Get("vehicle"))
+	if v == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, v.raw.report(v.id))
+}
+
+// rawReport is the last raw response of a vehicle with its diagnostics.
+type rawReport struct {
+	Vehicle string             `json:"vehicle"`
+	Time    time.Time          `json:"time"`
+	OK      bool               `json:"ok"`
+	Raw     interface{}        `json:"raw"`
+	Records []recordDiagnostic `json:"records"`
+}
+
+func (c *rawCapture) report(vehicleID string) rawReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	r := rawReport{Vehicle: vehicleID, Time: c.at, OK: c.ok, Raw: string(c.body), Records: c.records}
+	if json.Valid(c.body) {
+		r.Raw = json.RawMessage(c.body)
+	}
+	return r
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	recordLogDirFlag       = flag.String("record-log-dir", "", "Directory where every parsed record is persisted, in daily files per vehicle, for the export subcommand; empty disables it")
+	recordLogRetentionFlag = flag.Duration("record-log-retention", 0, "How long the daily files of -record-log-dir are kept; 0 keeps them forever")
+)
+
+// recordLogExt is the extension of the daily files, of one JSON object per
+// line, appended to as the records are received:
+// <dir>/<vehicle>/<YYYY-MM-DD>.jsonl, by UTC day of the record.
+const recordLogExt = ".jsonl"
+
+const recordLogDayLayout = "2006-01-02"
+
+// loggedRecord is a line of the daily files.
+type loggedRecord struct {
+	Time time.Time `json:"time"`
+	Code string    `json:"code"`
+	Msg  string    `json:"msg"`
+}
+
+// recordLog appends the records of a vehicle to its daily files. A record is
+// only appended once, when its message time changes.
+type recordLog struct {
+	vehicle string
+
+	mu   sync.Mutex
+	day  string
+	file *os.File
+	// last are the times of the latest records by code, loaded from the
+	// latest daily file on the first append.
+	last map[string]time.Time
+}
+
+func (l *recordLog) dir() string {
+	return filepath.Join(*recordLogDirFlag, l.vehicle)
+}
+
+// append persists a record newer than the latest one of its code.
+func (l *recordLog) append(rec record, ts time.Time) {
+	if *recordLogDirFlag == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last == nil {
+		l.last = map[string]time.Time{}
+		l.loadLast()
+	}
+	if !ts.After(l.last[rec.Code]) {
+		return
+	}
+	if err := l.write(loggedRecord{ts.UTC(), rec.Code, rec.Msg}); err != nil {
+		slog.Error("error appending to the record log", "vehicle", l.vehicle, "err", err)
+		return
+	}
+	l.last[rec.Code] = ts
+}
+
+// write appends a line to the file of the day of the record, opening it
+// and applying the retention when the day changes.
+func (l *recordLog) write(lr loggedRecord) error {
+	day := lr.Time.Format(recordLogDayLayout)
+	if l.file == nil || day != l.day {
+		if l.file != nil {
+			l.file.Close()
+			l.file = nil
+		}
+		if err := os.MkdirAll(l.dir(), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(l.dir(), day+recordLogExt), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		l.file, l.day = f, day
+		l.expire(lr.Time)
+	}
+	data, err := json.Marshal(lr)
+	if err != nil {
+		return err
+	}
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+// recordLogDays returns the days of the daily files in the directory of a
+// vehicle, oldest first.
+func recordLogDays(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var days []string
+	for _, e := range entries {
+		day, ok := strings.CutSuffix(e.Name(), recordLogExt)
+		if _, err := time.Parse(recordLogDayLayout, day); ok && err == nil && !e.IsDir() {
+			days = append(days, day)
+		}
+	}
+	sort.Strings(days)
+	return days, nil
+}
+
+// expire removes the daily files older than -record-log-retention.
+func (l *recordLog) expire(now time.Time) {
+	if *recordLogRetentionFlag <= 0 {
+		return
+	}
+	days, err := recordLogDays(l.dir())
+	if err != nil {
+		slog.Error("error listing the record log", "vehicle", l.vehicle, "err", err)
+		return
+	}
+	cutoff := now.Add(-*recordLogRetentionFlag).UTC().Format(recordLogDayLayout)
+	for _, day := range days {
+		if day >= cutoff {
+			break
+		}
+		if err := os.Remove(filepath.Join(l.dir(), day+recordLogExt)); err != nil {
+			slog.Error("error removing an expired record log file", "vehicle", l.vehicle, "day", day, "err", err)
+			continue
+		}
+		slog.Info("removed an expired record log file", "vehicle", l.vehicle, "day", day)
+	}
+}
+
+// loadLast reads the times of the latest records of the latest daily file,
+// for the records polled again after a restart not to be appended twice.
+func (l *recordLog) loadLast() {
+	days, err := recordLogDays(l.dir())
+	if err != nil || len(days) == 0 {
+		return
+	}
+	err = readRecordLog(filepath.Join(l.dir(), days[len(days)-1]+recordLogExt), func(lr loggedRecord) error {
+		if lr.Time.After(l.last[lr.Code]) {
+			l.last[lr.Code] = lr.Time
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("error reading the record log", "vehicle", l.vehicle, "err", err)
+	}
+}
+
+// readRecordLog calls fn with each record of a daily file. The invalid
+// lines, e.g. truncated by a crash, are skipped.
+func readRecordLog(name string, fn func(loggedRecord) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var lr loggedRecord
+		if err := json.Unmarshal(line, &lr); err != nil {
+			slog.Warn("skipping an invalid record log line", "file", name, "err", err)
+			continue
+		}
+		if err := fn(lr); err != nil {
+			return err
+		}
+	}
+}
+
+const exportUsage = `usage: ovms_exporter [flags] export [-vehicle id] [-code S,D] [-from time] [-to time] csv
+
+Writes the records of -record-log-dir to the standard output as CSV, with
+the vehicle, time, code and msg columns, in time order per vehicle. The
+output can be imported back with the import command. The times are in
+RFC 3339 format, as are -from and -to.
+
+Flags:`
+
+// exportCommand returns the flags of the export command and its function.
+func exportCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	vehicleID := fs.String("vehicle", "", "Vehicle to export; all of them if empty")
+	codes := fs.String("code", "", "Comma-separated record codes to export, e.g. S,D; all of them if empty")
+	from := fs.String("from", "", "Time of the oldest records to export")
+	to := fs.String("to", "", "Time after which the records are not exported")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), exportUsage)
+		fs.PrintDefaults()
+	}
+	return fs, func(args []string) error {
+		if len(args) != 1 || args[0] != "csv" {
+			fs.Usage()
+			return fmt.Errorf("invalid arguments")
+		}
+		if *recordLogDirFlag == "" {
+			return fmt.Errorf("export needs -record-log-dir")
+		}
+		parseTime := func(s string) (time.Time, error) {
+			if s == "" {
+				return time.Time{}, nil
+			}
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return t, fmt.Errorf("invalid time %q: %v", s, err)
+			}
+			return t, nil
+		}
+		start, err := parseTime(*from)
+		if err != nil {
+			return err
+		}
+		end, err := parseTime(*to)
+		if err != nil {
+			return err
+		}
+		codeSet := map[string]bool{}
+		for _, c := range strings.Split(*codes, ",") {
+			if c = strings.TrimSpace(c); c != "" {
+				codeSet[c] = true
+			}
+		}
+
+		ids := []string{*vehicleID}
+		if *vehicleID == "" {
+			entries, err := os.ReadDir(*recordLogDirFlag)
+			if err != nil {
+				return err
+			}
+			ids = nil
+			for _, e := range entries {
+				if e.IsDir() {
+					ids = append(ids, e.Name())
+				}
+			}
+		}
+
+		w := csv.NewWriter(os.Stdout)
+		w.Write([]string{"vehicle", "time", "code", "msg"})
+		for _, id := range ids {
+			dir := filepath.Join(*recordLogDirFlag, id)
+			days, err := recordLogDays(dir)
+			if os.IsNotExist(err) {
+				return fmt.Errorf("no records of vehicle %q in %s", id, *recordLogDirFlag)
+			}
+			if err != nil {
+				return err
+			}
+			var records []loggedRecord
+			for _, day := range days {
+				// The days are UTC, as the times of the records.
+				if !start.IsZero() && day < start.UTC().Format(recordLogDayLayout) ||
+					!end.IsZero() && day > end.UTC().Format(recordLogDayLayout) {
+					continue
+				}
+				err := readRecordLog(filepath.Join(dir, day+recordLogExt), func(lr loggedRecord) error {
+					if len(codeSet) > 0 && !codeSet[lr.Code] ||
+						!start.IsZero() && lr.Time.Before(start) ||
+						!end.IsZero() && lr.Time.After(end) {
+						return nil
+					}
+					records = append(records, lr)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+			for _, lr := range records {
+				w.Write([]string{id, lr.Time.Format(time.RFC3339), lr.Code, lr.Msg})
+			}
+		}
+		w.Flush()
+		return w.Error()
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteSeries is a time series of a Prometheus remote write request.
+type remoteSeries struct {
+	// labels are the name/value pairs, __name__ included, sorted by name.
+	labels  []*dto.LabelPair
+	samples []remoteSample
+}
+
+type remoteSample struct {
+	value float64
+	tsMs  int64
+}
+
+// parseRemoteSeries groups timestamped samples in the text format by series.
+func parseRemoteSeries(samples string) ([]*remoteSeries, error) {
+	var p expfmt.TextParser
+	mfs, err := p.TextToMetricFamilies(strings.NewReader(samples))
+	if err != nil {
+		return nil, err
+	}
+	byKey := map[string]*remoteSeries{}
+	var series []*remoteSeries
+	for name, mf := range mfs {
+		for _, m := range mf.Metric {
+			var value float64
+			switch {
+			case m.Gauge != nil:
+				value = m.Gauge.GetValue()
+			case m.Counter != nil:
+				value = m.Counter.GetValue()
+			case m.Untyped != nil:
+				value = m.Untyped.GetValue()
+			default:
+				continue
+			}
+			labels := append([]*dto.LabelPair{{Name: strPtr("__name__"), Value: strPtr(name)}}, m.Label...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			var key strings.Builder
+			for _, l := range labels {
+				fmt.Fprintf(&key, "%s=%q,", l.GetName(), l.GetValue())
+			}
+			s, ok := byKey[key.String()]
+			if !ok {
+				s = &remoteSeries{labels: labels}
+				byKey[key.String()] = s
+				series = append(series, s)
+			}
+			s.samples = append(s.samples, remoteSample{value, m.GetTimestampMs()})
+		}
+	}
+	// The samples of a series must be in time order.
+	for _, s := range series {
+		sort.SliceStable(s.samples, func(i, j int) bool { return s.samples[i].tsMs < s.samples[j].tsMs })
+	}
+	return series, nil
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+// encodeWriteRequest encodes the prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label { string name = 1; string value = 2; }
+//	Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []*remoteSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.GetName())
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.GetValue())
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, sample := range s.samples {
+			var b []byte
+			b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+			b = protowire.AppendFixed64(b, math.Float64bits(sample.value))
+			b = protowire.AppendTag(b, 2, protowire.VarintType)
+			b = protowire.AppendVarint(b, uint64(sample.tsMs))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, b)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
+
+// snappyBlock encodes data in the snappy block format required by remote
+// write. Only literals are emitted: the output is not compressed, but any
+// snappy decoder reads it.
+func snappyBlock(data []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(data)))
+	for len(data) > 0 {
+		n := min(len(data), 1<<16)
+		// Tag 61: literal whose length-1 follows in 2 bytes.
+		out = append(out, 61<<2, byte(n-1), byte((n-1)>>8))
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+	return out
+}
+
+// remoteWrite sends the series to a Prometheus remote write endpoint.
+func remoteWrite(url string, series []*remoteSeries) error {
+	body := snappyBlock(encodeWriteRequest(series))
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote write: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// decodeSnappy decodes a snappy block made of literals, the only elements
+// snappyBlock emits, following the format description of snappy.
+func decodeSnappy(t *testing.T, b []byte) []byte {
+	t.Helper()
+	n, l := binary.Uvarint(b)
+	if l <= 0 {
+		t.Fatal("invalid snappy preamble")
+	}
+	b = b[l:]
+	var out []byte
+	for len(b) > 0 {
+		tag := b[0]
+		if tag&3 != 0 {
+			t.Fatalf("unexpected element type %d", tag&3)
+		}
+		size := int(tag >> 2)
+		b = b[1:]
+		switch {
+		case size < 60:
+		case size <= 63:
+			k := size - 59
+			if len(b) < k {
+				t.Fatal("truncated literal length")
+			}
+			size = 0
+			for i := k - 1; i >= 0; i-- {
+				size = size<<8 | int(b[i])
+			}
+			b = b[k:]
+		}
+		size++
+		if len(b) < size {
+			t.Fatal("truncated literal")
+		}
+		out = append(out, b[:size]...)
+		b = b[size:]
+	}
+	if uint64(len(out)) != n {
+		t.Fatalf("decoded %d bytes, preamble says %d", len(out), n)
+	}
+	return out
+}
+
+func TestSnappyBlock(t *testing.T) {
+	for _, n := range []int{0, 1, 59, 60, 1 << 16, 1<<16 + 1, 3<<16 + 7} {
+		data := make([]byte, n)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		if got := decodeSnappy(t, snappyBlock(data)); !bytes.Equal(got, data) {
+			t.Errorf("snappyBlock of %d bytes does not decode to its input", n)
+		}
+	}
+}
+
+// decodedSeries is a time series decoded from a WriteRequest.
+type decodedSeries struct {
+	Labels  [][2]string
+	Samples []remoteSample
+}
+
+// decodeWriteRequest decodes a prometheus.WriteRequest with protowire.
+func decodeWriteRequest(t *testing.T, b []byte) []decodedSeries {
+	t.Helper()
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+			if n = fn(num, typ, b); n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			b = b[n:]
+		}
+	}
+	var series []decodedSeries
+	fields(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		if num != 1 || typ != protowire.BytesType {
+			t.Fatalf("unexpected WriteRequest field %d", num)
+		}
+		var s decodedSeries
+		fields(ts, func(num protowire.Number, typ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			switch num {
+			case 1:
+				var l [2]string
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					v, n := protowire.ConsumeString(b)
+					l[num-1] = v
+					return n
+				})
+				s.Labels = append(s.Labels, l)
+			case 2:
+				var sample remoteSample
+				fields(msg, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					if num == 1 {
+						v, n := protowire.ConsumeFixed64(b)
+						sample.value = math.Float64frombits(v)
+						return n
+					}
+					v, n := protowire.ConsumeVarint(b)
+					sample.tsMs = int64(v)
+					return n
+				})
+				s.Samples = append(s.Samples, sample)
+			default:
+				t.Fatalf("unexpected TimeSeries field %d", num)
+			}
+			return n
+		})
+		series = append(series, s)
+		return n
+	})
+	return series
+}
+
+const remoteTestSamples = `ovms_S_ms_v_bat_soc{vehicle="A"} 81 1700000060000
+ovms_S_ms_v_bat_soc{vehicle="A"} 80 1700000000000
+ovms_S_ms_v_bat_soc{vehicle="B"} 50.5 1700000000000
+ovms_S_ms_v_charge_state{vehicle="A",value="charging"} 1 1700000000000
+`
+
+func TestParseRemoteSeries(t *testing.T) {
+	series, err := parseRemoteSeries(remoteTestSamples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string][]remoteSample{}
+	for _, s := range series {
+		var key string
+		for i, l := range s.labels {
+			if i > 0 && s.labels[i-1].GetName() > l.GetName() {
+				t.Errorf("labels not sorted: %v", s.labels)
+			}
+			key += l.GetName() + "=" + l.GetValue() + ","
+		}
+		got[key] = s.samples
+	}
+	want := map[string][]remoteSample{
+		"__name__=ovms_S_ms_v_bat_soc,vehicle=A,":                     {{80, 1700000000000}, {81, 1700000060000}},
+		"__name__=ovms_S_ms_v_bat_soc,vehicle=B,":                     {{50.5, 1700000000000}},
+		"__name__=ovms_S_ms_v_charge_state,value=charging,vehicle=A,": {{1, 1700000000000}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRemoteSeries = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	series, err := parseRemoteSeries(remoteTestSamples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := decodeWriteRequest(t, encodeWriteRequest(series))
+	if len(got) != len(series) {
+		t.Fatalf("decoded %d series, want %d", len(got), len(series))
+	}
+	for i, s := range series {
+		var labels [][2]string
+		for _, l := range s.labels {
+			labels = append(labels, [2]string{l.GetName(), l.GetValue()})
+		}
+		want := decodedSeries{labels, s.samples}
+		if !reflect.DeepEqual(got[i], want) {
+			t.Errorf("series %d = %+v, want %+v", i, got[i], want)
+		}
+	}
+}
+
+func TestRemoteWrite(t *testing.T) {
+	series, err := parseRemoteSeries(remoteTestSamples)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	if err := remoteWrite(srv.URL, series); err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{
+		"Content-Type":                      "application/x-protobuf",
+		"Content-Encoding":                  "snappy",
+		"X-Prometheus-Remote-Write-Version": "0.1.0",
+	} {
+		if got := header.Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+	if got := decodeWriteRequest(t, decodeSnappy(t, body)); len(got) != len(series) {
+		t.Errorf("received %d series, want %d", len(got), len(series))
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	})
+	if err := remoteWrite(srv.URL, series); err == nil {
+		t.Error("remoteWrite succeeded on a 400 response")
+	}
+}
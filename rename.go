@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// renameRule renames an exported metric and optionally transforms its value
+// to value*scale+offset. From is the metric name before -metric-prefix is
+// applied; To is used as is.
+type renameRule struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	Scale  *float64 `json:"scale,omitempty"`
+	Offset float64  `json:"offset,omitempty"`
+}
+
+func (r *renameRule) validate() error {
+	if r.From == "" {
+		return fmt.Errorf("rename without from")
+	}
+	if r.To == "" {
+		r.To = r.From
+	}
+	if !labelNameRE.MatchString(r.To) {
+		return fmt.Errorf("rename %q: invalid metric name %q", r.From, r.To)
+	}
+	return nil
+}
+
+func (r *renameRule) transforms() bool {
+	return r.Scale != nil || r.Offset != 0
+}
+
+func (r *renameRule) apply(v float64) float64 {
+	if r.Scale != nil {
+		v *= *r.Scale
+	}
+	return v + r.Offset
+}
+
+// applyText transforms a value in the text format. Non-numeric values are
+// returned unchanged.
+func (r *renameRule) applyText(val string) string {
+	v, err := strconv.ParseFloat(val, 64)
+	if err != nil {
+		return val
+	}
+	return strconv.FormatFloat(r.apply(v), 'f', -1, 64)
+}
+
+// renameRule returns the rule for the given metric name, nil if none.
+func (c *config) renameRule(name string) *renameRule {
+	return c.renames[name]
+}
+
+// applyRename transforms the values of a gathered metric family. Histograms
+// and summaries are only renamed.
+func applyRename(mf *dto.MetricFamily, r *renameRule) {
+	mf.Name = proto.String(r.To)
+	if !r.transforms() {
+		return
+	}
+	for _, m := range mf.Metric {
+		switch {
+		case m.Gauge != nil:
+			m.Gauge.Value = proto.Float64(r.apply(m.Gauge.GetValue()))
+		case m.Counter != nil:
+			m.Counter.Value = proto.Float64(r.apply(m.Counter.GetValue()))
+		case m.Untyped != nil:
+			m.Untyped.Value = proto.Float64(r.apply(m.Untyped.GetValue()))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var replayFlag = flag.String("replay-file", "", "Saved OVMS server response, or directory of responses replayed in name order one per poll, read instead of the server")
+
+// replayer serves the saved responses of each vehicle in order, the last one
+// being repeated once all were replayed.
+type replayer struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+var replay = &replayer{next: map[string]int{}}
+
+// files returns the responses of a vehicle: the file of -replay-file, or the
+// files of its <vehicle> subdirectory if any, of the directory otherwise.
+func (r *replayer) files(vehicleID string) ([]string, error) {
+	fi, err := os.Stat(*replayFlag)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{*replayFlag}, nil
+	}
+	dir := *replayFlag
+	if fi, err := os.Stat(filepath.Join(dir, vehicleID)); err == nil && fi.IsDir() {
+		dir = filepath.Join(dir, vehicleID)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no response to replay in %s", dir)
+	}
+	return files, nil
+}
+
+// response returns the next saved response of a vehicle as an HTTP response.
+func (r *replayer) response(vehicleID string) (*http.Response, error) {
+	files, err := r.files(vehicleID)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	i := min(r.next[vehicleID], len(files)-1)
+	r.next[vehicleID] = i + 1
+	r.mu.Unlock()
+
+	f, err := os.Open(files[i])
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       f,
+	}, nil
+}
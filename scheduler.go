@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	scheduledRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_scheduled_command_runs_total",
+		Help: "Number of scheduled command runs by result.",
+	}, []string{"vehicle", "name", "result"})
+	scheduledLastRun = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_scheduled_command_last_run_timestamp_seconds",
+		Help: "Time of the last run of a scheduled command.",
+	}, []string{"vehicle", "name"})
+	scheduledLastSuccess = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_scheduled_command_last_success",
+		Help: "Whether the last run of a scheduled command succeeded.",
+	}, []string{"vehicle", "name"})
+	scheduledValue = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_scheduled_command_value",
+		Help: "Value extracted from the response of the last successful run of a scheduled command by the value regexp of its schedule.",
+	}, []string{"vehicle", "name"})
+)
+
+// scheduleCommands are the stream commands only the schedules may send, not
+// the command endpoint: module-command runs any line of the module shell,
+// e.g. "stat" or "vehicle 12v test", which is left to the config.
+var scheduleCommands = map[string]streamCommand{
+	"module-command": {code: "7", params: []string{"text"}, text: true},
+}
+
+// schedule runs a vehicle command cron-style, on all vehicles unless
+// Vehicle is set.
+type schedule struct {
+	Name    string            `json:"name"`
+	Cron    string            `json:"cron"`
+	Vehicle string            `json:"vehicle,omitempty"`
+	Command string            `json:"command"`
+	Params  map[string]string `json:"params,omitempty"`
+	// Value is a regexp whose first group extracts a number from the
+	// response, e.g. "([0-9.]+) ?V" for the 12V test, exported as
+	// ovms_scheduled_command_value.
+	Value string `json:"value,omitempty"`
+
+	cron  *cronSchedule
+	value *regexp.Regexp
+}
+
+func (s *schedule) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("schedule without a name")
+	}
+	params, ok := commandParams(s.Command)
+	if sc, found := scheduleCommands[s.Command]; found {
+		params, ok = sc.params, true
+	}
+	if !ok {
+		return fmt.Errorf("schedule %q: unknown command %q", s.Name, s.Command)
+	}
+	for k := range s.Params {
+		if !contains(params, k) {
+			return fmt.Errorf("schedule %q: command %q does not take the %s parameter", s.Name, s.Command, k)
+		}
+	}
+	c, err := parseCron(s.Cron)
+	if err != nil {
+		return fmt.Errorf("schedule %q: %v", s.Name, err)
+	}
+	s.cron = c
+	if s.Value != "" {
+		if s.value, err = regexp.Compile(s.Value); err != nil {
+			return fmt.Errorf("schedule %q: invalid value: %v", s.Name, err)
+		}
+		if s.value.NumSubexp() < 1 {
+			return fmt.Errorf("schedule %q: value %q without a group", s.Name, s.Value)
+		}
+	}
+	return nil
+}
+
+// runScheduler checks the configured schedules at the start of every minute.
+func runScheduler() {
+	for {
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		time.Sleep(next.Sub(now))
+		for _, s := range cfg().Schedules {
+			if !s.cron.matches(next) {
+				continue
+			}
+			for _, v := range vehicles {
+				if s.Vehicle == "" || s.Vehicle == v.id {
+					go runScheduled(s, v.id, next)
+				}
+			}
+		}
+	}
+}
+
+func runScheduled(s *schedule, vehicle string, t time.Time) {
+	slog.Info("running scheduled command", "schedule", s.Name, "vehicle", vehicle, "command", s.Command)
+	scheduledLastRun.WithLabelValues(vehicle, s.Name).Set(float64(t.Unix()))
+	var resp string
+	var err error
+	if sc, ok := scheduleCommands[s.Command]; ok {
+		resp, err = sendStreamCommand(vehicle, s.Command, sc, s.Params)
+	} else {
+		resp, err = sendCommand(vehicle, s.Command, s.Params)
+	}
+	if err != nil {
+		slog.Error("scheduled command failed", "schedule", s.Name, "vehicle", vehicle, "err", err, "response", resp)
+		scheduledRuns.WithLabelValues(vehicle, s.Name, "error").Inc()
+		scheduledLastSuccess.WithLabelValues(vehicle, s.Name).Set(0)
+		return
+	}
+	slog.Debug("scheduled command done", "schedule", s.Name, "vehicle", vehicle, "response", resp)
+	scheduledRuns.WithLabelValues(vehicle, s.Name, "success").Inc()
+	scheduledLastSuccess.WithLabelValues(vehicle, s.Name).Set(1)
+	if s.value == nil {
+		return
+	}
+	m := s.value.FindStringSubmatch(resp)
+	if m == nil {
+		slog.Warn("no value in the response of the scheduled command", "schedule", s.Name, "vehicle", vehicle, "response", resp)
+		return
+	}
+	val, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		slog.Warn("invalid value in the response of the scheduled command", "schedule", s.Name, "vehicle", vehicle, "value", m[1])
+		return
+	}
+	scheduledValue.WithLabelValues(vehicle, s.Name).Set(val)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var serverInUse = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_server_in_use",
+	Help: "OVMS server the requests are sent to, among -server or the server of a vehicle of the config.",
+}, []string{"server"})
+
+// serverFailback is how long the requests stay on a fallback server before
+// the primary is tried again.
+const serverFailback = 10 * time.Minute
+
+// serverPool fails over between the servers of -server, or of the server of
+// a vehicle of the config, the first being the primary.
+type serverPool struct {
+	// list are the comma-separated addresses, -server if empty.
+	list string
+
+	mu      sync.Mutex
+	current int
+	// since is when the current server was switched to.
+	since time.Time
+}
+
+var servers serverPool
+
+// vehicleServers are the pools of the servers of the vehicles of the config,
+// by list.
+var vehicleServers = struct {
+	mu    sync.Mutex
+	pools map[string]*serverPool
+}{pools: map[string]*serverPool{}}
+
+// splitServers returns the addresses of a comma-separated list.
+func splitServers(list string) []string {
+	var addrs []string
+	for _, a := range strings.Split(list, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// serverAddrs returns the addresses of -server.
+func serverAddrs() []string {
+	return splitServers(*ovmsSeverFlag)
+}
+
+// addrs returns the addresses of the pool.
+func (p *serverPool) addrs() []string {
+	if p.list == "" {
+		return serverAddrs()
+	}
+	return splitServers(p.list)
+}
+
+// vehicleServer returns the pool of the servers of a vehicle: its server in
+// the config if any, otherwise -server.
+func vehicleServer(id string) *serverPool {
+	vc := cfg().vehicleConfig(id)
+	if vc == nil || vc.Server == "" {
+		return &servers
+	}
+	vehicleServers.mu.Lock()
+	defer vehicleServers.mu.Unlock()
+	p := vehicleServers.pools[vc.Server]
+	if p == nil {
+		p = &serverPool{list: vc.Server}
+		vehicleServers.pools[vc.Server] = p
+	}
+	return p
+}
+
+func checkServers() error {
+	if len(serverAddrs()) == 0 {
+		return fmt.Errorf("no -server")
+	}
+	return nil
+}
+
+// currentServer returns the address of the server of -server in use.
+func currentServer() string {
+	return servers.currentAddr()
+}
+
+// currentAddr returns the address of the server of the pool in use.
+func (p *serverPool) currentAddr() string {
+	addrs := p.addrs()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return addrs[min(p.current, len(addrs)-1)]
+}
+
+// order returns the indexes of the servers in the order to try them: the
+// current one first, except for the primary once serverFailback elapsed.
+func (p *serverPool) order(n int, now time.Time) []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	start := p.current
+	if start >= n || start != 0 && now.Sub(p.since) >= serverFailback {
+		start = 0
+	}
+	order := make([]int, n)
+	for i := range order {
+		order[i] = (start + i) % n
+	}
+	return order
+}
+
+// use records that the server i answered, first being the server tried
+// first.
+func (p *serverPool) use(i, first int, addrs []string, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if i == p.current && i == first && !p.since.IsZero() {
+		return
+	}
+	// Also when the primary failed again: wait before trying it again.
+	if !p.since.IsZero() && i != p.current {
+		slog.Warn("switching OVMS server", "from", addrs[min(p.current, len(addrs)-1)], "to", addrs[i])
+	}
+	if !p.since.IsZero() {
+		serverInUse.DeleteLabelValues(addrs[min(p.current, len(addrs)-1)])
+	}
+	p.current = i
+	p.since = now
+	serverInUse.WithLabelValues(addrs[i]).Set(1)
+}
+
+// serverFailed reports whether the server should be failed over: it could
+// not be reached or it answered with a server error. A throttled request is
+// not retried elsewhere, the back off applies to all the servers.
+func serverFailed(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, errThrottled)
+	}
+	return resp.StatusCode >= 500
+}
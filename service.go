@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Defaults for the service reminder thresholds.
+const (
+	defaultServiceWarnKm   = 1000
+	defaultServiceWarnDays = 30
+)
+
+var (
+	serviceKmRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_service_due_km_remaining",
+		Help: "Distance left until the service is due, negative when overdue.",
+	}, []string{"vehicle", "service"})
+	serviceDaysRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_service_due_days_remaining",
+		Help: "Days left until the service is due, negative when overdue.",
+	}, []string{"vehicle", "service"})
+)
+
+// service is a maintenance interval. It is due after IntervalKm or
+// IntervalMonths, whichever comes first, counted from the last service.
+type service struct {
+	Name            string  `json:"name"`
+	Vehicle         string  `json:"vehicle,omitempty"`
+	IntervalKm      float64 `json:"interval_km,omitempty"`
+	IntervalMonths  int     `json:"interval_months,omitempty"`
+	LastServiceKm   float64 `json:"last_service_km,omitempty"`
+	LastServiceDate string  `json:"last_service_date,omitempty"`
+	WarnKm          float64 `json:"warn_km,omitempty"`
+	WarnDays        float64 `json:"warn_days,omitempty"`
+
+	lastDate time.Time
+}
+
+func (s *service) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("service without a name")
+	}
+	if s.IntervalKm <= 0 && s.IntervalMonths <= 0 {
+		return fmt.Errorf("service %q: interval_km or interval_months is required", s.Name)
+	}
+	if s.IntervalMonths > 0 {
+		t, err := time.ParseInLocation("2006-01-02", s.LastServiceDate, time.Local)
+		if err != nil {
+			return fmt.Errorf("service %q: invalid last_service_date: %v", s.Name, err)
+		}
+		s.lastDate = t
+	}
+	if s.WarnKm == 0 {
+		s.WarnKm = defaultServiceWarnKm
+	}
+	if s.WarnDays == 0 {
+		s.WarnDays = defaultServiceWarnDays
+	}
+	return nil
+}
+
+// serviceTracker exports the service reminders of a vehicle.
+type serviceTracker struct {
+	vehicle string
+
+	mu sync.Mutex
+	// notified records the services already reported as approaching.
+	notified map[string]bool
+}
+
+// update is called with the latest odometer reading, 0 if unknown.
+func (t *serviceTracker) update(odometerKm float64, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.notified == nil {
+		t.notified = map[string]bool{}
+	}
+
+	for _, s := range cfg().Services {
+		if s.Vehicle != "" && s.Vehicle != t.vehicle {
+			continue
+		}
+		due := false
+		km, days := math.Inf(1), math.Inf(1)
+		if s.IntervalKm > 0 && odometerKm > 0 {
+			km = s.LastServiceKm + s.IntervalKm - odometerKm
+			serviceKmRemaining.WithLabelValues(t.vehicle, s.Name).Set(km)
+			due = due || km <= s.WarnKm
+		}
+		if s.IntervalMonths > 0 {
+			days = s.lastDate.AddDate(0, s.IntervalMonths, 0).Sub(now).Hours() / 24
+			serviceDaysRemaining.WithLabelValues(t.vehicle, s.Name).Set(days)
+			due = due || days <= s.WarnDays
+		}
+
+		if due && !t.notified[s.Name] {
+			notify("%s: service %q is due in %s", t.vehicle, s.Name, formatDue(km, days))
+		}
+		t.notified[s.Name] = due
+	}
+}
+
+func formatDue(km, days float64) string {
+	switch {
+	case math.IsInf(days, 1):
+		return fmt.Sprintf("%.0f km", km)
+	case math.IsInf(km, 1):
+		return fmt.Sprintf("%.0f days", days)
+	}
+	return fmt.Sprintf("%.0f km or %.0f days", km, days)
+}
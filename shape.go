@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// writeShapedJSON writes v as the JSON response, shaped by the query
+// parameters for the Grafana Infinity and JSON datasources:
+//   - flatten=true turns the nested objects into dotted keys;
+//   - fields=a,b keeps only these keys of the objects, of the top level
+//     object or of the elements of the top level array;
+//   - time_format=unix|unix_ms|rfc3339 formats the timestamps.
+func writeShapedJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	q := r.URL.Query()
+	if q.Get("flatten") == "" && q.Get("fields") == "" && q.Get("time_format") == "" {
+		writeJSON(w, v)
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch tf := q.Get("time_format"); tf {
+	case "", "rfc3339":
+	case "unix", "unix_ms":
+		doc = formatTimes(doc, tf)
+	default:
+		http.Error(w, "time_format must be unix, unix_ms or rfc3339", http.StatusBadRequest)
+		return
+	}
+	if flatten := q.Get("flatten"); flatten == "1" || flatten == "true" {
+		doc = flattenJSON(doc)
+	}
+	if fields := q.Get("fields"); fields != "" {
+		keep := map[string]bool{}
+		for _, f := range strings.Split(fields, ",") {
+			keep[strings.TrimSpace(f)] = true
+		}
+		doc = selectFields(doc, keep)
+	}
+	writeJSON(w, doc)
+}
+
+// formatTimes replaces the RFC 3339 timestamps with Unix times.
+func formatTimes(v interface{}, format string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = formatTimes(e, format)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = formatTimes(e, format)
+		}
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return v
+		}
+		if format == "unix_ms" {
+			return t.UnixMilli()
+		}
+		return t.Unix()
+	}
+	return v
+}
+
+// flattenJSON turns the nested objects into dotted keys. The arrays are
+// flattened element by element.
+func flattenJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := map[string]interface{}{}
+		flattenInto(out, "", v)
+		return out
+	case []interface{}:
+		for i, e := range v {
+			v[i] = flattenJSON(e)
+		}
+	}
+	return v
+}
+
+func flattenInto(out map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, e := range m {
+		if nested, ok := e.(map[string]interface{}); ok {
+			flattenInto(out, prefix+k+".", nested)
+			continue
+		}
+		out[prefix+k] = flattenJSON(e)
+	}
+}
+
+// selectFields keeps only the given keys of the top level object, or of the
+// objects of the top level array.
+func selectFields(v interface{}, keep map[string]bool) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			if !keep[k] {
+				delete(v, k)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = selectFields(e, keep)
+		}
+	}
+	return v
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const simulateUsage = `usage: ovms_exporter [flags] simulate [-addr host:port] [-speed n] [-set code.field=value ...]
+
+Runs a fake OVMS server serving /api/protocol/<vehicle> with synthesized S, D,
+L and Y records, and the F record of -vehicle-type, for building dashboards
+and alerts without a vehicle. Every vehicle ID is accepted and any
+credentials are. Each vehicle repeats a cycle
+of 6 simulated hours: parked, a 60 km round trip, parked again, then charging
+back to 80% SOC. The pressure of the rear right tire drifts down and is
+reinflated every 3 simulated days. /api/historical/<vehicle>/<type> serves
+the extended battery records, RT-BAT-C and RT-BAT-M, of 14 cells, one of
+them weaker, in 7 modules.
+
+Flags:`
+
+// The simulated cycle, in simulated time.
+const (
+	simCycle         = 6 * time.Hour
+	simDriveStart    = 1 * time.Hour
+	simDriveDuration = 45 * time.Minute
+	simChargeStart   = 3 * time.Hour
+	// simTireCycle is the period after which the leaking tire is reinflated.
+	simTireCycle = 72 * time.Hour
+)
+
+// The simulated vehicle.
+const (
+	simCapacityKWh  = 64
+	simWhPerKm      = 165
+	simTripKm       = 60
+	simSOCFull      = 80
+	simOdometerKm   = 12000
+	simChargeKW     = 7.4
+	simTaperKW      = 2
+	simTaperSOC     = 70
+	simTirekPa      = 250
+	simTireLeakkPaH = 0.25
+	simTireWarmkPa  = 6
+	simRangeKmPerPc = 3.9
+)
+
+// simOverrides are the -set fields of the simulate command, by record code
+// then field index.
+type simOverrides map[string]map[int]string
+
+func (o simOverrides) String() string { return "" }
+
+func (o simOverrides) Set(s string) error {
+	name, val, ok := strings.Cut(s, "=")
+	code, field, ok2 := strings.Cut(name, ".")
+	if !ok || !ok2 {
+		return fmt.Errorf("expected code.field=value, got %q", s)
+	}
+	for i, f := range metricsMap[code] {
+		if f == field {
+			if o[code] == nil {
+				o[code] = map[int]string{}
+			}
+			o[code][i] = val
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown field %q", name)
+}
+
+// simulator synthesizes the records of the vehicles.
+type simulator struct {
+	start       time.Time
+	speed       float64
+	lat, lon    float64
+	vehicleType string
+	overrides   simOverrides
+}
+
+// simState is the state of a vehicle at one point of the cycle.
+type simState struct {
+	soc          float64
+	charge       string
+	plugged      bool
+	chargeKW     float64
+	chargeKWh    float64
+	speed        float64
+	tripKm       float64
+	odometerKm   float64
+	energyUsed   float64
+	parktime     float64
+	lat, lon     float64
+	direction    float64
+	envTemp      float64
+	batTemp      float64
+	tirePressure [4]float64
+}
+
+// elapsed returns the simulated time elapsed for a vehicle. The vehicles
+// start at different points of the cycle.
+func (s *simulator) elapsed(vehicleID string, now time.Time) time.Duration {
+	h := fnv.New32a()
+	h.Write([]byte(vehicleID))
+	offset := time.Duration(h.Sum32()%uint32(simCycle/time.Second)) * time.Second
+	return time.Duration(float64(now.Sub(s.start))*s.speed) + offset
+}
+
+// state returns the state of a vehicle after the elapsed simulated time.
+func (s *simulator) state(elapsed time.Duration) simState {
+	cycles := int(elapsed / simCycle)
+	t := elapsed % simCycle
+	st := simState{
+		soc:        simSOCFull,
+		charge:     "stopped",
+		odometerKm: simOdometerKm + float64(cycles)*simTripKm,
+		lat:        s.lat,
+		lon:        s.lon,
+		parktime:   t.Seconds(),
+	}
+
+	// The trip: out and back at about 80 km/h.
+	switch {
+	case t >= simDriveStart && t < simDriveStart+simDriveDuration:
+		f := float64(t-simDriveStart) / float64(simDriveDuration)
+		avg := simTripKm / simDriveDuration.Hours()
+		st.speed = avg * (1 + 0.2*math.Sin(8*math.Pi*f))
+		st.tripKm = simTripKm * (f + 0.2*(1-math.Cos(8*math.Pi*f))/(8*math.Pi))
+		st.parktime = 0
+	case t >= simDriveStart+simDriveDuration:
+		st.tripKm = simTripKm
+		st.parktime = (t - simDriveStart - simDriveDuration).Seconds()
+	}
+	st.odometerKm += st.tripKm
+	st.energyUsed = st.tripKm * simWhPerKm / 1000
+	st.soc -= st.energyUsed / simCapacityKWh * 100
+	away := st.tripKm
+	st.direction = 90
+	if away > simTripKm/2 {
+		away = simTripKm - away
+		st.direction = 270
+	}
+	st.lon += away / (111.32 * math.Cos(s.lat*math.Pi/180))
+
+	// The charge: constant power, tapering above simTaperSOC.
+	if t >= simChargeStart {
+		st.plugged = true
+		st.charge = "charging"
+		for m := time.Duration(0); m < t-simChargeStart; m += time.Minute {
+			if st.soc >= simSOCFull {
+				st.soc = simSOCFull
+				st.charge = "done"
+				break
+			}
+			st.chargeKW = simChargeKW
+			if st.soc > simTaperSOC {
+				st.chargeKW -= (st.soc - simTaperSOC) / (simSOCFull - simTaperSOC) * (simChargeKW - simTaperKW)
+			}
+			st.chargeKWh += st.chargeKW / 60
+			st.soc += st.chargeKW / 60 * 0.92 / simCapacityKWh * 100
+		}
+		if st.charge == "done" {
+			st.chargeKW = 0
+		}
+	}
+
+	hour := float64((elapsed+time.Duration(s.start.Hour())*time.Hour)%(24*time.Hour)) / float64(time.Hour)
+	st.envTemp = 12 + 6*math.Sin(2*math.Pi*(hour-9)/24)
+	st.batTemp = st.envTemp + 3
+	switch {
+	case st.speed > 0:
+		st.batTemp += 8
+	case st.charge == "charging":
+		st.batTemp += 10
+	}
+
+	leak := float64(elapsed%simTireCycle) / float64(time.Hour) * simTireLeakkPaH
+	for i := range st.tirePressure {
+		st.tirePressure[i] = simTirekPa
+		if st.speed > 0 {
+			st.tirePressure[i] += simTireWarmkPa * float64(t-simDriveStart) / float64(simDriveDuration)
+		}
+	}
+	st.tirePressure[3] -= leak
+	return st
+}
+
+// simChargeStateKeys are the numeric keys of the charge states.
+var simChargeStateKeys = map[string]string{"charging": "1", "done": "4", "stopped": "21"}
+
+// simFloat formats a simulated value with the given number of decimals.
+func simFloat(v float64, prec int) string {
+	return strconv.FormatFloat(v, 'f', prec, 64)
+}
+
+// simMsg returns the fields of a record in the order of names. Unset fields
+// are 0.
+func simMsg(names []string, vals map[string]string) []string {
+	msg := make([]string, len(names))
+	for i, name := range names {
+		msg[i] = "0"
+		if v, ok := vals[name]; ok {
+			msg[i] = v
+		}
+	}
+	return msg
+}
+
+// records returns the records of a vehicle in the wire encoding.
+func (s *simulator) records(vehicleID string, now time.Time) []record {
+	st := s.state(s.elapsed(vehicleID, now))
+
+	// ms_v_bat_power is negative while charging, S sends it negated.
+	power, chargePower := st.speed*simWhPerKm/1000, 0.0
+	if st.charge == "charging" {
+		power, chargePower = -st.chargeKW, st.chargeKW
+	}
+	var doors1 int64
+	if st.plugged {
+		doors1 |= doors1ChargePort | doors1Pilot
+	}
+	if st.charge == "charging" {
+		doors1 |= doors1Charging
+	}
+
+	msgs := map[string][]string{
+		"S": simMsg(sMetrics, map[string]string{
+			"ms_v_bat_soc":              simFloat(st.soc, 1),
+			"m_units_distance":          "K",
+			"ms_v_charge_voltage":       "230",
+			"ms_v_charge_current":       simFloat(st.chargeKW*1000/230, 1),
+			"ms_v_charge_state":         st.charge,
+			"ms_v_charge_mode":          "standard",
+			"ms_v_bat_range_ideal":      simFloat(st.soc*simRangeKmPerPc*1.1, 0),
+			"ms_v_bat_range_est":        simFloat(st.soc*simRangeKmPerPc, 0),
+			"ms_v_charge_climit":        "32",
+			"ms_v_charge_kwh":           simFloat(st.chargeKWh*10, 0),
+			"ms_v_charge_timermode":     "no",
+			"ms_v_bat_cac":              "180.0",
+			"ms_v_charge_limit_soc":     simFloat(simSOCFull, 0),
+			"ms_v_env_cooling":          "-1",
+			"ms_v_bat_range_full":       simFloat(100*simRangeKmPerPc, 0),
+			"ms_v_bat_power":            simFloat(chargePower, 3),
+			"ms_v_bat_voltage":          simFloat(340+st.soc*0.6, 1),
+			"ms_v_bat_soh":              "97",
+			"ms_v_charge_power":         simFloat(st.chargeKW, 3),
+			"ms_v_charge_efficiency":    "92",
+			"ms_v_bat_current":          simFloat(power*1000/(340+st.soc*0.6), 1),
+			"ms_v_charge_duration_full": "-1",
+		}),
+		"D": simMsg(dMetrics, map[string]string{
+			"doors1":                   strconv.FormatInt(doors1, 10),
+			"ms_v_env_locked":          "4",
+			"ms_v_inv_temp":            simFloat(st.batTemp+5, 1),
+			"ms_v_mot_temp":            simFloat(st.batTemp+10, 1),
+			"ms_v_bat_temp":            simFloat(st.batTemp, 1),
+			"ms_v_pos_trip":            simFloat(st.tripKm*10, 0),
+			"ms_v_pos_odometer":        simFloat(st.odometerKm*10, 0),
+			"ms_v_pos_speed":           simFloat(st.speed, 0),
+			"ms_v_env_parktime":        simFloat(st.parktime, 0),
+			"ms_v_env_temp":            simFloat(st.envTemp, 1),
+			"stale_temps":              "1",
+			"ms_v_env_temp_indicator":  "1",
+			"ms_v_bat_12v_voltage":     "12.8",
+			"ms_v_bat_12v_voltage_ref": "12.6",
+			"ms_v_charge_temp":         simFloat(st.envTemp+3, 1),
+			"ms_v_env_cabintemp":       simFloat(st.envTemp+4, 1),
+		}),
+		"L": simMsg(lMetrics, map[string]string{
+			"ms_v_pos_latitude":    simFloat(st.lat, 6),
+			"ms_v_pos_longitude":   simFloat(st.lon, 6),
+			"ms_v_pos_direction":   simFloat(st.direction, 0),
+			"ms_v_pos_altitude":    "100",
+			"ms_v_pos_gpslock":     "yes",
+			"stale":                "1",
+			"ms_v_pos_speed":       simFloat(st.speed, 1),
+			"ms_v_pos_trip":        simFloat(st.tripKm*10, 0),
+			"ms_v_bat_power":       simFloat(power, 3),
+			"ms_v_bat_energy_used": simFloat(st.energyUsed, 3),
+			"ms_v_pos_gpsmode":     "A",
+			"ms_v_pos_satcount":    "9",
+			"ms_v_pos_gpshdop":     "1.1",
+			"ms_v_pos_gpsspeed":    simFloat(st.speed, 1),
+			"ms_v_pos_gpssq":       "80",
+		}),
+		"Y": simMsg(yMetrics, map[string]string{
+			"wheels_count":             "4",
+			"wheel1":                   "FL",
+			"wheel2":                   "FR",
+			"wheel3":                   "RL",
+			"wheel4":                   "RR",
+			"ms_v_tpms_pressure_count": "4",
+			"ms_v_tpms_pressure_whee1": simFloat(st.tirePressure[0], 1),
+			"ms_v_tpms_pressure_whee2": simFloat(st.tirePressure[1], 1),
+			"ms_v_tpms_pressure_whee3": simFloat(st.tirePressure[2], 1),
+			"ms_v_tpms_pressure_whee4": simFloat(st.tirePressure[3], 1),
+			"defstale_pressure":        "1",
+			"defstale_temp":            "-1",
+			"defstale_health":          "-1",
+			"defstale_alert":           "-1",
+		}),
+	}
+	// The second charge state and mode fields are numeric keys.
+	msgs["S"][13] = simChargeStateKeys[st.charge]
+	msgs["S"][14] = "0"
+
+	msgTime := now.UTC().Format("2006-01-02 15:04:05")
+	var recs []record
+	for _, code := range []string{"S", "D", "L", "Y"} {
+		msg := msgs[code]
+		for i, val := range s.overrides[code] {
+			msg[i] = val
+		}
+		recs = append(recs, record{Code: code, Msg: strings.Join(msg, ","), MsgTime: msgTime})
+	}
+	if s.vehicleType != "" {
+		recs = append(recs, record{Code: firmwareCode, Msg: "3.3.004-sim,,0,1," + s.vehicleType + ",,0,0", MsgTime: msgTime})
+	}
+	return recs
+}
+
+func (s *simulator) handleProtocol(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/protocol/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.records(id, time.Now()))
+}
+
+// The simulated battery, of 14 cells in 7 modules, one cell being weaker.
+const (
+	simCells     = 14
+	simModules   = 7
+	simWeakCell  = 9
+	simWeakCellV = 0.04
+)
+
+// historical returns the extended battery records of a vehicle, RT-BAT-C or
+// RT-BAT-M, one per cell or module.
+func (s *simulator) historical(vehicleID, recordType string, now time.Time) []historicalRecord {
+	st := s.state(s.elapsed(vehicleID, now))
+	var recs []historicalRecord
+	add := func(number int, act, dev float64, prec int) {
+		recs = append(recs, historicalRecord{
+			Timestamp:    now.UTC().Format(msgTimeLayout),
+			RecordNumber: json.Number(strconv.Itoa(number)),
+			Data:         strings.Join([]string{simFloat(act, prec), simFloat(act-dev, prec), simFloat(act+dev, prec), simFloat(dev, prec), "0"}, ","),
+		})
+	}
+	switch recordType {
+	case cellVoltageRecord:
+		volt := 3.4 + 0.8*st.soc/100
+		for i := 1; i <= simCells; i++ {
+			act := volt + 0.002*float64(i%3)
+			if i == simWeakCell {
+				act -= simWeakCellV
+			}
+			add(i, act, 0.005, 3)
+		}
+	case moduleTemperatureRecord:
+		for i := 1; i <= simModules; i++ {
+			add(i, st.batTemp+0.5*float64(i%4), 0.5, 1)
+		}
+	}
+	return recs
+}
+
+// handleHistorical serves /api/historical/<vehicle>/<type>.
+func (s *simulator) handleHistorical(w http.ResponseWriter, r *http.Request) {
+	id, recordType, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/historical/"), "/")
+	if !ok || id == "" || recordType == "" || strings.Contains(recordType, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	recs := s.historical(id, recordType, time.Now())
+	if recs == nil {
+		recs = []historicalRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
+// simulateCommand returns the flags of the simulate command and its function.
+func simulateCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:6868", "Address on which the simulated server listens")
+	speed := fs.Float64("speed", 60, "Simulated seconds per second")
+	lat := fs.Float64("lat", 52.520008, "Latitude where the vehicles park")
+	lon := fs.Float64("lon", 13.404954, "Longitude where the vehicles park")
+	vehicleType := fs.String("vehicle-type", "RT", "OVMS vehicle type code sent in the F record, selecting the decoder of the vendor-specific records; empty sends no F record")
+	s := &simulator{overrides: simOverrides{}}
+	fs.Var(s.overrides, "set", "Field set to a fixed wire value, as code.field=value, e.g. S.ms_v_bat_soh=90; repeatable")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), simulateUsage)
+		fs.PrintDefaults()
+	}
+	return fs, func(args []string) error {
+		if len(args) > 0 || *speed <= 0 {
+			fs.Usage()
+			return fmt.Errorf("invalid arguments")
+		}
+		s.start, s.speed, s.lat, s.lon, s.vehicleType = time.Now(), *speed, *lat, *lon, *vehicleType
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/protocol/", s.handleProtocol)
+		mux.HandleFunc("/api/historical/", s.handleHistorical)
+		slog.Info("simulating an OVMS server", "addr", *addr, "speed", *speed)
+		return http.ListenAndServe(*addr, middleware(mux))
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+)
+
+var stateDirFlag = flag.String("state-dir", "", "Directory where state is persisted across restarts; empty disables persistence")
+
+// readState loads the named state file into v. A missing file is not an error.
+func readState(name string, v interface{}) error {
+	if *stateDirFlag == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(*stateDirFlag, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeState atomically replaces the named state file with v.
+func writeState(name string, v interface{}) error {
+	if *stateDirFlag == "" {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*stateDirFlag, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(*stateDirFlag, name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if fsyncEnabled {
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(*stateDirFlag, name))
+}
+
+// removeState deletes the named state file.
+func removeState(name string) error {
+	if *stateDirFlag == "" {
+		return nil
+	}
+	err := os.Remove(filepath.Join(*stateDirFlag, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
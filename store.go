@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// parsedRecord is the latest record of a code: its time, parsed fields and
+// samples. It is not modified once stored.
+type parsedRecord struct {
+	ts      time.Time
+	fields  map[string]string
+	samples []string
+}
+
+// recordStore holds the latest parsed record of each code of a vehicle. The
+// records are replaced one code at a time, so a record missing from a
+// response or failing to parse keeps the previous one of its code. The
+// metrics, the JSON API, the UI and the public page all read from it.
+type recordStore struct {
+	mu sync.Mutex
+	// codes are the codes in the order they were first received.
+	codes   []string
+	records map[string]parsedRecord
+	// last is the time of the latest record, including the unknown codes.
+	last time.Time
+}
+
+// seen records the time of a record, if newer than the latest.
+func (s *recordStore) seen(ts time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ts.After(s.last) {
+		s.last = ts
+	}
+}
+
+// set stores the record of a code, unless the stored one is newer.
+func (s *recordStore) set(code string, rec parsedRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = map[string]parsedRecord{}
+	}
+	old, ok := s.records[code]
+	if !ok {
+		s.codes = append(s.codes, code)
+	} else if old.ts.After(rec.ts) {
+		return
+	}
+	s.records[code] = rec
+}
+
+// get returns the record of a code.
+func (s *recordStore) get(code string) (parsedRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[code]
+	return rec, ok
+}
+
+// snapshot returns the records by code.
+func (s *recordStore) snapshot() map[string]parsedRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make(map[string]parsedRecord, len(s.records))
+	for code, rec := range s.records {
+		records[code] = rec
+	}
+	return records
+}
+
+// text returns the samples of all the records in the text format.
+func (s *recordStore) text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b strings.Builder
+	for _, code := range s.codes {
+		for _, sample := range s.records[code].samples {
+			b.WriteString(sample)
+			b.WriteByte('\n')
+		}
+	}
+	return b.String()
+}
+
+// lastMessage returns the time of the latest record, zero if none.
+func (s *recordStore) lastMessage() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// field returns a field of the record of a code, empty if unknown.
+func (s *recordStore) field(code, name string) string {
+	rec, _ := s.get(code)
+	return rec.fields[name]
+}
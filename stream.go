@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Reference: https://docs.openvehicles.com/en/latest/protocol_v2/index.html
+var (
+	streamFlag          = flag.Bool("stream", false, "Hold a persistent OVMS protocol v2 connection per vehicle instead of polling; polling is used while the connection is down")
+	streamServerFlag    = flag.String("stream-server", "", "OVMS protocol v2 server; defaults to the host of -server on port 6867")
+	vehiclePasswordFlag = flag.String("vehicle-password", os.Getenv("OVMS_VEHICLE_PASSWORD"), "Vehicle (module) password used to authenticate the -stream connection and the stream commands, unless the vehicle has its own vehicle_password in -config")
+)
+
+// Timings of the stream connection.
+const (
+	streamPingInterval = 5 * time.Minute
+	streamReadTimeout  = 2 * streamPingInterval
+	streamMinBackoff   = 5 * time.Second
+	streamMaxBackoff   = 5 * time.Minute
+)
+
+var lastMessageAge = prometheus.NewDesc("ovms_last_message_age_seconds",
+	"Time since the latest record of the vehicle was received.", []string{"vehicle"}, nil)
+
+// lastMessageCollector exports the age of the latest record at scrape time.
+type lastMessageCollector struct{}
+
+func (lastMessageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lastMessageAge
+}
+
+func (lastMessageCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, v := range vehicles {
+		t := v.records.lastMessage()
+		if !t.IsZero() {
+			ch <- prometheus.MustNewConstMetric(lastMessageAge, prometheus.GaugeValue, now.Sub(t).Seconds(), v.id)
+		}
+	}
+}
+
+func init() {
+	prometheus.MustRegister(lastMessageCollector{})
+}
+
+// streamClient holds the protocol v2 connection of a vehicle.
+type streamClient struct {
+	vehicle *vehicle
+
+	mu        sync.Mutex
+	connected bool
+	// send sends a message while connected.
+	send func(msg string) error
+	// results are the waiters of the command responses, by command code.
+	results map[string]chan string
+}
+
+func (s *streamClient) isConnected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected
+}
+
+func (s *streamClient) setConnected(connected bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = connected
+}
+
+// startStreams starts the stream connections if -stream is set.
+func startStreams() {
+	if !*streamFlag {
+		return
+	}
+	for _, v := range vehicles {
+		if vehiclePassword(v.id) == "" {
+			fatal("invalid -stream", fmt.Errorf("-vehicle-password or the vehicle_password of %s is required", v.id))
+		}
+	}
+	for _, v := range vehicles {
+		go v.stream.run()
+	}
+}
+
+func streamServer(vehicleID string) string {
+	if *streamServerFlag != "" {
+		return *streamServerFlag
+	}
+	addr := vehicleServer(vehicleID).currentAddr()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return net.JoinHostPort(host, "6867")
+}
+
+// run keeps the connection up, reconnecting with an exponential backoff.
+func (s *streamClient) run() {
+	backoff := streamMinBackoff
+	for {
+		start := time.Now()
+		err := s.session()
+		s.setConnected(false)
+		if time.Since(start) > streamMaxBackoff {
+			backoff = streamMinBackoff
+		}
+		slog.Warn("stream disconnected, polling until it is back", "vehicle", s.vehicle.id, "retry", backoff, "err", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, streamMaxBackoff)
+	}
+}
+
+func hmacMD5(key, msg string) []byte {
+	h := hmac.New(md5.New, []byte(key))
+	h.Write([]byte(msg))
+	return h.Sum(nil)
+}
+
+// newStreamCipher returns the RC4 cipher of a session, the first 1024 bytes
+// of the key stream being discarded.
+func newStreamCipher(key []byte) (*rc4.Cipher, error) {
+	c, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	discard := make([]byte, 1024)
+	c.XORKeyStream(discard, discard)
+	return c, nil
+}
+
+// streamConn is an authenticated protocol v2 connection.
+type streamConn struct {
+	net.Conn
+	r  *bufio.Reader
+	rx *rc4.Cipher
+
+	txMu sync.Mutex
+	tx   *rc4.Cipher
+}
+
+// dialStream connects and authenticates as an app of the vehicle.
+func dialStream(vehicleID string) (*streamConn, error) {
+	conn, err := dialOutbound(streamServer(vehicleID), 30*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	c, err := authenticateStream(conn, vehicleID)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func authenticateStream(conn net.Conn, vehicleID string) (*streamConn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	password := vehiclePassword(vehicleID)
+	clientToken := base64.RawStdEncoding.EncodeToString(nonce)
+	clientDigest := base64.StdEncoding.EncodeToString(hmacMD5(password, clientToken))
+	if _, err := fmt.Fprintf(conn, "MP-A 0 %s %s %s\r\n", clientToken, clientDigest, vehicleID); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	r := bufio.NewReader(conn)
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	f := strings.Fields(line)
+	if len(f) != 4 || f[0] != "MP-S" {
+		return nil, fmt.Errorf("unexpected welcome %q", strings.TrimSpace(line))
+	}
+	serverToken, serverDigest := f[2], f[3]
+	if !hmac.Equal([]byte(serverDigest), []byte(base64.StdEncoding.EncodeToString(hmacMD5(password, serverToken)))) {
+		return nil, fmt.Errorf("server authentication failed")
+	}
+	key := hmacMD5(password, serverToken+clientToken)
+	rx, err := newStreamCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := newStreamCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &streamConn{Conn: conn, r: r, rx: rx, tx: tx}, nil
+}
+
+// send sends a message.
+func (c *streamConn) send(msg string) error {
+	c.txMu.Lock()
+	defer c.txMu.Unlock()
+	b := []byte(msg)
+	c.tx.XORKeyStream(b, b)
+	_, err := fmt.Fprintf(c.Conn, "%s\r\n", base64.StdEncoding.EncodeToString(b))
+	return err
+}
+
+// read returns the next message, without its "MP-0 " prefix, empty if none.
+func (c *streamConn) read(timeout time.Duration) (string, error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(line))
+	if err != nil {
+		return "", fmt.Errorf("invalid message: %v", err)
+	}
+	c.rx.XORKeyStream(b, b)
+	msg, ok := strings.CutPrefix(string(b), "MP-0 ")
+	if !ok {
+		return "", nil
+	}
+	return msg, nil
+}
+
+// session authenticates and processes the messages until the connection
+// fails.
+func (s *streamClient) session() error {
+	v := s.vehicle
+	conn, err := dialStream(v.id)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	s.mu.Lock()
+	s.connected = true
+	s.send = conn.send
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.send = nil
+		s.mu.Unlock()
+	}()
+	slog.Info("stream connected", "vehicle", v.id, "server", streamServer(v.id))
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(streamPingInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := conn.send("MP-0 A"); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		msg, err := conn.read(streamReadTimeout)
+		if err != nil {
+			return err
+		}
+		if msg == "" {
+			continue
+		}
+		if msg[0] == 'c' {
+			s.commandResult(msg[1:])
+			continue
+		}
+		s.handle(msg[:1], msg[1:])
+	}
+}
+
+// command sends a command message and waits for its response, returning the
+// text of the response. Without the -stream connection up, the command is
+// sent over a connection of its own.
+// Reference: https://docs.openvehicles.com/en/latest/protocol_v2/commands.html
+func (s *streamClient) command(code string, args []string, timeout time.Duration) (string, error) {
+	msg := "MP-0 C" + strings.Join(append([]string{code}, args...), ",")
+	ch := make(chan string, 1)
+	s.mu.Lock()
+	send := s.send
+	if send != nil {
+		if s.results == nil {
+			s.results = map[string]chan string{}
+		}
+		if _, busy := s.results[code]; busy {
+			s.mu.Unlock()
+			return "", fmt.Errorf("command %s already in progress", code)
+		}
+		s.results[code] = ch
+	}
+	s.mu.Unlock()
+	if send == nil {
+		return s.commandOnce(code, msg, timeout)
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(s.results, code)
+		s.mu.Unlock()
+	}()
+	if err := send(msg); err != nil {
+		return "", err
+	}
+	select {
+	case res := <-ch:
+		return commandResponse(code, res)
+	case <-time.After(timeout):
+		return "", fmt.Errorf("no response to command %s within %v", code, timeout)
+	}
+}
+
+// commandOnce sends a command over a new connection and waits for its
+// response. The records received meanwhile, e.g. from a vehicle woken up,
+// are processed.
+func (s *streamClient) commandOnce(code, msg string, timeout time.Duration) (string, error) {
+	conn, err := dialStream(s.vehicle.id)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if err := conn.send(msg); err != nil {
+		return "", err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		msg, err := conn.read(time.Until(deadline))
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return "", fmt.Errorf("no response to command %s within %v", code, timeout)
+			}
+			return "", err
+		}
+		if msg == "" {
+			continue
+		}
+		if msg[0] == 'c' {
+			if rcode, res, _ := strings.Cut(msg[1:], ","); rcode == code {
+				return commandResponse(code, res)
+			}
+			continue
+		}
+		s.handle(msg[:1], msg[1:])
+	}
+}
+
+// commandResponse returns the text of a command response,
+// <result>[,<text>], and an error unless the result is 0 (ok): 1 failed, 2
+// unsupported and 3 unimplemented.
+func commandResponse(code, res string) (string, error) {
+	result, text, _ := strings.Cut(res, ",")
+	switch result {
+	case "0":
+		return text, nil
+	case "1":
+		return text, fmt.Errorf("command %s failed: %s", code, text)
+	case "2", "3":
+		return text, fmt.Errorf("command %s not supported by the vehicle", code)
+	}
+	return text, fmt.Errorf("command %s: unexpected result %q", code, res)
+}
+
+// commandResult passes a command response, <code>,<result>[,<text>], to its
+// waiter.
+func (s *streamClient) commandResult(msg string) {
+	code, res, _ := strings.Cut(msg, ",")
+	s.mu.Lock()
+	ch, ok := s.results[code]
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case ch <- res:
+	default:
+	}
+}
+
+// handle processes a message, updating the samples of its code right away.
+func (s *streamClient) handle(code, payload string) {
+	v := s.vehicle
+	now := time.Now().UTC().Truncate(time.Second)
+	if code == historicalCode {
+		v.historicalMessage(payload, now)
+		return
+	}
+	if _, ok := metricsMap[code]; !ok && code != notificationCode && !decodedCode(code) {
+		return
+	}
+	rec := record{Code: code, Msg: payload, MsgTime: now.Format("2006-01-02 15:04:05")}
+	v.processRecord(rec, now)
+	v.setFetchStatus(true)
+	v.utilization.update(v.state(), now, v.pollInterval())
+	v.updatePlannedTrips()
+	v.updateDerived()
+	v.updateChargeStation()
+	v.updateChargeControl()
+	v.updatePlace()
+	v.published()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeStreamServer answers the authentication of a client on conn as a
+// protocol v2 server knowing the password, failing if the client digest
+// was not made with it.
+func fakeStreamServer(conn net.Conn, password string) error {
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	f := strings.Fields(line)
+	if len(f) != 5 || f[0] != "MP-A" {
+		return fmt.Errorf("unexpected login %q", line)
+	}
+	if !hmac.Equal([]byte(f[3]), []byte(base64.StdEncoding.EncodeToString(hmacMD5(password, f[2])))) {
+		fmt.Fprintf(conn, "MP-S 1\r\n")
+		return fmt.Errorf("client digest does not match the password")
+	}
+	serverToken := "server-token"
+	_, err = fmt.Fprintf(conn, "MP-S 0 %s %s\r\n", serverToken, base64.StdEncoding.EncodeToString(hmacMD5(password, serverToken)))
+	return err
+}
+
+func TestStreamVehiclePassword(t *testing.T) {
+	prevFlag, prevConfig := *vehiclePasswordFlag, cfg()
+	t.Cleanup(func() {
+		*vehiclePasswordFlag = prevFlag
+		currentConfig.Store(prevConfig)
+	})
+	*vehiclePasswordFlag = "global"
+	currentConfig.Store(&config{Vehicles: []*vehicleConfig{{Vehicle: "A", VehiclePassword: "own"}}})
+
+	for _, tc := range []struct {
+		vehicle, password string
+	}{
+		{"A", "own"},
+		{"B", "global"},
+	} {
+		if got := vehiclePassword(tc.vehicle); got != tc.password {
+			t.Errorf("vehiclePassword(%q) = %q, want %q", tc.vehicle, got, tc.password)
+		}
+		client, server := net.Pipe()
+		errc := make(chan error, 1)
+		go func() { errc <- fakeStreamServer(server, tc.password) }()
+		c, err := authenticateStream(client, tc.vehicle)
+		if err != nil {
+			t.Errorf("authenticateStream(%q) = %v", tc.vehicle, err)
+		} else {
+			c.Close()
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("server of %q: %v", tc.vehicle, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reference: https://www.freedesktop.org/software/systemd/man/latest/sd_listen_fds.html
+// and https://www.freedesktop.org/software/systemd/man/latest/sd_notify.html
+
+// systemdAddr is the -addr of the socket passed by systemd, the only one or
+// the one named systemd:<FileDescriptorName>.
+const systemdAddr = "systemd"
+
+var (
+	systemdOnce sync.Once
+	// systemdSockets are the sockets passed by systemd, by name.
+	systemdSockets map[string]net.Listener
+	systemdNames   []string
+	systemdErr     error
+)
+
+// loadSystemdSockets loads the sockets passed by systemd on socket
+// activation, as fds 3 and up.
+func loadSystemdSockets() {
+	systemdSockets = map[string]net.Listener{}
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		systemdErr = fmt.Errorf("invalid LISTEN_FDS: %v", err)
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// Not passed to the processes started by an upgrade.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for i := 0; i < n; i++ {
+		name := strconv.Itoa(3 + i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(3+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			systemdErr = fmt.Errorf("error using the systemd socket %s: %v", name, err)
+			return
+		}
+		systemdSockets[name] = ln
+		systemdNames = append(systemdNames, name)
+	}
+}
+
+// systemdListener returns the socket passed by systemd of an -addr.
+func systemdListener(addr string) (net.Listener, error) {
+	systemdOnce.Do(loadSystemdSockets)
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+	name, named := strings.CutPrefix(addr, systemdAddr+":")
+	if !named {
+		if len(systemdNames) != 1 {
+			return nil, fmt.Errorf("-addr %s needs exactly one socket from systemd, got %d; use %s:<name>", systemdAddr, len(systemdNames), systemdAddr)
+		}
+		name = systemdNames[0]
+	}
+	ln, ok := systemdSockets[name]
+	if !ok {
+		return nil, fmt.Errorf("no socket %q from systemd, got %s", name, strings.Join(systemdNames, ", "))
+	}
+	delete(systemdSockets, name)
+	return ln, nil
+}
+
+// isSystemdAddr reports whether an -addr is of a socket passed by systemd.
+func isSystemdAddr(addr string) bool {
+	return addr == systemdAddr || strings.HasPrefix(addr, systemdAddr+":")
+}
+
+// sdNotify sends a state to the systemd service manager, if any.
+func sdNotify(state string) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return
+	}
+	if strings.HasPrefix(path, "@") {
+		// Abstract socket.
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		slog.Error("error notifying systemd", "state", state, "err", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		slog.Error("error notifying systemd", "state", state, "err", err)
+	}
+}
+
+// pollStallTimeout is how much longer than the poll interval the poll loop
+// can go without progress before the watchdog stops being notified.
+const pollStallTimeout = 5 * time.Minute
+
+// pollProgress is the time of the last progress of the poll loop, in Unix
+// nanoseconds.
+var pollProgress atomic.Int64
+
+// pollHeartbeat records a progress of the poll loop.
+func pollHeartbeat() {
+	pollProgress.Store(time.Now().UnixNano())
+}
+
+// runWatchdog notifies the systemd watchdog, if enabled, as long as the poll
+// loop progresses, so that systemd restarts the exporter when it wedges.
+func runWatchdog() {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	// The watchdog passes to the process started by an upgrade.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) && !upgraded {
+		return
+	}
+	interval := time.Duration(usec) * time.Microsecond / 2
+	slog.Info("notifying the systemd watchdog", "interval", interval)
+	for range time.Tick(interval) {
+		since := time.Since(time.Unix(0, pollProgress.Load()))
+		if since > nextPoll()+pollStallTimeout {
+			slog.Error("the poll loop is stalled, not notifying the systemd watchdog", "since", since.Round(time.Second))
+			continue
+		}
+		sdNotify("WATCHDOG=1")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// tariff is the electricity price used to estimate charging costs. The
+// rate of the first matching time-of-use window applies, RatePerKWh
+// otherwise.
+type tariff struct {
+	RatePerKWh float64 `json:"rate_per_kwh"`
+	// Timezone is the IANA time zone of the windows, the local one if
+	// empty.
+	Timezone string          `json:"timezone,omitempty"`
+	Windows  []*tariffWindow `json:"windows,omitempty"`
+
+	loc *time.Location
+}
+
+// tariffWindow is a daily time-of-use window such as "22:00"-"06:00".
+type tariffWindow struct {
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	RatePerKWh float64 `json:"rate_per_kwh"`
+
+	start, end time.Duration
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (t *tariff) validate() error {
+	// LoadLocation("") is UTC, not the local time zone.
+	t.loc = time.Local
+	var err error
+	if t.Timezone != "" {
+		if t.loc, err = time.LoadLocation(t.Timezone); err != nil {
+			return fmt.Errorf("tariff: %v", err)
+		}
+	}
+	for _, w := range t.Windows {
+		if w.start, err = parseClock(w.Start); err != nil {
+			return fmt.Errorf("tariff: %v", err)
+		}
+		if w.end, err = parseClock(w.End); err != nil {
+			return fmt.Errorf("tariff: %v", err)
+		}
+	}
+	return nil
+}
+
+// rate returns the price per kWh at the given time.
+func (t *tariff) rate(ts time.Time) float64 {
+	local := ts.In(t.loc)
+	tod := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute
+	for _, w := range t.Windows {
+		if w.start <= w.end {
+			if tod >= w.start && tod < w.end {
+				return w.RatePerKWh
+			}
+		} else if tod >= w.start || tod < w.end {
+			// The window wraps around midnight.
+			return w.RatePerKWh
+		}
+	}
+	return t.RatePerKWh
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	tireRotationReminderFlag = flag.Float64("tire-rotation-reminder-km", 10000, "Notify when the tires were not rotated for this distance; 0 disables")
+	tireChangeReminderFlag   = flag.Float64("tire-change-reminder-km", 0, "Notify when the tires were not changed for this distance; 0 disables")
+)
+
+const (
+	// tiresStateFile is the state file name pattern, %s being the vehicle ID.
+	tiresStateFile = "tires_%s.json"
+
+	// tireJumpKPa is the pressure change of a wheel that is treated as a
+	// discontinuity rather than normal drift.
+	tireJumpKPa = 20
+	// tireMatchKPa is how close pressures must be to be the same tire.
+	tireMatchKPa = 8
+)
+
+var (
+	tireKmSince = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_tire_km_since",
+		Help: "Distance driven since the last detected tire change or rotation.",
+	}, []string{"vehicle", "event"})
+	tireEventTime = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_tire_event_timestamp_seconds",
+		Help: "Time of the last detected tire change or rotation.",
+	}, []string{"vehicle", "event"})
+)
+
+// tireEvent is a detected change or rotation of the tires.
+type tireEvent struct {
+	Time       time.Time `json:"time"`
+	OdometerKm float64   `json:"odometer_km"`
+}
+
+// tireTracker detects wheel-set changes and rotations. OVMS does not report
+// the TPMS sensor IDs, so they are inferred from the per-wheel pressures:
+// the sensors moving to other wheels show up as a permutation of the
+// previous pressures, a new wheel set as a jump that is neither a
+// permutation nor the uniform shift caused by temperature.
+type tireTracker struct {
+	vehicle string
+
+	mu        sync.Mutex
+	Pressures []float64
+	Change    *tireEvent
+	Rotation  *tireEvent
+	// Reminded records the reminders already sent, by event.
+	Reminded map[string]bool
+}
+
+func (t *tireTracker) stateFile() string {
+	return fmt.Sprintf(tiresStateFile, url.PathEscape(t.vehicle))
+}
+
+func (t *tireTracker) init() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := readState(t.stateFile(), t); err != nil {
+		return fmt.Errorf("error loading %s: %v", t.stateFile(), err)
+	}
+	if t.Reminded == nil {
+		t.Reminded = map[string]bool{}
+	}
+	return nil
+}
+
+// update processes the fields of a Y record.
+func (t *tireTracker) update(fields map[string]string, odometerKm float64, ts time.Time) {
+	var pressures []float64
+	for i := 1; i <= 4; i++ {
+		p, err := strconv.ParseFloat(fields[fmt.Sprintf("ms_v_tpms_pressure_whee%d", i)], 64)
+		if err != nil || p <= 0 {
+			return
+		}
+		pressures = append(pressures, p)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	changed := false
+	if len(t.Pressures) == len(pressures) {
+		switch classifyTires(t.Pressures, pressures) {
+		case "change":
+			t.Change = &tireEvent{ts, odometerKm}
+			t.Reminded["change"] = false
+			notify("%s: new set of tires detected at %.0f km", t.vehicle, odometerKm)
+			changed = true
+		case "rotation":
+			t.Rotation = &tireEvent{ts, odometerKm}
+			t.Reminded["rotation"] = false
+			notify("%s: tire rotation detected at %.0f km", t.vehicle, odometerKm)
+			changed = true
+		}
+	}
+	if len(t.Pressures) == 0 {
+		changed = true
+	}
+	t.Pressures = pressures
+
+	if t.remind("change", t.Change, *tireChangeReminderFlag, odometerKm) {
+		changed = true
+	}
+	if t.remind("rotation", t.Rotation, *tireRotationReminderFlag, odometerKm) {
+		changed = true
+	}
+	if changed {
+		if err := writeState(t.stateFile(), t); err != nil {
+			slog.Error("error saving state", "file", t.stateFile(), "err", err)
+		}
+	}
+}
+
+// remind exports the distance since the event and notifies once the
+// reminder threshold is reached. It returns true if a reminder was sent.
+func (t *tireTracker) remind(event string, e *tireEvent, thresholdKm, odometerKm float64) bool {
+	if e == nil || odometerKm <= 0 {
+		return false
+	}
+	km := odometerKm - e.OdometerKm
+	tireKmSince.WithLabelValues(t.vehicle, event).Set(km)
+	tireEventTime.WithLabelValues(t.vehicle, event).Set(float64(e.Time.Unix()))
+	if thresholdKm > 0 && km >= thresholdKm && !t.Reminded[event] {
+		t.Reminded[event] = true
+		notify("%s: tire %s is due, %.0f km since the last one", t.vehicle, event, km)
+		return true
+	}
+	return false
+}
+
+// classifyTires compares two consecutive pressure readings and returns
+// "change", "rotation" or "" if the tires are the same.
+func classifyTires(prev, cur []float64) string {
+	deltas := make([]float64, len(cur))
+	jump := false
+	for i := range cur {
+		deltas[i] = cur[i] - prev[i]
+		if math.Abs(deltas[i]) >= tireJumpKPa {
+			jump = true
+		}
+	}
+	if !jump {
+		return ""
+	}
+
+	// Temperature moves all the pressures by about the same amount.
+	sorted := append([]float64(nil), deltas...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	uniform := true
+	for _, d := range deltas {
+		if math.Abs(d-median) > tireMatchKPa {
+			uniform = false
+		}
+	}
+	if uniform {
+		return ""
+	}
+
+	if isPermutation(prev, cur) {
+		return "rotation"
+	}
+	return "change"
+}
+
+// isPermutation reports whether cur is a reordering of prev, within
+// tireMatchKPa.
+func isPermutation(prev, cur []float64) bool {
+	used := make([]bool, len(prev))
+	var match func(i int) bool
+	match = func(i int) bool {
+		if i == len(cur) {
+			return true
+		}
+		for j := range prev {
+			if !used[j] && math.Abs(cur[i]-prev[j]) <= tireMatchKPa {
+				used[j] = true
+				if match(i + 1) {
+					return true
+				}
+				used[j] = false
+			}
+		}
+		return false
+	}
+	return match(0)
+}
+
+// tireSnapshot is a copy of the tire tracker state.
+type tireSnapshot struct {
+	Pressures []float64  `json:"pressures"`
+	Change    *tireEvent `json:"change"`
+	Rotation  *tireEvent `json:"rotation"`
+}
+
+func (t *tireTracker) snapshot() tireSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return tireSnapshot{
+		Pressures: append([]float64(nil), t.Pressures...),
+		Change:    t.Change,
+		Rotation:  t.Rotation,
+	}
+}
+
+// purge forgets all the history, including the persisted state.
+func (t *tireTracker) purge() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Pressures = nil
+	t.Change = nil
+	t.Rotation = nil
+	t.Reminded = map[string]bool{}
+	tireKmSince.DeletePartialMatch(prometheus.Labels{"vehicle": t.vehicle})
+	tireEventTime.DeletePartialMatch(prometheus.Labels{"vehicle": t.vehicle})
+	return removeState(t.stateFile())
+}
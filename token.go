@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// Reference: https://docs.openvehicles.com/en/latest/server/api.html#api-tokens
+const tokenUsage = `usage: ovms_exporter [flags] token <command>
+
+Commands:
+  create [application [purpose]]  create a new API token
+  list                            list the existing API tokens
+  revoke <token>                  revoke an API token
+
+The commands authenticate with -username and -token, or -password
+without -token.`
+
+// authQuery returns the query parameters used to authenticate against the
+// OVMS server API. An API token, if set, is used in place of the password.
+func authQuery() url.Values {
+	password := *passwordFlag
+	if *tokenFlag != "" {
+		password = *tokenFlag
+	}
+	return url.Values{
+		"username": {*usernameFlag},
+		"password": {password},
+	}
+}
+
+// tokenCommand returns the flags of the token command, none, and its function.
+func tokenCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(fs.Output(), tokenUsage) }
+	return fs, runTokenCommand
+}
+
+func runTokenCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", tokenUsage)
+	}
+
+	q := authQuery()
+	method := http.MethodGet
+	path := "/api/token"
+	switch args[0] {
+	case "create":
+		method = http.MethodPost
+		q.Set("application", "ovms_exporter")
+		q.Set("purpose", "metrics")
+		q.Set("permit", "auth")
+		if len(args) > 1 {
+			q.Set("application", args[1])
+		}
+		if len(args) > 2 {
+			q.Set("purpose", args[2])
+		}
+	case "list":
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("%s", tokenUsage)
+		}
+		method = http.MethodDelete
+		path += "/" + url.PathEscape(args[1])
+	default:
+		return fmt.Errorf("unknown token command %q\n%s", args[0], tokenUsage)
+	}
+
+	u := fmt.Sprintf("http://%s%s?%s", currentServer(), path, q.Encode())
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := serverDo(req)
+	if err != nil {
+		return fmt.Errorf("error calling %s %s: %v", method, path, withoutURL(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, body)
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	fmt.Println()
+	return err
+}
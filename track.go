@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var trackWindowFlag = flag.Duration("track-window", 24*time.Hour, "How long the positions of the L records are kept in memory for /track.gpx and /track.kml; 0 disables them")
+
+// maxTrackPoints caps the positions kept per vehicle.
+const maxTrackPoints = 100000
+
+// trackPoint is a position of the vehicle.
+type trackPoint struct {
+	Time      time.Time
+	Lat, Lon  float64
+	Elevation float64
+}
+
+// trackBuffer holds the recent positions of a vehicle, oldest first.
+type trackBuffer struct {
+	mu     sync.Mutex
+	points []trackPoint
+}
+
+// add processes the fields of an L record. The positions unchanged since
+// the previous record are skipped, unknown ones too.
+func (t *trackBuffer) add(fields map[string]string, ts time.Time) {
+	if *trackWindowFlag <= 0 {
+		return
+	}
+	lat, err1 := strconv.ParseFloat(fields["ms_v_pos_latitude"], 64)
+	lon, err2 := strconv.ParseFloat(fields["ms_v_pos_longitude"], 64)
+	if err1 != nil || err2 != nil || lat == 0 && lon == 0 {
+		return
+	}
+	ele, _ := strconv.ParseFloat(fields["ms_v_pos_altitude"], 64)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.points); n > 0 {
+		last := t.points[n-1]
+		if !ts.After(last.Time) || last.Lat == lat && last.Lon == lon {
+			return
+		}
+	}
+	t.points = append(t.points, trackPoint{ts, lat, lon, ele})
+	// Trimmed by copying, for the array not to grow forever.
+	cutoff := ts.Add(-*trackWindowFlag)
+	i := 0
+	for i < len(t.points) && (t.points[i].Time.Before(cutoff) || len(t.points)-i > maxTrackPoints) {
+		i++
+	}
+	if i > 0 {
+		t.points = append(t.points[:0], t.points[i:]...)
+	}
+}
+
+// since returns the positions newer than a time.
+func (t *trackBuffer) since(start time.Time) []trackPoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var points []trackPoint
+	for _, p := range t.points {
+		if p.Time.After(start) {
+			points = append(points, p)
+		}
+	}
+	return points
+}
+
+// Reference: https://www.topografix.com/GPX/1/1/
+type gpx struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   struct {
+		Name    string `xml:"name"`
+		Segment struct {
+			Points []gpxPoint `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+type gpxPoint struct {
+	Lat       float64 `xml:"lat,attr"`
+	Lon       float64 `xml:"lon,attr"`
+	Elevation float64 `xml:"ele"`
+	Time      string  `xml:"time"`
+}
+
+// Reference: https://developers.google.com/kml/documentation/kmlreference
+type kml struct {
+	XMLName   xml.Name `xml:"http://www.opengis.net/kml/2.2 kml"`
+	Placemark struct {
+		Name       string `xml:"name"`
+		LineString struct {
+			AltitudeMode string `xml:"altitudeMode"`
+			Coordinates  string `xml:"coordinates"`
+		} `xml:"LineString"`
+	} `xml:"Document>Placemark"`
+}
+
+// handleTrack serves the recent track of a vehicle, ?vehicle=<id>, as GPX or
+// KML by the extension of the path, over the -track-window or the last
+// ?window=<duration>.
+func handleTrack(w http.ResponseWriter, r *http.Request) {
+	if *trackWindowFlag <= 0 {
+		http.Error(w, "tracks are disabled, see -track-window", http.StatusNotFound)
+		return
+	}
+	v := findVehicle(r.FormValue("vehicle"))
+	if v == nil {
+		http.Error(w, "unknown vehicle", http.StatusNotFound)
+		return
+	}
+	window := *trackWindowFlag
+	if s := r.FormValue("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid window", http.StatusBadRequest)
+			return
+		}
+		window = min(d, window)
+	}
+	points := v.track.since(time.Now().Add(-window))
+	name := fmt.Sprintf("%s %s", v.id, time.Now().UTC().Format(time.RFC3339))
+
+	var doc interface{}
+	if strings.HasSuffix(r.URL.Path, ".kml") {
+		w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+		var k kml
+		k.Placemark.Name = name
+		k.Placemark.LineString.AltitudeMode = "clampToGround"
+		var coords []string
+		for _, p := range points {
+			coords = append(coords, fmt.Sprintf("%g,%g,%g", p.Lon, p.Lat, p.Elevation))
+		}
+		k.Placemark.LineString.Coordinates = strings.Join(coords, " ")
+		doc = k
+	} else {
+		w.Header().Set("Content-Type", "application/gpx+xml")
+		g := gpx{Version: "1.1", Creator: "ovms_exporter " + version}
+		g.Track.Name = name
+		for _, p := range points {
+			g.Track.Segment.Points = append(g.Track.Segment.Points, gpxPoint{p.Lat, p.Lon, p.Elevation, p.Time.UTC().Format(time.RFC3339)})
+		}
+		doc = g
+	}
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		slog.Error("error writing the track", "vehicle", v.id, "err", err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const kmPerMile = 1.609344
+
+var (
+	tripsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_trips_total",
+		Help: "Number of completed trips.",
+	}, []string{"vehicle"})
+	tripDistance = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_trip_distance_km",
+		Help: "Distance of the last completed trip.",
+	}, []string{"vehicle"})
+	tripEnergyUsed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_trip_energy_used_kwh",
+		Help: "Battery energy used during the last completed trip.",
+	}, []string{"vehicle"})
+	tripEfficiency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_trip_efficiency_wh_per_km",
+		Help: "Energy efficiency of the last completed trip.",
+	}, []string{"vehicle"})
+)
+
+// trip is a drive between two parking periods.
+type trip struct {
+	Start, End                     time.Time
+	StartLat, StartLon             float64
+	EndLat, EndLon                 float64
+	StartOdometerKm, EndOdometerKm float64
+	StartEnergyKWh, EnergyKWh      float64
+	StartSOC, EndSOC               float64
+}
+
+// DistanceKm returns the distance driven.
+func (t *trip) DistanceKm() float64 {
+	return t.EndOdometerKm - t.StartOdometerKm
+}
+
+// tripTracker detects trips from the parktime and speed in the D record and
+// collects the position and energy from the L record.
+type tripTracker struct {
+	vehicle    string
+	efficiency *efficiencyTracker
+	// onCompleted, if set, is called with every completed trip.
+	onCompleted func(trip)
+	// onEvent, if set, is called when a trip starts.
+	onEvent func(event string)
+
+	mu      sync.Mutex
+	miles   bool
+	driving bool
+	cur     trip
+
+	// odometerKm is the latest odometer reading.
+	odometerKm float64
+
+	// soc is the latest value from the S record.
+	soc float64
+
+	// Latest values from the L record.
+	lat, lon   float64
+	energyUsed float64
+}
+
+// setUnits processes the m_units_distance and ms_v_bat_soc fields of the S
+// record.
+func (t *tripTracker) setUnits(fields map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.miles = fields["m_units_distance"] == "M"
+	if soc, err := strconv.ParseFloat(fields["ms_v_bat_soc"], 64); err == nil {
+		t.soc = soc
+	}
+}
+
+// updatePosition processes the fields of an L record.
+func (t *tripTracker) updatePosition(fields map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lat, err := strconv.ParseFloat(fields["ms_v_pos_latitude"], 64); err == nil {
+		t.lat = lat
+	}
+	if lon, err := strconv.ParseFloat(fields["ms_v_pos_longitude"], 64); err == nil {
+		t.lon = lon
+	}
+	if e, err := strconv.ParseFloat(fields["ms_v_bat_energy_used"], 64); err == nil {
+		t.energyUsed = e
+		t.efficiency.addEnergy(e)
+	}
+}
+
+// updateDrive processes the fields of a D record.
+func (t *tripTracker) updateDrive(fields map[string]string, ts time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parktime, err1 := strconv.ParseFloat(fields["ms_v_env_parktime"], 64)
+	speed, err2 := strconv.ParseFloat(fields["ms_v_pos_speed"], 64)
+	odometer, err3 := strconv.ParseFloat(fields["ms_v_pos_odometer"], 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+	// The odometer is in the vehicle units.
+	if t.miles {
+		odometer *= kmPerMile
+	}
+	t.odometerKm = odometer
+	t.efficiency.addOdometer(odometer)
+
+	driving := parktime == 0 || speed > 0
+	switch {
+	case driving && !t.driving:
+		t.cur = trip{
+			Start:           ts,
+			StartLat:        t.lat,
+			StartLon:        t.lon,
+			StartOdometerKm: odometer,
+			StartEnergyKWh:  t.energyUsed,
+			StartSOC:        t.soc,
+		}
+		if t.onEvent != nil {
+			t.onEvent(eventTripStart)
+		}
+	case !driving && t.driving:
+		t.cur.End = ts
+		t.cur.EndLat, t.cur.EndLon = t.lat, t.lon
+		t.cur.EndOdometerKm = odometer
+		t.cur.EndSOC = t.soc
+		// Most vehicles reset ms_v_bat_energy_used at the start of a trip.
+		t.cur.EnergyKWh = t.energyUsed - t.cur.StartEnergyKWh
+		if t.cur.EnergyKWh < 0 {
+			t.cur.EnergyKWh = t.energyUsed
+		}
+		t.completed(t.cur)
+	}
+	t.driving = driving
+}
+
+// completed exports the summary of a finished trip.
+func (t *tripTracker) completed(tr trip) {
+	d := tr.DistanceKm()
+	if d <= 0 {
+		return
+	}
+	tripsTotal.WithLabelValues(t.vehicle).Inc()
+	tripDistance.WithLabelValues(t.vehicle).Set(d)
+	tripEnergyUsed.WithLabelValues(t.vehicle).Set(tr.EnergyKWh)
+	tripEfficiency.WithLabelValues(t.vehicle).Set(tr.EnergyKWh * 1000 / d)
+	if t.onCompleted != nil {
+		t.onCompleted(tr)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+//go:embed ui
+var uiFiles embed.FS
+
+// uiVehicle is the latest state of a vehicle sent to the UI: the fields of
+// the latest record of each code, and the update time.
+type uiVehicle map[string]interface{}
+
+// handleUIState serves the latest fields of every vehicle for the initial
+// rendering of the UI, which then follows /events.
+func handleUIState(w http.ResponseWriter, r *http.Request) {
+	state := map[string]uiVehicle{}
+	for _, v := range vehicles {
+		uv := uiVehicle{}
+		for code, rec := range v.records.snapshot() {
+			uv[code] = rec.fields
+		}
+		if t := v.records.lastMessage(); !t.IsZero() {
+			uv["updated"] = t.Local().Format(time.DateTime)
+		}
+		state[v.id] = uv
+	}
+	writeJSON(w, state)
+}
+
+// uiHandler serves the embedded UI.
+func uiHandler() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(sub)))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listenersEnv lists the addresses of the listeners a process started by an
+// upgrade inherits, as fds 3 and up in that order. The next fd is a pipe to
+// report its readiness.
+const listenersEnv = "OVMS_EXPORTER_LISTENERS"
+
+var (
+	listenersMu sync.Mutex
+	// listeners are the open listeners by address, handed to the new process
+	// on an upgrade.
+	listeners = map[string]*drainListener{}
+	inherited map[string]net.Listener
+	readyPipe *os.File
+	// upgraded is whether the process was started by an upgrade.
+	upgraded bool
+)
+
+// inheritListeners loads the listeners passed by the previous process.
+func inheritListeners() error {
+	inherited = map[string]net.Listener{}
+	env := os.Getenv(listenersEnv)
+	if env == "" {
+		return nil
+	}
+	os.Unsetenv(listenersEnv)
+	addrs := strings.Fields(env)
+	for i, addr := range addrs {
+		f := os.NewFile(uintptr(3+i), addr)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("error inheriting the listener of %s: %v", addr, err)
+		}
+		inherited[addr] = ln
+	}
+	readyPipe = os.NewFile(uintptr(3+len(addrs)), "ready")
+	upgraded = true
+	return nil
+}
+
+// listenAddrs implements flag.Value for the repeatable -addr flag, the
+// first value replacing the default.
+type listenAddrs struct {
+	addrs []string
+	set   bool
+}
+
+func (a *listenAddrs) String() string {
+	return strings.Join(a.addrs, ",")
+}
+
+func (a *listenAddrs) Set(s string) error {
+	if !a.set {
+		a.addrs, a.set = nil, true
+	}
+	a.addrs = append(a.addrs, s)
+	return nil
+}
+
+var addrFlag = listenAddrs{addrs: []string{":8080"}}
+
+func init() {
+	flag.Var(&addrFlag, "addr", "Address to listen on, host:port, unix:///path/to/socket, or systemd[:<name>] for a socket passed by systemd socket activation; may be repeated")
+}
+
+// unixSocketPrefix is the prefix of the addresses of Unix domain sockets.
+const unixSocketPrefix = "unix://"
+
+// listenNew opens a listener of addr, or takes the one passed by systemd.
+// A stale socket file is removed; the
+// file is not removed on close, the socket being handed to the new process
+// on an upgrade.
+func listenNew(addr string) (net.Listener, error) {
+	if isSystemdAddr(addr) {
+		return systemdListener(addr)
+	}
+	path, ok := strings.CutPrefix(addr, unixSocketPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	return ln, nil
+}
+
+// listen returns a listener of addr, inherited from the previous process on
+// an upgrade.
+func listen(addr string) (net.Listener, error) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	if inherited == nil {
+		if err := inheritListeners(); err != nil {
+			return nil, err
+		}
+	}
+	ln, ok := inherited[addr]
+	if ok {
+		delete(inherited, addr)
+	} else {
+		var err error
+		if ln, err = listenNew(addr); err != nil {
+			return nil, err
+		}
+	}
+	dl := &drainListener{Listener: ln, stop: make(chan struct{}), closed: make(chan struct{})}
+	listeners[addr] = dl
+	return dl, nil
+}
+
+// drainListener is a listener that can stop accepting connections while
+// staying open, leaving them to the new process on an upgrade. Without it
+// the connections accepted while the server shuts down would be dropped.
+type drainListener struct {
+	net.Listener
+	stopOnce, closeOnce sync.Once
+	stop, closed        chan struct{}
+}
+
+func (l *drainListener) Accept() (net.Conn, error) {
+	for {
+		select {
+		case <-l.stop:
+			<-l.closed
+			return nil, net.ErrClosed
+		default:
+		}
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case <-l.stop:
+				continue
+			default:
+			}
+		}
+		return c, err
+	}
+}
+
+// drain stops accepting connections, interrupting a pending Accept.
+func (l *drainListener) drain() {
+	l.stopOnce.Do(func() { close(l.stop) })
+	if d, ok := l.Listener.(interface{ SetDeadline(time.Time) error }); ok {
+		d.SetDeadline(time.Now())
+	}
+}
+
+func (l *drainListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return l.Listener.Close()
+}
+
+// signalReady tells the previous process, if any, that it can stop.
+func signalReady() {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	for addr, ln := range inherited {
+		// Not listened on anymore, e.g. after a flag change.
+		ln.Close()
+		delete(inherited, addr)
+	}
+	if readyPipe != nil {
+		readyPipe.Write([]byte{1})
+		readyPipe.Close()
+		readyPipe = nil
+	}
+}
+
+// drainListeners stops accepting connections on all the listeners.
+func drainListeners() {
+	_, lns := openListeners()
+	for _, ln := range lns {
+		ln.drain()
+	}
+}
+
+// openListeners returns the addresses and the listeners, sorted by address.
+func openListeners() ([]string, []*drainListener) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	var addrs []string
+	for addr := range listeners {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	lns := make([]*drainListener, len(addrs))
+	for i, addr := range addrs {
+		lns[i] = listeners[addr]
+	}
+	return addrs, lns
+}
@@ -0,0 +1,6 @@
+//go:build !unix
+
+package main
+
+// handleUpgrades is a no-op: upgrades are only supported on Unix.
+func handleUpgrades() {}
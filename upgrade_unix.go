@@ -0,0 +1,106 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// upgradeTimeout is how long the new process has to take over the listeners.
+const upgradeTimeout = 30 * time.Second
+
+// handleUpgrades starts a new process from the executable on SIGUSR2, hands it
+// the listeners and stops this one gracefully once the new one is ready. Both
+// accept connections on the same sockets meanwhile so no scrape fails.
+func handleUpgrades() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	for range ch {
+		pid, err := upgrade()
+		if err != nil {
+			slog.Error("upgrade failed", "err", err)
+			continue
+		}
+		slog.Info("upgraded, handing over", "pid", pid)
+		drainListeners()
+		quitOnce.Do(func() { close(quit) })
+		return
+	}
+}
+
+// upgrade starts the new process. The listener sockets are passed with
+// syscall.ForkExec since os/exec would switch them to blocking mode, which is
+// shared with this process.
+func upgrade() (int, error) {
+	addrs, lns := openListeners()
+	var fds []int
+	defer func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}()
+	for _, ln := range lns {
+		sc, ok := ln.Listener.(syscall.Conn)
+		if !ok {
+			return 0, fmt.Errorf("unsupported listener %T", ln.Listener)
+		}
+		rc, err := sc.SyscallConn()
+		if err != nil {
+			return 0, err
+		}
+		var dupErr error
+		err = rc.Control(func(fd uintptr) {
+			var nfd int
+			nfd, dupErr = syscall.Dup(int(fd))
+			if dupErr == nil {
+				syscall.CloseOnExec(nfd)
+				fds = append(fds, nfd)
+			}
+		})
+		if err == nil {
+			err = dupErr
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	files := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+	for _, fd := range fds {
+		files = append(files, uintptr(fd))
+	}
+	files = append(files, w.Fd())
+	pid, err := syscall.ForkExec(exe, os.Args, &syscall.ProcAttr{
+		Env:   append(os.Environ(), listenersEnv+"="+strings.Join(addrs, " ")),
+		Files: files,
+	})
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	r.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+			p.Wait()
+		}
+		return 0, fmt.Errorf("the new process did not become ready: %v", err)
+	}
+	return pid, nil
+}
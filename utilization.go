@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// utilizationDays is the number of days kept for /report/fleet.
+const utilizationDays = 7
+
+// Vehicle states tracked for utilization.
+const (
+	stateDriving  = "driving"
+	stateParked   = "parked"
+	stateCharging = "charging"
+)
+
+var (
+	utilizationSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "ovms_utilization_seconds_total",
+		Help: "Time spent by the vehicle in each state.",
+	}, []string{"vehicle", "state"})
+	utilizationToday = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_utilization_today_hours",
+		Help: "Hours spent by the vehicle in each state since local midnight.",
+	}, []string{"vehicle", "state"})
+)
+
+// utilizationDay holds the hours spent in each state on one local day.
+type utilizationDay struct {
+	Date          string  `json:"date"`
+	DrivingHours  float64 `json:"driving_hours"`
+	ParkedHours   float64 `json:"parked_hours"`
+	ChargingHours float64 `json:"charging_hours"`
+}
+
+func (d *utilizationDay) add(state string, hours float64) {
+	switch state {
+	case stateDriving:
+		d.DrivingHours += hours
+	case stateParked:
+		d.ParkedHours += hours
+	case stateCharging:
+		d.ChargingHours += hours
+	}
+}
+
+// utilizationTracker attributes the time between polls to the state the
+// vehicle was in at the end of the previous poll.
+type utilizationTracker struct {
+	vehicle string
+
+	mu    sync.Mutex
+	last  time.Time
+	state string
+	days  []*utilizationDay
+}
+
+// update records that the vehicle is in state at time now, interval being
+// the poll interval of the vehicle.
+func (u *utilizationTracker) update(state string, now time.Time, interval time.Duration) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// Gaps much longer than the poll interval mean the state is unknown.
+	if elapsed := now.Sub(u.last); u.state != "" && elapsed > 0 && elapsed <= 2*max(interval, *pollDurationFlag) {
+		utilizationSeconds.WithLabelValues(u.vehicle, u.state).Add(elapsed.Seconds())
+		date := now.Format("2006-01-02")
+		if n := len(u.days); n == 0 || u.days[n-1].Date != date {
+			u.days = append(u.days, &utilizationDay{Date: date})
+			if len(u.days) > utilizationDays {
+				u.days = u.days[1:]
+			}
+		}
+		day := u.days[len(u.days)-1]
+		day.add(u.state, elapsed.Hours())
+		utilizationToday.WithLabelValues(u.vehicle, stateDriving).Set(day.DrivingHours)
+		utilizationToday.WithLabelValues(u.vehicle, stateParked).Set(day.ParkedHours)
+		utilizationToday.WithLabelValues(u.vehicle, stateCharging).Set(day.ChargingHours)
+	}
+	u.last = now
+	u.state = state
+}
+
+func (u *utilizationTracker) report() []utilizationDay {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	days := make([]utilizationDay, len(u.days))
+	for i, d := range u.days {
+		days[i] = *d
+	}
+	return days
+}
+
+// purge forgets the daily history.
+func (u *utilizationTracker) purge() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.days = nil
+	utilizationToday.DeletePartialMatch(prometheus.Labels{"vehicle": u.vehicle})
+}
+
+// state returns the current state of the vehicle for utilization purposes.
+func (v *vehicle) state() string {
+	v.charge.mu.Lock()
+	charging := v.charge.charging
+	v.charge.mu.Unlock()
+	if charging {
+		return stateCharging
+	}
+	v.trips.mu.Lock()
+	driving := v.trips.driving
+	v.trips.mu.Unlock()
+	if driving {
+		return stateDriving
+	}
+	return stateParked
+}
+
+type fleetReportVehicle struct {
+	Vehicle            string           `json:"vehicle"`
+	State              string           `json:"state"`
+	Days               []utilizationDay `json:"days"`
+	AvgDrivingHours    float64          `json:"avg_driving_hours"`
+	AvgChargingHours   float64          `json:"avg_charging_hours"`
+	UtilizationPercent float64          `json:"utilization_percent"`
+}
+
+// handleFleetReport serves the per-vehicle utilization of the last days,
+// least utilized vehicles first.
+func handleFleetReport(w http.ResponseWriter, r *http.Request) {
+	var report []fleetReportVehicle
+	for _, v := range vehicles {
+		rv := fleetReportVehicle{
+			Vehicle: v.id,
+			State:   v.state(),
+			Days:    v.utilization.report(),
+		}
+		var total float64
+		for _, d := range rv.Days {
+			rv.AvgDrivingHours += d.DrivingHours
+			rv.AvgChargingHours += d.ChargingHours
+			total += d.DrivingHours + d.ParkedHours + d.ChargingHours
+		}
+		if n := float64(len(rv.Days)); n > 0 {
+			if total > 0 {
+				rv.UtilizationPercent = rv.AvgDrivingHours / total * 100
+			}
+			rv.AvgDrivingHours /= n
+			rv.AvgChargingHours /= n
+		}
+		report = append(report, rv)
+	}
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].UtilizationPercent < report[j].UtilizationPercent
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("error writing the fleet report", "err", err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	upGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_up",
+		Help: "Whether the last poll of the vehicle succeeded. The samples of /metrics_ovms are those of the last successful poll otherwise.",
+	}, []string{"vehicle"})
+	lastSuccessGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ovms_last_success_timestamp_seconds",
+		Help: "Time of the last successful poll of the vehicle.",
+	}, []string{"vehicle"})
+)
+
+// vehicle holds the state derived from the records of one vehicle.
+type vehicle struct {
+	id string
+
+	charge        chargeTracker
+	chargeCurve   chargeCurveTracker
+	trips         tripTracker
+	efficiency    efficiencyTracker
+	degradation   degradationTracker
+	openDoors     openDetector
+	utilization   utilizationTracker
+	service       serviceTracker
+	tires         tireTracker
+	mileage       mileageLog
+	latency       latencyTracker
+	burst         burstTracker
+	stream        streamClient
+	raw           rawCapture
+	capture       debugCapture
+	notifications notificationTracker
+	alerts        alertTracker
+	wakeup        wakeupTracker
+	chargeControl chargeController
+	track         trackBuffer
+	place         placeTracker
+	distributions distributionTracker
+	history       historyBuffer
+	recordLog     recordLog
+	archive       archiveWriter
+	cells         cellTracker
+	vehicleTypes  typeTracker
+	battery12V    battery12VTracker
+	circuit       circuitBreaker
+	records       recordStore
+
+	fetchMu     sync.Mutex
+	lastFetch   time.Time
+	lastFetchOK bool
+	// lastPoll is when the last poll of the vehicle started and polling
+	// whether it is still being polled or waiting for a slot, see pollDue.
+	lastPoll time.Time
+	polling  bool
+	// codeProcessed is when the records of each code were last processed,
+	// with -poll-codes.
+	codeProcessed map[string]time.Time
+
+	// importing is set by the import command: the records only feed the
+	// history and the samples, without raising events or notifications,
+	// nor writing to the record log, the archive or the event stream.
+	importing bool
+
+	// msgTimeWarned are the codes whose implausible times were reported,
+	// and clockSkewWarned whether the skew was, only used by fetchMetrics.
+	msgTimeWarned   map[string]bool
+	clockSkewWarned bool
+}
+
+// metricsText returns the samples of the vehicle in the text format.
+func (v *vehicle) metricsText() string {
+	return v.records.text()
+}
+
+// published is called once new samples are exposed.
+func (v *vehicle) published() {
+	v.latency.published(time.Now())
+	v.updateVehicleInfo()
+	v.burst.record(v.metricsText())
+}
+
+// vehicles are the vehicles polled, in the order given by -vehicle.
+var vehicles []*vehicle
+
+func newVehicle(id string) (*vehicle, error) {
+	v := &vehicle{id: id}
+	v.charge.vehicle = id
+	v.chargeCurve.vehicle = id
+	v.trips.vehicle = id
+	v.trips.efficiency = &v.efficiency
+	v.efficiency.vehicle = id
+	v.degradation.vehicle = id
+	v.openDoors.vehicle = id
+	v.utilization.vehicle = id
+	v.service.vehicle = id
+	v.tires.vehicle = id
+	v.mileage.vehicle = id
+	v.latency.vehicle = id
+	v.distributions.vehicle = id
+	v.recordLog.vehicle = id
+	v.archive.vehicle = id
+	v.cells.vehicle = id
+	v.battery12V.vehicle = id
+	v.circuit.vehicle = id
+	v.burst.vehicle = id
+	v.capture.vehicle = id
+	v.notifications.vehicle = id
+	v.notifications.last = time.Now()
+	v.stream.vehicle = v
+	v.charge.onEvent = v.event
+	v.trips.onEvent = v.event
+	v.openDoors.onEvent = v.event
+	v.battery12V.onEvent = v.event
+	v.trips.onCompleted = v.mileage.add
+	if err := v.degradation.init(); err != nil {
+		return nil, err
+	}
+	if err := v.tires.init(); err != nil {
+		return nil, err
+	}
+	if err := v.mileage.init(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// odometerKm returns the latest odometer reading.
+func (v *vehicle) odometerKm() float64 {
+	v.trips.mu.Lock()
+	defer v.trips.mu.Unlock()
+	return v.trips.odometerKm
+}
+
+// purge erases all the data stored about the vehicle.
+func (v *vehicle) purge() error {
+	v.utilization.purge()
+	v.history.purge()
+	v.chargeCurve.purge()
+	if err := v.degradation.purge(); err != nil {
+		return err
+	}
+	if err := v.tires.purge(); err != nil {
+		return err
+	}
+	return v.mileage.purge()
+}
+
+// setFetchStatus records the outcome of a fetch.
+func (v *vehicle) setFetchStatus(ok bool) {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+	v.lastFetch = time.Now()
+	v.lastFetchOK = ok
+	if ok {
+		upGauge.WithLabelValues(v.id).Set(1)
+		lastSuccessGauge.WithLabelValues(v.id).Set(float64(v.lastFetch.UnixNano()) / 1e9)
+	} else {
+		upGauge.WithLabelValues(v.id).Set(0)
+	}
+}
+
+// fetchStatus returns the time and the status of the last fetch.
+func (v *vehicle) fetchStatus() (time.Time, string) {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+	switch {
+	case v.lastFetch.IsZero():
+		return v.lastFetch, "pending"
+	case v.lastFetchOK:
+		return v.lastFetch, "ok"
+	}
+	return v.lastFetch, "error"
+}
+
+// field returns a field of the latest record of a code, empty if unknown.
+func (v *vehicle) field(code, name string) string {
+	return v.records.field(code, name)
+}
+
+// parseVehicleIDs splits the comma-separated -vehicle flag.
+func parseVehicleIDs(s string) ([]string, error) {
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(s, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate vehicle %q", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// findVehicle returns the vehicle with the given ID or nil.
+func findVehicle(id string) *vehicle {
+	for _, v := range vehicles {
+		if v.id == id {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// vehicleConfig overrides the server, the credentials and the poll interval
+// of a vehicle of -vehicle, e.g. one registered under another OVMS account.
+type vehicleConfig struct {
+	Vehicle string `json:"vehicle"`
+	// Server is the comma-separated OVMS servers of the vehicle, failed
+	// over as -server.
+	Server   string `json:"server"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Token is an API token used in place of the password.
+	Token string `json:"token"`
+	// VehiclePassword is the vehicle (module) password of the stream
+	// connection and commands, in place of -vehicle-password.
+	VehiclePassword string `json:"vehicle_password"`
+	PollInterval    string `json:"poll_interval"`
+
+	pollInterval time.Duration
+}
+
+func (vc *vehicleConfig) validate() error {
+	if vc.Vehicle == "" {
+		return fmt.Errorf("vehicles: entry without a vehicle")
+	}
+	if vc.Server != "" && len(splitServers(vc.Server)) == 0 {
+		return fmt.Errorf("vehicles: invalid server %q of %q", vc.Server, vc.Vehicle)
+	}
+	if vc.Username == "" && (vc.Password != "" || vc.Token != "") {
+		return fmt.Errorf("vehicles: password or token of %q without a username", vc.Vehicle)
+	}
+	if vc.PollInterval != "" {
+		var err error
+		if vc.pollInterval, err = time.ParseDuration(vc.PollInterval); err != nil || vc.pollInterval <= 0 {
+			return fmt.Errorf("vehicles: invalid poll_interval %q of %q", vc.PollInterval, vc.Vehicle)
+		}
+	}
+	return nil
+}
+
+// vehicleConfig returns the settings of a vehicle, nil if none.
+func (c *config) vehicleConfig(id string) *vehicleConfig {
+	for _, vc := range c.Vehicles {
+		if vc.Vehicle == id {
+			return vc
+		}
+	}
+	return nil
+}
+
+// vehicleAuthQuery returns the query parameters authenticating the requests
+// of a vehicle: its credentials in the config if any, otherwise the flags.
+func vehicleAuthQuery(id string) url.Values {
+	vc := cfg().vehicleConfig(id)
+	if vc == nil || vc.Username == "" {
+		return authQuery()
+	}
+	password := vc.Password
+	if vc.Token != "" {
+		password = vc.Token
+	}
+	return url.Values{
+		"username": {vc.Username},
+		"password": {password},
+	}
+}
+
+// vehiclePassword returns the vehicle (module) password of a vehicle: its
+// vehicle_password in the config if any, otherwise -vehicle-password.
+func vehiclePassword(id string) string {
+	if vc := cfg().vehicleConfig(id); vc != nil && vc.VehiclePassword != "" {
+		return vc.VehiclePassword
+	}
+	return *vehiclePasswordFlag
+}
+
+// pollInterval returns the poll interval of the vehicle: its poll_interval
+// in the config, shortened by its burst, if any, otherwise the global one.
+func (v *vehicle) pollInterval() time.Duration {
+	vc := cfg().vehicleConfig(v.id)
+	if vc == nil || vc.pollInterval == 0 {
+		return pollInterval()
+	}
+	if c := cfg().Burst; c != nil && v.burst.active(time.Now()) {
+		return min(vc.pollInterval, c.pollInterval)
+	}
+	return vc.pollInterval
+}
+
+// pollDue reports whether the vehicle is to be polled, its poll interval
+// having elapsed since the last poll and the last poll being done, and marks
+// it as being polled. The poll is timed from when it starts, see
+// pollStarted.
+func (v *vehicle) pollDue(now time.Time) bool {
+	interval := v.pollInterval()
+	// The slack is shorter for the short intervals, the loop running at
+	// the shortest one.
+	slack := min(pollCodeSlack, interval/10)
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+	if v.polling || now.Sub(v.lastPoll) < interval-slack {
+		return false
+	}
+	v.polling = true
+	return true
+}
+
+// pollStarted records the start of a poll of the vehicle, once it got a slot
+// of -poll-concurrency.
+func (v *vehicle) pollStarted(now time.Time) {
+	v.fetchMu.Lock()
+	defer v.fetchMu.Unlock()
+	v.lastPoll = now
+}
+
+// redacted returns a copy of the config without the credentials of the
+// vehicles and of the notifiers, for handleConfig.
+func (c *config) redacted() *config {
+	redact := func(s *string) {
+		if *s != "" {
+			*s = "REDACTED"
+		}
+	}
+	r := *c
+	r.Notifiers = make([]*notifier, len(c.Notifiers))
+	for i, n := range c.Notifiers {
+		n := *n
+		redact(&n.BotToken)
+		redact(&n.AppToken)
+		redact(&n.UserKey)
+		redact(&n.Password)
+		n.URL = redactURL(n.URL)
+		r.Notifiers[i] = &n
+	}
+	r.Vehicles = make([]*vehicleConfig, len(c.Vehicles))
+	for i, vc := range c.Vehicles {
+		vc := *vc
+		redact(&vc.Password)
+		redact(&vc.Token)
+		redact(&vc.VehiclePassword)
+		r.Vehicles[i] = &vc
+	}
+	return &r
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Set at build time with:
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// Unset values are taken from the build info embedded by the Go toolchain.
+var (
+	version = ""
+	commit  = ""
+	date    = ""
+)
+
+var versionFlag = flag.Bool("version", false, "Print the version and exit")
+
+var buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "ovms_exporter_build_info",
+	Help: "A metric with a constant '1' value labeled by version, revision, build date and goversion.",
+}, []string{"version", "revision", "date", "goversion"})
+
+func init() {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if version == "" && bi.Main.Version != "" {
+			version = bi.Main.Version
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && commit == "":
+				commit = s.Value
+			case s.Key == "vcs.time" && date == "":
+				date = s.Value
+			}
+		}
+	}
+	if version == "" {
+		version = "unknown"
+	}
+	buildInfo.WithLabelValues(version, commit, date, runtime.Version()).Set(1)
+}
+
+func versionString() string {
+	return fmt.Sprintf("ovms_exporter %s (revision %s, built %s, %s)", version, commit, date, runtime.Version())
+}
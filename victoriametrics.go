@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var victoriaMetricsURLFlag = flag.String("victoriametrics-url", "", "VictoriaMetrics URL, e.g. http://localhost:8428, to which the samples of the new records are sent with their timestamps, to <url>/api/v1/import; also used by the import command; empty disables it")
+
+var vmImportFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "ovms_victoriametrics_import_failures_total",
+	Help: "Number of failed requests to the VictoriaMetrics import API.",
+})
+
+// vmLine is a line of the JSON line format of /api/v1/import.
+type vmLine struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// vmImport sends the series to the VictoriaMetrics import API.
+func vmImport(baseURL string, series []*remoteSeries) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, s := range series {
+		line := vmLine{Metric: map[string]string{}}
+		for _, l := range s.labels {
+			line.Metric[l.GetName()] = l.GetValue()
+		}
+		for _, sample := range s.samples {
+			line.Values = append(line.Values, sample.value)
+			line.Timestamps = append(line.Timestamps, sample.tsMs)
+		}
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(strings.TrimSuffix(baseURL, "/")+"/api/v1/import", "application/json", &body)
+	if err != nil {
+		vmImportFailures.Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		vmImportFailures.Inc()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("VictoriaMetrics import: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// vmSink sends the samples of the records not sent yet.
+type vmSink struct {
+	mu sync.Mutex
+	// sent is the time of the last record sent by vehicle and code.
+	sent map[string]time.Time
+}
+
+var victoriaMetrics vmSink
+
+// push sends the records of the vehicles newer than the ones sent. The
+// records not sent because of an error are retried by the next push, if
+// still the latest.
+func (s *vmSink) push() {
+	if *victoriaMetricsURLFlag == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sent == nil {
+		s.sent = map[string]time.Time{}
+	}
+	var b strings.Builder
+	pending := map[string]time.Time{}
+	for _, v := range vehicles {
+		for code, rec := range v.records.snapshot() {
+			key := v.id + "/" + code
+			if !rec.ts.After(s.sent[key]) {
+				continue
+			}
+			pending[key] = rec.ts
+			for _, sample := range rec.samples {
+				b.WriteString(sample)
+				b.WriteByte('\n')
+			}
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	series, err := parseRemoteSeries(b.String())
+	if err == nil {
+		err = vmImport(*victoriaMetricsURLFlag, series)
+	}
+	if err != nil {
+		slog.Error("error sending to VictoriaMetrics", "url", redactURL(*victoriaMetricsURLFlag), "err", err)
+		return
+	}
+	for key, ts := range pending {
+		s.sent[key] = ts
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	wakeupBeforePollFlag = flag.Duration("wakeup-before-poll", 0, "Send the wakeup command before polling a vehicle whose latest record is older than this, at most once per this duration; needs -vehicle-password or the vehicle_password of each vehicle; 0 disables it")
+	wakeupWaitFlag       = flag.Duration("wakeup-wait", 30*time.Second, "Time to wait after a wakeup before polling, for the module to send fresh records")
+)
+
+var wakeupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "ovms_wakeups_total",
+	Help: "Number of wakeup commands sent before polling, by result.",
+}, []string{"vehicle", "result"})
+
+func setupWakeup() error {
+	if *wakeupBeforePollFlag <= 0 {
+		return nil
+	}
+	for _, v := range vehicles {
+		if vehiclePassword(v.id) == "" {
+			return fmt.Errorf("-wakeup-before-poll needs -vehicle-password or the vehicle_password of %s", v.id)
+		}
+	}
+	return nil
+}
+
+// wakeupTracker limits the wakeups of a vehicle.
+type wakeupTracker struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+// wakeupIfStale sends the wakeup command if the latest record of the vehicle
+// is older than -wakeup-before-poll, reporting whether it was accepted.
+func (v *vehicle) wakeupIfStale(now time.Time) bool {
+	if *wakeupBeforePollFlag <= 0 {
+		return false
+	}
+	last := v.records.lastMessage()
+	if !last.IsZero() && now.Sub(last) < *wakeupBeforePollFlag {
+		return false
+	}
+	v.wakeup.mu.Lock()
+	if now.Sub(v.wakeup.last) < *wakeupBeforePollFlag {
+		v.wakeup.mu.Unlock()
+		return false
+	}
+	v.wakeup.last = now
+	v.wakeup.mu.Unlock()
+
+	slog.Info("waking up the vehicle", "vehicle", v.id, "last_record", last)
+	if _, err := sendCommand(v.id, "wakeup", nil); err != nil {
+		wakeupsTotal.WithLabelValues(v.id, "error").Inc()
+		slog.Error("error waking up the vehicle", "vehicle", v.id, "err", err)
+		return false
+	}
+	wakeupsTotal.WithLabelValues(v.id, "success").Inc()
+	return true
+}
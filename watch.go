@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const watchUsage = `usage: ovms_exporter [flags] watch [-interval d]
+
+Shows a live view of the SOC, range, charge power and connection status of
+each -vehicle in the terminal, polling the server like the exporter.
+
+Flags:`
+
+// watchVehicle is the state of a vehicle shown by the watch command.
+type watchVehicle struct {
+	ok      bool
+	elapsed time.Duration
+	fields  map[string]map[string]string
+	latest  time.Time
+}
+
+// poll fetches the records of a vehicle, without updating any metric.
+func (w *watchVehicle) poll(vehicleID string) {
+	start := time.Now()
+	fields := map[string]map[string]string{}
+	var latest time.Time
+	w.ok, _, _, _ = fetch(vehicleID, nil, func(rec record) {
+		m, ok := metricsMap[rec.Code]
+		if !ok {
+			return
+		}
+		data := strings.Split(rec.Msg, ",")
+		for i := range data {
+			if i < len(m) {
+				data[i] = normalizeField(m[i], data[i])
+			}
+		}
+		fields[rec.Code] = recordFields(m, data)
+		if ts, err := parseMsgTime(rec.MsgTime); err == nil && ts.After(latest) {
+			latest = ts
+		}
+	})
+	w.elapsed = time.Since(start)
+	if w.ok {
+		w.fields, w.latest = fields, latest
+	}
+}
+
+// row returns the columns of the vehicle in the watch table.
+func (w *watchVehicle) row(vehicleID string, now time.Time) []string {
+	status := "ok"
+	if !w.ok {
+		status = "error"
+	}
+	s := w.fields["S"]
+	if s == nil {
+		return []string{vehicleID, status, "-", "-", "-", "-", "-"}
+	}
+	units := "km"
+	if s["m_units_distance"] == "M" {
+		units = "mi"
+	}
+	power := "-"
+	if isCharging(s["ms_v_charge_state"]) {
+		power = s["ms_v_charge_power"] + " kW"
+	}
+	updated := "-"
+	if !w.latest.IsZero() {
+		updated = now.Sub(w.latest).Truncate(time.Second).String() + " ago"
+	}
+	return []string{
+		vehicleID,
+		fmt.Sprintf("%s (%s)", status, w.elapsed.Round(time.Millisecond)),
+		s["ms_v_bat_soc"] + "%",
+		s["ms_v_bat_range_est"] + " " + units,
+		s["ms_v_charge_state"],
+		power,
+		updated,
+	}
+}
+
+// renderWatch writes the watch table, clearing the terminal first.
+func renderWatch(out io.Writer, ids []string, state map[string]*watchVehicle, now time.Time) {
+	// Move the cursor home and clear the screen.
+	fmt.Fprint(out, "\x1b[H\x1b[2J")
+	fmt.Fprintf(out, "%s  %s  (Ctrl-C to quit)\n\n", currentServer(), now.Format(time.DateTime))
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VEHICLE\tSTATUS\tSOC\tRANGE\tCHARGE\tPOWER\tUPDATED")
+	for _, id := range ids {
+		fmt.Fprintln(tw, strings.Join(state[id].row(id, now), "\t"))
+	}
+	tw.Flush()
+}
+
+// watchCommand returns the flags of the watch command and its function.
+func watchCommand() (*flag.FlagSet, func(args []string) error) {
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	interval := fs.Duration("interval", 10*time.Second, "How frequently to poll the server and refresh the view")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), watchUsage)
+		fs.PrintDefaults()
+	}
+	return fs, func(args []string) error {
+		if len(args) > 0 || *interval <= 0 {
+			fs.Usage()
+			return fmt.Errorf("invalid arguments")
+		}
+		ids, err := parseVehicleIDs(*vehicleIDFlag)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return fmt.Errorf("no -vehicle to watch")
+		}
+
+		// The errors are shown in the view, the logs would garble it.
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+		state := map[string]*watchVehicle{}
+		for _, id := range ids {
+			state[id] = &watchVehicle{}
+		}
+		for {
+			for _, id := range ids {
+				state[id].poll(id)
+			}
+			renderWatch(os.Stdout, ids, state, time.Now())
+			time.Sleep(*interval)
+		}
+	}
+}